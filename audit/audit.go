@@ -0,0 +1,129 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/garm/config"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+const (
+	// stdout represents the audit log path for standard output.
+	stdout = "stdout"
+
+	// megabyte is the unit of config.Audit.MaxSize.
+	megabyte = 1024 * 1024
+)
+
+// Record represents the audit record of a webhook request.
+type Record struct {
+	// Time represents the time when the request is received.
+	Time time.Time `json:"time"`
+	// RequestID represents the ID of the request, the same as the request ID prefix of the webhook logs.
+	RequestID string `json:"request_id"`
+	// Endpoint represents the webhook endpoint, "authn" or "authz".
+	Endpoint string `json:"endpoint"`
+	// Profile represents the selected mapping profile, empty for the default profile.
+	Profile string `json:"profile,omitempty"`
+	// User represents the K8s user of the SubjectAccessReview, or the authenticated user of the TokenReview.
+	User string `json:"user,omitempty"`
+	// Groups represents the K8s groups of the user.
+	Groups []string `json:"groups,omitempty"`
+	// ResourceAttributes represents the resource attributes of the SubjectAccessReview.
+	ResourceAttributes *authz.ResourceAttributes `json:"resource_attributes,omitempty"`
+	// NonResourceAttributes represents the non-resource attributes of the SubjectAccessReview.
+	NonResourceAttributes *authz.NonResourceAttributes `json:"non_resource_attributes,omitempty"`
+	// Identity represents the Athenz principal mapped from the K8s user.
+	Identity string `json:"identity,omitempty"`
+	// AccessChecks represents the Athenz access checks, in format "${action} on ${resource}".
+	AccessChecks []string `json:"access_checks,omitempty"`
+	// Action represents the action applied to the request, e.g. "deny" or "admin-domain".
+	Action string `json:"action,omitempty"`
+	// MatchedRules represents the matched rules, "white_list", "black_list", "admin_access_list", "rules[${index}]" or "default_action".
+	MatchedRules []string `json:"matched_rules,omitempty"`
+	// Cached represents the decision is answered from the decision cache.
+	Cached bool `json:"cached,omitempty"`
+	// LocalPolicy represents the decision is answered from the Athenz policies evaluated locally.
+	LocalPolicy bool `json:"local_policy,omitempty"`
+	// Decision represents the final decision, "allowed", "denied", "blacklisted", "error" or "timeout".
+	Decision string `json:"decision"`
+	// Reason represents the reason of the decision returned to K8s.
+	Reason string `json:"reason,omitempty"`
+	// Error represents the evaluation error of the decision.
+	Error string `json:"error,omitempty"`
+	// LatencySeconds represents the request handling duration in seconds.
+	LatencySeconds float64 `json:"latency_seconds"`
+}
+
+// Auditor represents an interface to write audit records.
+type Auditor interface {
+	// Audit writes the record.
+	Audit(Record) error
+}
+
+type auditor struct {
+	// mu serializes the writes, one record per line.
+	mu sync.Mutex
+	// w is the output destination.
+	w io.Writer
+}
+
+// New returns an Auditor writing to the destination of the given configuration.
+// If cfg.Path is empty or "stdout", it writes to standard output, otherwise it writes to the file and rotates the file by cfg.MaxSize.
+func New(cfg config.Audit) (Auditor, error) {
+	path := config.GetActualValue(cfg.Path)
+	if path == "" || path == stdout {
+		return NewWithWriter(os.Stdout), nil
+	}
+
+	f, err := openRotatingFile(path, int64(cfg.MaxSize)*megabyte, cfg.MaxBackups)
+	if err != nil {
+		return nil, errors.Wrap(err, "audit log open failed")
+	}
+	return NewWithWriter(f), nil
+}
+
+// NewWithWriter returns an Auditor writing to w.
+func NewWithWriter(w io.Writer) Auditor {
+	return &auditor{
+		w: w,
+	}
+}
+
+// Audit writes the record as one line of JSON.
+func (a *auditor) Audit(r Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "audit record marshal failed")
+	}
+	b = append(b, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.w.Write(b)
+	if err != nil {
+		return errors.Wrap(err, "audit record write failed")
+	}
+	return nil
+}
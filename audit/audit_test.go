@@ -0,0 +1,139 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yahoojapan/garm/config"
+)
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write error")
+}
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		cfg     config.Audit
+		wantErr bool
+	}{
+		{
+			name: "Check empty path writes to stdout",
+			cfg:  config.Audit{},
+		},
+		{
+			name: "Check stdout path",
+			cfg: config.Audit{
+				Path: "stdout",
+			},
+		},
+		{
+			name: "Check file path",
+			cfg: config.Audit{
+				Path:    filepath.Join(dir, "audit.log"),
+				MaxSize: 1,
+			},
+		},
+		{
+			name: "Check file open fail",
+			cfg: config.Audit{
+				Path: filepath.Join(dir, "notexists", "audit.log"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got == nil {
+				t.Error("New() returns nil")
+			}
+		})
+	}
+}
+
+func Test_auditor_Audit(t *testing.T) {
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		record  Record
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Check record is written as one line",
+			record: Record{
+				Time:           at,
+				RequestID:      "abcdefgh",
+				Endpoint:       "authz",
+				User:           "user",
+				Identity:       "athenz.user",
+				AccessChecks:   []string{"get on k8s:pods"},
+				Decision:       "allowed",
+				LatencySeconds: 0.5,
+			},
+			want: `{"time":"2020-01-02T03:04:05Z","request_id":"abcdefgh","endpoint":"authz","user":"user","identity":"athenz.user","access_checks":["get on k8s:pods"],"decision":"allowed","latency_seconds":0.5}` + "\n",
+		},
+		{
+			name: "Check empty fields are omitted",
+			record: Record{
+				Time:     at,
+				Endpoint: "authn",
+				Decision: "timeout",
+			},
+			want: `{"time":"2020-01-02T03:04:05Z","request_id":"","endpoint":"authn","decision":"timeout","latency_seconds":0}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			err := NewWithWriter(buf).Audit(tt.record)
+			if err != nil {
+				t.Errorf("Audit() unexpected error: %v", err)
+				return
+			}
+			if got := buf.String(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Audit() wrote %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	err := NewWithWriter(errWriter{}).Audit(Record{})
+	if err == nil || err.Error() != "audit record write failed: write error" {
+		t.Errorf("Audit() error = %v, want write error", err)
+	}
+}
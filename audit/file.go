@@ -0,0 +1,118 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// rotatingFile is an io.Writer writing to a file, which is rotated when its size exceeds maxSize.
+// The rotated files are renamed to "${path}.1", "${path}.2" ... "${path}.${maxBackups}", the larger number is the older.
+type rotatingFile struct {
+	mu sync.Mutex
+	// path is the file path.
+	path string
+	// maxSize is the maximum file size in bytes, 0 disables rotation.
+	maxSize int64
+	// maxBackups is the maximum number of rotated files to keep.
+	maxBackups int
+	// file is the current file.
+	file *os.File
+	// size is the current file size in bytes.
+	size int64
+}
+
+// openRotatingFile opens the file for appending, and returns a rotatingFile.
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write writes b to the file, and rotates the file before writing if the file size will exceed maxSize.
+func (f *rotatingFile) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(b)) > f.maxSize {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+// open opens the file for appending, and gets the current file size.
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrap(err, "file open failed")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "file stat failed")
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate closes the current file, shifts the rotated files, and opens a new file.
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return errors.Wrap(err, "file close failed")
+	}
+
+	if f.maxBackups > 0 {
+		for i := f.maxBackups - 1; i > 0; i-- {
+			err = os.Rename(f.backup(i), f.backup(i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "backup file rename failed")
+			}
+		}
+		err = os.Rename(f.path, f.backup(1))
+	} else {
+		err = os.Remove(f.path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "file rotate failed")
+	}
+
+	return f.open()
+}
+
+// backup returns the path of the i-th rotated file.
+func (f *rotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_rotatingFile_Write(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxSize    int64
+		maxBackups int
+		writes     []string
+		want       map[string]string
+	}{
+		{
+			name:       "Check no rotation",
+			maxSize:    0,
+			maxBackups: 1,
+			writes:     []string{"a\n", "b\n", "c\n"},
+			want: map[string]string{
+				"audit.log": "a\nb\nc\n",
+			},
+		},
+		{
+			name:       "Check rotation keeps backups",
+			maxSize:    4,
+			maxBackups: 2,
+			writes:     []string{"a\n", "b\n", "c\n", "d\n", "e\n", "f\n", "g\n"},
+			want: map[string]string{
+				"audit.log":   "g\n",
+				"audit.log.1": "e\nf\n",
+				"audit.log.2": "c\nd\n",
+			},
+		},
+		{
+			name:       "Check rotation without backups",
+			maxSize:    4,
+			maxBackups: 0,
+			writes:     []string{"a\n", "b\n", "c\n"},
+			want: map[string]string{
+				"audit.log": "c\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "garm")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			f, err := openRotatingFile(filepath.Join(dir, "audit.log"), tt.maxSize, tt.maxBackups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.writes {
+				if _, err := f.Write([]byte(w)); err != nil {
+					t.Errorf("Write() unexpected error: %v", err)
+				}
+			}
+
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != len(tt.want) {
+				t.Errorf("Write() created %d files, want %d", len(files), len(tt.want))
+			}
+			for name, want := range tt.want {
+				got, err := ioutil.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Errorf("%s read error: %v", name, err)
+					continue
+				}
+				if string(got) != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_openRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	if err := ioutil.WriteFile(path, []byte("exists\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := openRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.size != 7 {
+		t.Errorf("openRotatingFile() size = %d, want %d", f.size, 7)
+	}
+}
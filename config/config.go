@@ -17,6 +17,9 @@ limitations under the License.
 package config
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"regexp"
 	"strings"
@@ -30,6 +33,53 @@ import (
 const (
 	// currentVersion represents the configuration version.
 	currentVersion = "v2.0.0"
+
+	// defaultMetricsPath represents the default API path (pattern) for Prometheus metrics on health check server.
+	defaultMetricsPath = "/metrics"
+
+	// defaultLivenessPath represents the default API path (pattern) for liveness probe on health check server.
+	defaultLivenessPath = "/livez"
+
+	// defaultReadinessPath represents the default API path (pattern) for readiness probe on health check server.
+	defaultReadinessPath = "/readyz"
+
+	// defaultAthenzProbePath represents the default API path under the Athenz URL requested by the Athenz probe.
+	defaultAthenzProbePath = "/status"
+
+	// regexPrefix represents the prefix of the regular expression form of a RequestInfo field pattern.
+	regexPrefix = "regex:"
+
+	// MatchPlaceholder is replaced with the part of the K8s namespace matched by the NamespaceDomain pattern.
+	MatchPlaceholder = "_match_"
+
+	// defaultDenialMessage represents the default template of the reason returned to K8s for the rejected authorization requests.
+	defaultDenialMessage = "_reason_"
+
+	// defaultJWKSPath represents the default API path under the Athenz URL serving the ZTS JSON Web Key Set.
+	defaultJWKSPath = "/oauth2/keys?rfc=true"
+
+	// defaultRoleGroup represents the default template of the K8s group of each role in the Athenz access token, also used to find the roles of the principal in local policy evaluation.
+	defaultRoleGroup = "_domain_:role._role_"
+
+	// defaultUserTemplate represents the default template of the K8s username and UID of the authenticated Athenz principal.
+	defaultUserTemplate = "_principal_"
+)
+
+const (
+	// ActionAllowBypassAthenz represents the rule action allowing the K8s webhook request directly without querying Athenz.
+	ActionAllowBypassAthenz = "allow-bypass-athenz"
+
+	// ActionDeny represents the rule action rejecting the K8s webhook request directly without querying Athenz.
+	ActionDeny = "deny"
+
+	// ActionAdminDomain represents the rule action checking the K8s webhook request against the admin domain and the service domains in Athenz.
+	ActionAdminDomain = "admin-domain"
+
+	// ActionDefaultDomain represents the rule action checking the K8s webhook request against the service domains in Athenz.
+	ActionDefaultDomain = "default-domain"
+
+	// ActionCustomDomain represents the rule action checking the K8s webhook request against the domains of the rule in Athenz.
+	ActionCustomDomain = "custom-domain"
 )
 
 // Config represents an application configuration content (config.yaml).
@@ -55,6 +105,39 @@ type Config struct {
 
 	// Mapping represents the mapping rule for mapping K8s authentication and authorization requests to Athenz requests.
 	Mapping Mapping `yaml:"map_rule"`
+
+	// Reload represents the configuration for reloading the mapping rules without restarting Garm.
+	Reload Reload `yaml:"reload"`
+
+	// Audit represents the audit log configuration.
+	Audit Audit `yaml:"audit"`
+
+	// FilePath represents the path of the loaded configuration file. It is set by New, and not read from YAML.
+	FilePath string `yaml:"-"`
+}
+
+// Reload represents the configuration for reloading the mapping rules without restarting Garm.
+type Reload struct {
+	// Enabled represents if Garm should watch the configuration file and reload "map_rule" on change.
+	Enabled bool `yaml:"enabled"`
+
+	// Interval represents the duration between each configuration file check.
+	Interval string `yaml:"interval"`
+}
+
+// Audit represents the audit log configuration. Each webhook request is written as one JSON record.
+type Audit struct {
+	// Enabled represents the audit log is written or not.
+	Enabled bool `yaml:"enabled"`
+
+	// Path represents the audit log file path. Empty or "stdout" writes to standard output.
+	Path string `yaml:"path"`
+
+	// MaxSize represents the maximum size in megabytes of the audit log file before rotation. 0 disables rotation.
+	MaxSize int `yaml:"max_size"`
+
+	// MaxBackups represents the maximum number of rotated audit log files to keep.
+	MaxBackups int `yaml:"max_backups"`
 }
 
 // Logger represents logging configuration for Garm.
@@ -78,6 +161,18 @@ type Server struct {
 	// HealthzPath represents the API path (pattern) for health check server.
 	HealthzPath string `yaml:"health_check_path"`
 
+	// MetricsPath represents the API path (pattern) for Prometheus metrics on health check server. Default is "/metrics".
+	MetricsPath string `yaml:"metrics_path"`
+
+	// LivenessPath represents the API path (pattern) for liveness probe on health check server. Default is "/livez".
+	LivenessPath string `yaml:"liveness_path"`
+
+	// ReadinessPath represents the API path (pattern) for readiness probe on health check server. Default is "/readyz".
+	ReadinessPath string `yaml:"readiness_path"`
+
+	// Readiness represents the readiness probe configuration.
+	Readiness Readiness `yaml:"readiness"`
+
 	// Timeout represents the maximum webhook server request handling duration.
 	Timeout string `yaml:"timeout"`
 
@@ -91,6 +186,18 @@ type Server struct {
 	TLS TLS `yaml:"tls"`
 }
 
+// Readiness represents the readiness probe configuration.
+type Readiness struct {
+	// AthenzProbe represents the readiness probe also requires the Athenz server to be reachable or not.
+	AthenzProbe bool `yaml:"athenz_probe"`
+
+	// AthenzProbePath represents the API path under the Athenz URL requested by the Athenz probe. Default is "/status".
+	AthenzProbePath string `yaml:"athenz_probe_path"`
+
+	// AthenzProbeInterval represents the duration between each Athenz probe.
+	AthenzProbeInterval string `yaml:"athenz_probe_interval"`
+}
+
 // TLS represents the TLS configuration for webhook server.
 type TLS struct {
 	// Enable represents the webhook server enable TLS or not.
@@ -120,6 +227,21 @@ type Athenz struct {
 	// AthenzRootCA is the Athenz root CA certificate file path for connecting to Athenz.
 	AthenzRootCA string `yaml:"root_ca"`
 
+	// Cache represents the authorization decision cache configuration.
+	Cache Cache `yaml:"cache"`
+
+	// Denial represents the reason returned to K8s for the rejected authorization requests.
+	Denial Denial `yaml:"denial"`
+
+	// AccessToken represents the local verification configuration of the Athenz access tokens for authentication.
+	AccessToken AccessToken `yaml:"access_token"`
+
+	// Certificate represents the authentication configuration of the assertions signed with the Athenz X.509 service certificates.
+	Certificate Certificate `yaml:"certificate"`
+
+	// Policy represents the local evaluation configuration of the Athenz policies.
+	Policy Policy `yaml:"policy"`
+
 	// AuthN represents the authentication configuration.
 	AuthN webhook.AuthenticationConfig
 
@@ -130,6 +252,89 @@ type Athenz struct {
 	Config webhook.Config
 }
 
+// Denial represents the reason returned to K8s for the rejected authorization requests, which is shown to the users in kubectl errors.
+// The reason is replaced only if Message or HelpMessage is set.
+type Denial struct {
+	// Message represents the template of the reason of the requests rejected by Garm directly or denied by Athenz. Default is "_reason_".
+	// "_reason_", "_user_", "_principal_", "_verb_", "_namespace_", "_api_group_", "_resource_", "_subresource_" and "_name_" are replaced.
+	Message string `yaml:"message"`
+
+	// HelpMessage represents the message appended to the reason of every rejected request, e.g. the link to the access request portal.
+	HelpMessage string `yaml:"help_message"`
+}
+
+// AccessToken represents the configuration to verify the Athenz access tokens (JWT) locally with the public keys of ZTS.
+// The TokenReview of an access token is answered by Garm directly, other tokens (n-token) are authenticated by Athenz.
+type AccessToken struct {
+	// Enabled represents the access tokens are verified locally or not.
+	Enabled bool `yaml:"enabled"`
+
+	// JWKSURL represents the URL of the ZTS JSON Web Key Set. Default is "${athenz.url}/oauth2/keys?rfc=true".
+	JWKSURL string `yaml:"jwks_url"`
+
+	// RefreshDuration represents the duration between each JSON Web Key Set refresh. The keys are also refreshed on an unknown key ID.
+	RefreshDuration string `yaml:"refresh_duration"`
+
+	// Issuer represents the expected "iss" claim, e.g. "https://zts.athenz.io/zts/v1". It is required.
+	Issuer string `yaml:"issuer"`
+
+	// Audiences represents the accepted "aud" claims, i.e. the Athenz domains of the roles in the access token. At least one audience is required.
+	Audiences []string `yaml:"audiences"`
+
+	// RoleGroup represents the template of the K8s group of each role in the access token. Default is "_domain_:role._role_".
+	// "_domain_" is replaced with the audience and "_role_" is replaced with the role name.
+	RoleGroup string `yaml:"role_group"`
+}
+
+// Certificate represents the configuration to authenticate the workloads with the Athenz X.509 service certificates (Copper Argos).
+// The bearer token is a JWT assertion signed with the private key of the certificate, and the certificate chain is in its "x5c" header.
+// The certificate is verified against AthenzRootCA only, and its SPIFFE URI SAN or CN is the Athenz principal.
+type Certificate struct {
+	// Enabled represents the certificate assertions are authenticated or not.
+	Enabled bool `yaml:"enabled"`
+
+	// Audience represents the expected "aud" claim of the assertion, e.g. "https://garm.example.com".
+	Audience string `yaml:"audience"`
+
+	// MaxLifetime represents the maximum duration between the "iat" and "exp" claims of the assertion.
+	MaxLifetime string `yaml:"max_lifetime"`
+}
+
+// Policy represents the configuration to evaluate the Athenz access checks locally (ZPE), with the signed policies fetched from ZTS.
+// The roles of the principal are taken only from the access tokens verified by Garm (see AccessToken).
+// The access checks which cannot be evaluated locally are sent to Athenz.
+type Policy struct {
+	// Enabled represents the Athenz policies are evaluated locally or not.
+	Enabled bool `yaml:"enabled"`
+
+	// Domains represents the Athenz domains to fetch the signed policies.
+	Domains []string `yaml:"domains"`
+
+	// RefreshDuration represents the duration between each signed policy fetch.
+	RefreshDuration string `yaml:"refresh_duration"`
+
+	// ZTSPublicKeys represents the PEM file paths of the ZTS public keys by key ID, verifying the ZTS signature of the signed policies.
+	ZTSPublicKeys map[string]string `yaml:"zts_public_keys"`
+
+	// ZMSPublicKeys represents the PEM file paths of the ZMS public keys by key ID, verifying the ZMS signature of the policies.
+	ZMSPublicKeys map[string]string `yaml:"zms_public_keys"`
+}
+
+// Cache represents the in-process cache configuration of authorization decisions.
+type Cache struct {
+	// Enabled represents the authorization decisions are cached or not.
+	Enabled bool `yaml:"enabled"`
+
+	// AllowTTL represents the duration to cache an allowed decision.
+	AllowTTL string `yaml:"allow_ttl"`
+
+	// DenyTTL represents the duration to cache a decision denied by Athenz. Set "0s" to disable negative caching.
+	DenyTTL string `yaml:"deny_ttl"`
+
+	// Size represents the maximum number of cached decisions. The least recently used decision is evicted when the cache is full.
+	Size int `yaml:"size"`
+}
+
 // Token represents the token generation details or the n-token file for Copper Argos.
 type Token struct {
 	// AthenzDomain represents the Athenz domain value to generate the n-token.
@@ -159,8 +364,17 @@ type Token struct {
 
 // Mapping represents the mapping rules from K8s authentication and authorization requests to Athenz requests.
 type Mapping struct {
-	// TLD represents the mapping rules for each Top Level Domain.
+	// TLD represents the mapping rules for each Top Level Domain. It is the default profile used if no profile is selected.
 	TLD TLD `yaml:"tld"`
+
+	// Profiles represents the named mapping rules, selected per request by the URL path "/authz/${name}" (or "/authn/${name}"), ProfileHeader or ProfileExtraKey.
+	Profiles map[string]TLD `yaml:"profiles"`
+
+	// ProfileHeader represents the HTTP request header selecting the profile. Empty disables the selection by header.
+	ProfileHeader string `yaml:"profile_header"`
+
+	// ProfileExtraKey represents the key of the SubjectAccessReview "extra" selecting the profile. Empty disables the selection by extra.
+	ProfileExtraKey string `yaml:"profile_extra_key"`
 }
 
 // TLD represents the mapping rules for each Top Level Domain.
@@ -180,9 +394,19 @@ type Platform struct {
 	// ServiceAthenzDomains represents the Athenz domain name used for non-administrative K8s webhook requests.
 	ServiceAthenzDomains []string `yaml:"service_athenz_domains"`
 
+	// NamespaceDomains represents the ordered overrides of ServiceAthenzDomains for the matched K8s namespaces. The first matched override is used.
+	NamespaceDomains []*NamespaceDomain `yaml:"namespace_domains"`
+
 	// ResourceMappings maps the K8s webhook request "resource" to the "resource" part of Athenz resource name.
 	ResourceMappings map[string]string `yaml:"resource_mappings,omitempty"`
 
+	// SubresourceMappings maps the K8s webhook request "subresource" before it is joined with the "resource".
+	// The key is "${resource}/${subresource}", or "${subresource}" for any resource. Mapped to "" removes the subresource.
+	SubresourceMappings map[string]string `yaml:"subresource_mappings,omitempty"`
+
+	// SubresourceAsAction moves the mapped subresource to the Athenz action, e.g. "create" on "pods/exec" is mapped to "exec" on "pods".
+	SubresourceAsAction bool `yaml:"subresource_as_action"`
+
 	// VerbMappings maps the K8s webhook request "verb" to the "verb" part of Athenz resource name.
 	VerbMappings map[string]string `yaml:"verb_mappings,omitempty"`
 
@@ -204,6 +428,10 @@ type Platform struct {
 	// ResourceNameReplacer represents the replacer to replace ":" or some values to another string.
 	ResourceNameReplacer map[string]string `yaml:"resource_name_replacer"`
 
+	// ResourceTemplate represents the template of the Athenz resource in the Athenz access checks. Empty uses the fixed layout "${domain}:${api_group}.${resource}.${name}".
+	// "_domain_", "_service_domain_", "_namespace_", "_api_group_", "_resource_", "_subresource_", "_name_" and "_verb_" are replaced.
+	ResourceTemplate string `yaml:"resource_template"`
+
 	// NonResourceAPIGroup represents the API group value (Athenz resource name) used for K8s non-resource webhook requests.
 	NonResourceAPIGroup string `yaml:"non_resource_api_group"`
 
@@ -230,6 +458,143 @@ type Platform struct {
 
 	// Blacklist represents the list of blacklist K8s webhook request patterns. These requests will always rejected by Garm directly.
 	BlackList []*RequestInfo `yaml:"black_list"`
+
+	// WhiteListLocalAllow allows the K8s webhook requests matching WhiteList directly without querying Athenz.
+	WhiteListLocalAllow bool `yaml:"white_list_local_allow"`
+
+	// LegacyListMatch enables the deprecated matching of AdminAccessList, WhiteList and BlackList, which matches the dash-joined fields without anchors (see RequestInfo.LegacyMatch).
+	LegacyListMatch bool `yaml:"legacy_list_match"`
+
+	// Rules represents the ordered request filtering rules. The action of the first matched rule is applied.
+	// The rules are enabled by DefaultAction, and cannot be used together with AdminAccessList, WhiteList and BlackList.
+	Rules []*Rule `yaml:"rules"`
+
+	// DefaultAction represents the action applied to the K8s webhook requests not matching any of Rules. Empty disables Rules.
+	DefaultAction string `yaml:"default_action"`
+
+	// GroupMappings represents the list of rules assigning K8s groups to the authenticated Athenz principals.
+	GroupMappings []*GroupMapping `yaml:"group_mappings"`
+
+	// UserMapping represents the templates of the K8s user information of the authenticated Athenz principals.
+	UserMapping UserMapping `yaml:"user_mapping"`
+
+	// EKS represents the mapping rules specific to Amazon EKS platform.
+	EKS EKS `yaml:"eks"`
+
+	// AKS represents the mapping rules specific to Azure AKS platform.
+	AKS AKS `yaml:"aks"`
+}
+
+// AKS represents the mapping rules of the Azure AD users and groups, which are UPNs or object IDs.
+type AKS struct {
+	// UserMappings maps the Azure AD user (UPN or object ID) to the Athenz principal.
+	UserMappings map[string]string `yaml:"user_mappings"`
+
+	// UPNDomainMappings maps the domain part of the UPN to the Athenz domain, e.g. "alice@corp.onmicrosoft.com" is mapped to "${Athenz domain}.alice".
+	UPNDomainMappings map[string]string `yaml:"upn_domain_mappings"`
+
+	// GroupNames maps the Azure AD group object ID to the group name used by the mapping rules.
+	GroupNames map[string]string `yaml:"group_names"`
+}
+
+// EKS represents the mapping rules of the EKS users, which are IAM role/user ARNs or the usernames mapped by aws-auth.
+type EKS struct {
+	// UserMappings maps the EKS username to the Athenz principal. An IAM role ARN also matches the sessions assuming the role.
+	UserMappings map[string]string `yaml:"user_mappings"`
+
+	// ARNTemplate represents the template of the Athenz principal for the IAM ARNs not in UserMappings.
+	// "_account_", "_type_" ("role" or "user"), "_name_" and "_session_" are replaced. Empty passes the ARN as it is.
+	ARNTemplate string `yaml:"arn_template"`
+
+	// SystemGroupMappings maps the EKS system groups (e.g. "system:nodes") to the Athenz principal.
+	SystemGroupMappings map[string]string `yaml:"system_group_mappings"`
+}
+
+// UserMapping represents the templates of the K8s user information of the authenticated Athenz principals.
+// The following placeholders are replaced in the templates.
+// "_principal_": the Athenz principal, "${domain}.${service}"
+// "_domain_": the Athenz domain
+// "_service_": the Athenz service
+// "_user_": the Athenz principal without AthenzUserPrefix, the inverse of the user mapping in authorization
+type UserMapping struct {
+	// Username represents the template of the K8s username. Default is "_principal_".
+	Username string `yaml:"username"`
+
+	// UID represents the template of the K8s UID. Default is "_principal_".
+	UID string `yaml:"uid"`
+
+	// Extra represents the templates of the K8s user extra fields.
+	Extra map[string][]string `yaml:"extra"`
+}
+
+// GroupMapping represents the rule assigning K8s groups to the matched Athenz principals.
+// A principal matches the rule only if it matches every non-empty condition.
+type GroupMapping struct {
+	// Domain represents the Athenz domain of the principal. Empty matches any domain.
+	Domain string `yaml:"domain"`
+
+	// Service represents the Athenz service of the principal. Empty matches any service.
+	Service string `yaml:"service"`
+
+	// DomainRegex represents the regular expression matching the whole Athenz domain of the principal. Empty matches any domain.
+	DomainRegex string `yaml:"domain_regex"`
+
+	// Groups represents the K8s groups assigned to the matched principal. "_domain_" and "_service_" are replaced with the Athenz domain and service.
+	Groups []string `yaml:"groups"`
+
+	// reg represents the compiled DomainRegex.
+	reg *regexp.Regexp
+
+	// err represents the error of compiling DomainRegex.
+	err error
+
+	// once ensure that the reg is compiled only once.
+	once *sync.Once
+}
+
+// NamespaceDomain represents the Athenz domains of the matched K8s namespaces, overriding ServiceAthenzDomains.
+type NamespaceDomain struct {
+	// Namespace represents the pattern of the K8s namespace, a glob that "*" matches any characters, or a regular expression with "regex:" prefix.
+	Namespace string `yaml:"namespace"`
+
+	// Domains represents the Athenz domains of the matched K8s namespace.
+	// "_namespace_" is replaced with the namespace, and "_match_" is replaced with the part matched by the first "*" (or the first capturing group of the regular expression).
+	Domains []string `yaml:"domains"`
+
+	// reg represents the compiled Namespace.
+	reg *regexp.Regexp
+
+	// err represents the error of compiling Namespace.
+	err error
+
+	// once ensure that the reg is compiled only once.
+	once *sync.Once
+}
+
+// Rule represents an ordered request filtering rule and its action.
+// Unlike the lists, the patterns match the K8s webhook request attributes before mapping, and Resource does not contain the subresource.
+// For K8s non-resource webhook requests, Resource matches the path, and the other request attributes are empty.
+// Like the lists, every omitted (empty) pattern matches any value, and "regex:" matches only an empty value.
+type Rule struct {
+	RequestInfo `yaml:",inline"`
+
+	// Subresource represents the pattern of the K8s subresource field inside K8s webhook request.
+	Subresource string `yaml:"subresource"`
+
+	// Action represents the action applied to the matched requests, "allow-bypass-athenz", "deny", "admin-domain", "default-domain" or "custom-domain".
+	Action string `yaml:"action"`
+
+	// Domains represents the Athenz domains checked by "custom-domain" action. "_namespace_" is replaced in the same way as ServiceAthenzDomains.
+	Domains []string `yaml:"domains"`
+
+	// subReg represents the compiled regexp for matching the K8s subresource.
+	subReg *regexp.Regexp
+
+	// err represents the error of compiling the patterns.
+	err error
+
+	// once ensure that the subReg is compiled only once.
+	once *sync.Once
 }
 
 // RequestInfo represents the rule of the K8s webhook request.
@@ -249,9 +614,27 @@ type RequestInfo struct {
 	// Name represents the K8s resource name field inside K8s webhook request.
 	Name string `yaml:"name"`
 
-	// reg represents the compiled regexp for matching another RequestInfo.
+	// User represents the pattern of the K8s user inside K8s webhook request. Empty matches any user.
+	User string `yaml:"user"`
+
+	// Group represents the pattern of the K8s groups inside K8s webhook request. Any group matching the pattern matches. Empty matches any groups.
+	Group string `yaml:"group"`
+
+	// reg represents the compiled regexp for matching another RequestInfo in the legacy way, nil if the legacy pattern is invalid.
 	reg *regexp.Regexp
 
+	// fieldRegs represents the compiled regexps of Verb, Namespace, APIGroup, Resource and Name, nil if the field is empty.
+	fieldRegs []*regexp.Regexp
+
+	// userReg represents the compiled regexp for matching the K8s user, nil if User is empty.
+	userReg *regexp.Regexp
+
+	// groupReg represents the compiled regexp for matching the K8s groups, nil if Group is empty.
+	groupReg *regexp.Regexp
+
+	// err represents the error of compiling the patterns.
+	err error
+
 	// once ensure that the reg is compiled only once.
 	once *sync.Once
 }
@@ -263,30 +646,356 @@ func (r *RequestInfo) Serialize() string {
 	return strings.Join([]string{r.Verb, r.Namespace, strings.Replace(r.APIGroup, ".", "_", -1), r.Resource, r.Name}, "-")
 }
 
-// Match checks if the given RequestInfo matches with the regular expression in this RequestInfo.
+// Match checks if each field of the given RequestInfo matches with the pattern of the same field in this RequestInfo.
+// Each pattern should match the whole field value (see fieldPattern), and an omitted (empty) pattern matches any value.
+// To match only an empty value, use the empty regular expression "regex:".
+func (r *RequestInfo) Match(req RequestInfo) bool {
+	if r.Compile() != nil {
+		return false
+	}
+	for i, v := range req.fields() {
+		if r.fieldRegs[i] != nil && !r.fieldRegs[i].MatchString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// LegacyMatch checks if the given RequestInfo matches with the regular expression in this RequestInfo.
 // 1. r.Serialize()
 // 2. replace `* => .*`
 // 3. replace `..* => .*`
 // return is regexp match
-func (r *RequestInfo) Match(req RequestInfo) bool {
+// Deprecated: the serialized fields are matched without anchors, hence, a pattern can match across the fields or a part of a field. Use Match instead.
+func (r *RequestInfo) LegacyMatch(req RequestInfo) bool {
+	if r.Compile() != nil {
+		return false
+	}
+	return r.reg != nil && r.reg.Copy().MatchString(req.Serialize())
+}
+
+// MatchIdentity checks if the given K8s user and groups match with the user and group patterns in this RequestInfo.
+// Each pattern should match the whole user or group (see fieldPattern), and an empty pattern matches any user or groups.
+func (r *RequestInfo) MatchIdentity(user string, groups []string) bool {
+	if r.Compile() != nil {
+		return false
+	}
+	if r.userReg != nil && !r.userReg.MatchString(user) {
+		return false
+	}
+	if r.groupReg == nil {
+		return true
+	}
+	for _, g := range groups {
+		if r.groupReg.MatchString(g) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compile compiles the regular expressions of this RequestInfo only once, and returns an error if any pattern is invalid.
+// It should be called before this RequestInfo is shared, a RequestInfo with an invalid pattern never matches.
+func (r *RequestInfo) Compile() error {
 	if r.once == nil {
 		r.once = new(sync.Once)
 	}
 	r.once.Do(func() {
-		r.reg = regexp.MustCompile(strings.Replace(strings.Replace(r.Serialize(), "*", ".*", -1), "..*", ".*", -1))
+		r.err = r.compile()
 	})
+	return r.err
+}
+
+// compile compiles the regular expressions of this RequestInfo.
+func (r *RequestInfo) compile() error {
+	r.reg, _ = regexp.Compile(r.pattern())
+	for _, f := range r.fields() {
+		if f == "" {
+			// omitted field matches any value
+			r.fieldRegs = append(r.fieldRegs, nil)
+			continue
+		}
+		reg, err := regexp.Compile(fieldPattern(f))
+		if err != nil {
+			return errors.Wrapf(err, "invalid pattern %q", f)
+		}
+		r.fieldRegs = append(r.fieldRegs, reg)
+	}
+	var err error
+	if r.User != "" {
+		r.userReg, err = regexp.Compile(fieldPattern(r.User))
+		if err != nil {
+			return errors.Wrapf(err, "invalid pattern %q", r.User)
+		}
+	}
+	if r.Group != "" {
+		r.groupReg, err = regexp.Compile(fieldPattern(r.Group))
+		if err != nil {
+			return errors.Wrapf(err, "invalid pattern %q", r.Group)
+		}
+	}
+	return nil
+}
 
-	return r.reg.Copy().MatchString(req.Serialize())
+// legacyDifferences returns the fields which match differently from the legacy way (see LegacyMatch).
+//   - a literal pattern matches only the whole field value, but also matched a longer value, checked with the value appended "-x", e.g. namespace "kube" with "kube-x" like "kube-system".
+//   - an omitted field matches any value, but matched only an empty value except the last field, checked with "x", e.g. namespace "" with "x" like "default".
+func (r *RequestInfo) legacyDifferences() []string {
+	if r.Compile() != nil {
+		return nil
+	}
+	names := []string{"verb", "namespace", "api_group", "resource", "name"}
+	patterns := r.fields()
+	probe := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if strings.HasPrefix(p, regexPrefix) {
+			// regular expressions are not supported by the legacy matching
+			return nil
+		}
+		probe = append(probe, strings.Replace(p, "*", "x", -1))
+	}
+
+	var diffs []string
+	for i, p := range patterns {
+		if strings.Contains(p, "*") {
+			continue
+		}
+		values := append([]string(nil), probe...)
+		values[i] = p + "-x"
+		if p == "" {
+			values[i] = "x"
+		}
+		req := RequestInfo{
+			Verb:      values[0],
+			Namespace: values[1],
+			APIGroup:  values[2],
+			Resource:  values[3],
+			Name:      values[4],
+		}
+		switch {
+		case p == "" && r.Match(req) && !r.LegacyMatch(req):
+			diffs = append(diffs, fmt.Sprintf("%s: omitted field matches any value, but matched only an empty value with legacy_list_match, use 'regex:' to match only an empty value", names[i]))
+		case p != "" && r.LegacyMatch(req) && !r.Match(req):
+			diffs = append(diffs, fmt.Sprintf("%s: %q matches only the whole value, but also matched %q with legacy_list_match", names[i], p, values[i]))
+		}
+	}
+	return diffs
+}
+
+// fields returns Verb, Namespace, APIGroup, Resource and Name.
+func (r *RequestInfo) fields() []string {
+	return []string{r.Verb, r.Namespace, r.APIGroup, r.Resource, r.Name}
+}
+
+// fieldPattern returns the anchored regular expression of the pattern of a RequestInfo field.
+// If the pattern has "regex:" prefix, the rest is a regular expression, else the pattern is a glob that "*" matches any characters.
+func fieldPattern(p string) string {
+	if strings.HasPrefix(p, regexPrefix) {
+		return "^(?:" + strings.TrimPrefix(p, regexPrefix) + ")$"
+	}
+	return "^" + strings.Replace(regexp.QuoteMeta(p), `\*`, ".*", -1) + "$"
+}
+
+// pattern returns the regular expression of this RequestInfo for matching another RequestInfo in the legacy way.
+func (r *RequestInfo) pattern() string {
+	return strings.Replace(strings.Replace(r.Serialize(), "*", ".*", -1), "..*", ".*", -1)
+}
+
+// Match checks if the K8s webhook request matches with every pattern in this Rule (see RequestInfo.Match and RequestInfo.MatchIdentity).
+func (r *Rule) Match(req RequestInfo, subresource, user string, groups []string) bool {
+	if r.Compile() != nil {
+		return false
+	}
+	return r.RequestInfo.Match(req) && (r.subReg == nil || r.subReg.MatchString(subresource)) && r.MatchIdentity(user, groups)
+}
+
+// Compile compiles the regular expressions of this Rule only once, and returns an error if any pattern is invalid.
+func (r *Rule) Compile() error {
+	if r.once == nil {
+		r.once = new(sync.Once)
+	}
+	r.once.Do(func() {
+		r.err = r.RequestInfo.Compile()
+		if r.err != nil {
+			return
+		}
+		if r.Subresource == "" {
+			// omitted subresource matches any subresource
+			return
+		}
+		r.subReg, r.err = regexp.Compile(fieldPattern(r.Subresource))
+		if r.err != nil {
+			r.err = errors.Wrapf(r.err, "invalid pattern %q", r.Subresource)
+		}
+	})
+	return r.err
+}
+
+// Match checks if the K8s namespace matches with this NamespaceDomain, and returns the matched part for "_match_".
+func (n *NamespaceDomain) Match(namespace string) (string, bool) {
+	if n.Compile() != nil {
+		return "", false
+	}
+	m := n.reg.FindStringSubmatch(namespace)
+	if m == nil {
+		return "", false
+	}
+	if len(m) > 1 {
+		return m[1], true
+	}
+	return "", true
+}
+
+// Compile compiles Namespace only once, and returns an error if it is invalid.
+func (n *NamespaceDomain) Compile() error {
+	if n.once == nil {
+		n.once = new(sync.Once)
+	}
+	n.once.Do(func() {
+		n.reg, n.err = regexp.Compile(n.pattern())
+		if n.err != nil {
+			n.err = errors.Wrapf(n.err, "invalid pattern %q", n.Namespace)
+		}
+	})
+	return n.err
+}
+
+// pattern returns the anchored regular expression of Namespace, each "*" of the glob is a capturing group.
+func (n *NamespaceDomain) pattern() string {
+	if strings.HasPrefix(n.Namespace, regexPrefix) {
+		return fieldPattern(n.Namespace)
+	}
+	return "^" + strings.Replace(regexp.QuoteMeta(n.Namespace), `\*`, "(.*)", -1) + "$"
+}
+
+// Match checks if the given Athenz domain and service match with this GroupMapping.
+func (g *GroupMapping) Match(domain, service string) bool {
+	if g.Compile() != nil {
+		return false
+	}
+
+	return (g.Domain == "" || g.Domain == domain) &&
+		(g.Service == "" || g.Service == service) &&
+		(g.reg == nil || g.reg.Copy().MatchString(domain))
+}
+
+// Compile compiles DomainRegex only once, and returns an error if it is invalid.
+func (g *GroupMapping) Compile() error {
+	if g.once == nil {
+		g.once = new(sync.Once)
+	}
+	g.once.Do(func() {
+		if g.DomainRegex != "" {
+			g.reg, g.err = regexp.Compile(g.pattern())
+			if g.err != nil {
+				g.err = errors.Wrapf(g.err, "invalid pattern %q", g.DomainRegex)
+			}
+		}
+	})
+	return g.err
+}
+
+// pattern returns the anchored regular expression of DomainRegex.
+func (g *GroupMapping) pattern() string {
+	return "^(?:" + g.DomainRegex + ")$"
+}
+
+// GetMessage returns the template of the reason, or the default template if Message is empty.
+func (d Denial) GetMessage() string {
+	if d.Message == "" {
+		return defaultDenialMessage
+	}
+	return d.Message
+}
+
+// GetJWKSURL returns the URL of the ZTS JSON Web Key Set, or the default URL under athenzURL if JWKSURL is empty.
+func (a AccessToken) GetJWKSURL(athenzURL string) string {
+	if a.JWKSURL == "" {
+		return strings.TrimSuffix(athenzURL, "/") + defaultJWKSPath
+	}
+	return a.JWKSURL
+}
+
+// GetRoleGroup returns the template of the K8s group of each role in the access token.
+func (a AccessToken) GetRoleGroup() string {
+	if a.RoleGroup == "" {
+		return defaultRoleGroup
+	}
+	return a.RoleGroup
+}
+
+// GetUsername returns the template of the K8s username.
+func (u UserMapping) GetUsername() string {
+	if u.Username == "" {
+		return defaultUserTemplate
+	}
+	return u.Username
+}
+
+// GetUID returns the template of the K8s UID.
+func (u UserMapping) GetUID() string {
+	if u.UID == "" {
+		return defaultUserTemplate
+	}
+	return u.UID
+}
+
+// GetMetricsPath returns the API path (pattern) for Prometheus metrics on health check server.
+func (s Server) GetMetricsPath() string {
+	if s.MetricsPath == "" {
+		return defaultMetricsPath
+	}
+	return s.MetricsPath
+}
+
+// GetLivenessPath returns the API path (pattern) for liveness probe on health check server.
+func (s Server) GetLivenessPath() string {
+	if s.LivenessPath == "" {
+		return defaultLivenessPath
+	}
+	return s.LivenessPath
+}
+
+// GetReadinessPath returns the API path (pattern) for readiness probe on health check server.
+func (s Server) GetReadinessPath() string {
+	if s.ReadinessPath == "" {
+		return defaultReadinessPath
+	}
+	return s.ReadinessPath
+}
+
+// GetAthenzProbePath returns the API path under the Athenz URL requested by the Athenz probe.
+func (r Readiness) GetAthenzProbePath() string {
+	if r.AthenzProbePath == "" {
+		return defaultAthenzProbePath
+	}
+	return r.AthenzProbePath
 }
 
 // New returns the decoded configuration YAML file as *Config struct. Returns non-nil error if any.
 func New(path string) (*Config, error) {
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "config read failed")
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
 	if err != nil {
 		return nil, errors.Wrap(err, "config read failed")
 	}
+	cfg, err := Parse(b)
+	if err != nil {
+		return nil, err
+	}
+	cfg.FilePath = path
+	return cfg, nil
+}
+
+// Parse returns the decoded configuration YAML content as *Config struct. Returns non-nil error if any.
+func Parse(b []byte) (*Config, error) {
 	cfg := new(Config)
-	err = yaml.NewDecoder(f).Decode(&cfg)
+	err := yaml.NewDecoder(bytes.NewReader(b)).Decode(&cfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "yaml parse failed")
 	}
@@ -195,13 +195,527 @@ func Test_requestInfo_Match(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "Check pattern does not match a part of the field",
+			fields: fields{
+				req: RequestInfo{
+					Verb:      "get",
+					Namespace: "kube",
+				},
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:      "get",
+					Namespace: "kube-system",
+				},
+			},
+			want: false,
+		},
+		{
+			name: "Check wildcard does not match across the fields",
+			fields: fields{
+				req: RequestInfo{
+					Verb:      "get",
+					Namespace: "kube-*",
+					APIGroup:  "",
+					Resource:  "pods",
+					Name:      "",
+				},
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:      "get",
+					Namespace: "kube",
+					APIGroup:  "system",
+					Resource:  "pods",
+				},
+			},
+			want: false,
+		},
+		{
+			name: "Check dot in pattern is not a regular expression",
+			fields: fields{
+				req: RequestInfo{
+					Verb:     "get",
+					APIGroup: "apps.k8s.io",
+				},
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:     "get",
+					APIGroup: "appsxk8sxio",
+				},
+			},
+			want: false,
+		},
+		{
+			name: "Check regex match",
+			fields: fields{
+				req: RequestInfo{
+					Verb:      "regex:get|list",
+					Namespace: "regex:kube-(system|public)",
+					Resource:  "*",
+				},
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:      "list",
+					Namespace: "kube-public",
+					Resource:  "pods",
+				},
+			},
+			want: true,
+		},
+		{
+			name: "Check omitted field matches any value",
+			fields: fields{
+				req: RequestInfo{
+					Verb:     "delete",
+					Resource: "nodes",
+				},
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:     "delete",
+					Resource: "nodes",
+					Name:     "foo",
+				},
+			},
+			want: true,
+		},
+		{
+			name: "Check empty regex matches only empty value",
+			fields: fields{
+				req: RequestInfo{
+					Verb:     "delete",
+					Resource: "nodes",
+					Name:     "regex:",
+				},
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:     "delete",
+					Resource: "nodes",
+					Name:     "foo",
+				},
+			},
+			want: false,
+		},
+		{
+			name: "Check regex is anchored",
+			fields: fields{
+				req: RequestInfo{
+					Verb:      "regex:get|list",
+					Namespace: "regex:kube",
+				},
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:      "get",
+					Namespace: "kube-system",
+				},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.fields.req.Match(tt.args.req)
+			if got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+				return
+			}
+		})
+	}
+}
+
+func Test_requestInfo_LegacyMatch(t *testing.T) {
+	type args struct {
+		req RequestInfo
+	}
+	type fields struct {
+		req RequestInfo
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want   bool
+	}{
+		{
+			name: "Check match",
+			fields: fields{
+				req: RequestInfo{
+					Verb:      "get",
+					Namespace: "*",
+					APIGroup:  "apps",
+					Resource:  "pods",
+					Name:      "*",
+				},
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:      "get",
+					Namespace: "default",
+					APIGroup:  "apps",
+					Resource:  "pods",
+					Name:      "pod",
+				},
+			},
+			want: true,
+		},
+		{
+			name: "Check empty pattern matches a part of the field",
+			fields: fields{
+				req: RequestInfo{
+					Verb:      "get",
+					Namespace: "*",
+					Resource:  "secrets",
+				},
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:      "get",
+					Namespace: "default",
+					Resource:  "secrets",
+					Name:      "token",
+				},
+			},
+			want: true,
+		},
+		{
+			name: "Check pattern matches across the fields",
+			fields: fields{
+				req: RequestInfo{
+					Verb:      "get",
+					Namespace: "kube-*",
+					Resource:  "pods",
+				},
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:      "get",
+					Namespace: "kube",
+					APIGroup:  "system",
+					Name:      "pods-0",
+				},
+			},
+			want: true,
+		},
+		{
+			name: "Check invalid legacy pattern never matches",
+			fields: fields{
+				req: RequestInfo{
+					Name: "(",
+				},
+			},
+			args: args{
+				req: RequestInfo{
+					Name: "(",
+				},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.fields.req.LegacyMatch(tt.args.req)
+			if got != tt.want {
+				t.Errorf("LegacyMatch() = %v, want %v", got, tt.want)
+				return
+			}
+		})
+	}
+}
+
+func Test_requestInfo_MatchIdentity(t *testing.T) {
+	type args struct {
+		user   string
+		groups []string
+	}
+	tests := []struct {
+		name string
+		ri   RequestInfo
+		args args
+		want bool
+	}{
+		{
+			name: "Check empty patterns match any identity",
+			ri:   RequestInfo{},
+			args: args{
+				user: "user",
+			},
+			want: true,
+		},
+		{
+			name: "Check user wildcard match",
+			ri: RequestInfo{
+				User: "system:serviceaccount:kube-system:*",
+			},
+			args: args{
+				user: "system:serviceaccount:kube-system:default",
+			},
+			want: true,
+		},
+		{
+			name: "Check user matches the whole user",
+			ri: RequestInfo{
+				User: "system:serviceaccount:kube-system:*",
+			},
+			args: args{
+				user: "x-system:serviceaccount:kube-system:default",
+			},
+			want: false,
+		},
+		{
+			name: "Check any group match",
+			ri: RequestInfo{
+				Group: "system:masters",
+			},
+			args: args{
+				user:   "user",
+				groups: []string{"system:authenticated", "system:masters"},
+			},
+			want: true,
+		},
+		{
+			name: "Check no group match",
+			ri: RequestInfo{
+				Group: "system:masters",
+			},
+			args: args{
+				user:   "user",
+				groups: []string{"system:authenticated"},
+			},
+			want: false,
+		},
+		{
+			name: "Check user and group should both match",
+			ri: RequestInfo{
+				User:  "admin",
+				Group: "system:masters",
+			},
+			args: args{
+				user:   "user",
+				groups: []string{"system:masters"},
+			},
+			want: false,
+		},
+		{
+			name: "Check regexp meta characters are literal",
+			ri: RequestInfo{
+				User: "user.name",
+			},
+			args: args{
+				user: "user-name",
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ri.MatchIdentity(tt.args.user, tt.args.groups); got != tt.want {
+				t.Errorf("MatchIdentity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRule_Match(t *testing.T) {
+	type args struct {
+		req         RequestInfo
+		subresource string
+		user        string
+		groups      []string
+	}
+	tests := []struct {
+		name string
+		rule Rule
+		args args
+		want bool
+	}{
+		{
+			name: "Check match",
+			rule: Rule{
+				RequestInfo: RequestInfo{
+					Verb:      "create",
+					Namespace: "*",
+					Resource:  "pods",
+					Group:     "developers",
+				},
+				Subresource: "regex:exec|attach",
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:      "create",
+					Namespace: "default",
+					Resource:  "pods",
+				},
+				subresource: "attach",
+				user:        "alice",
+				groups:      []string{"developers"},
+			},
+			want: true,
+		},
+		{
+			name: "Check omitted subresource matches any subresource",
+			rule: Rule{
+				RequestInfo: RequestInfo{
+					Verb:      "create",
+					Namespace: "*",
+					Resource:  "pods",
+				},
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:      "create",
+					Namespace: "default",
+					Resource:  "pods",
+				},
+				subresource: "exec",
+			},
+			want: true,
+		},
+		{
+			name: "Check omitted name matches any name",
+			rule: Rule{
+				RequestInfo: RequestInfo{
+					Verb:     "delete",
+					Resource: "nodes",
+				},
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:     "delete",
+					Resource: "nodes",
+					Name:     "node-1",
+				},
+			},
+			want: true,
+		},
+		{
+			name: "Check empty regex subresource does not match subresource",
+			rule: Rule{
+				RequestInfo: RequestInfo{
+					Verb:      "create",
+					Namespace: "*",
+					Resource:  "pods",
+				},
+				Subresource: "regex:",
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:      "create",
+					Namespace: "default",
+					Resource:  "pods",
+				},
+				subresource: "exec",
+			},
+			want: false,
+		},
+		{
+			name: "Check group not match",
+			rule: Rule{
+				RequestInfo: RequestInfo{
+					Verb:      "create",
+					Namespace: "*",
+					Resource:  "pods",
+					Group:     "developers",
+				},
+				Subresource: "*",
+			},
+			args: args{
+				req: RequestInfo{
+					Verb:      "create",
+					Namespace: "default",
+					Resource:  "pods",
+				},
+				subresource: "exec",
+				groups:      []string{"viewers"},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Match(tt.args.req, tt.args.subresource, tt.args.user, tt.args.groups); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupMapping_Match(t *testing.T) {
+	type args struct {
+		domain  string
+		service string
+	}
+	tests := []struct {
+		name string
+		gm   GroupMapping
+		args args
+		want bool
+	}{
+		{
+			name: "Check empty rule matches any principal",
+			gm:   GroupMapping{},
+			args: args{
+				domain:  "k8s.admin",
+				service: "user",
+			},
+			want: true,
+		},
+		{
+			name: "Check domain and service match",
+			gm: GroupMapping{
+				Domain:  "k8s.admin",
+				Service: "user",
+			},
+			args: args{
+				domain:  "k8s.admin",
+				service: "user",
+			},
+			want: true,
+		},
+		{
+			name: "Check service not match",
+			gm: GroupMapping{
+				Domain:  "k8s.admin",
+				Service: "user",
+			},
+			args: args{
+				domain:  "k8s.admin",
+				service: "other",
+			},
+			want: false,
+		},
+		{
+			name: "Check domain regex match",
+			gm: GroupMapping{
+				DomainRegex: `.*\.k8s\.admin`,
+			},
+			args: args{
+				domain:  "team.k8s.admin",
+				service: "user",
+			},
+			want: true,
+		},
+		{
+			name: "Check domain regex matches the whole domain",
+			gm: GroupMapping{
+				DomainRegex: `.*\.k8s\.admin`,
+			},
+			args: args{
+				domain:  "team.k8s.admin.sub",
+				service: "user",
+			},
+			want: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := tt.fields.req.Match(tt.args.req)
-			if got != tt.want {
+			if got := tt.gm.Match(tt.args.domain, tt.args.service); got != tt.want {
 				t.Errorf("Match() = %v, want %v", got, tt.want)
-				return
 			}
 		})
 	}
@@ -228,6 +742,19 @@ func TestNew(t *testing.T) {
 			},
 			wantErr: fmt.Errorf("yaml parse failed: yaml: line 11: could not find expected ':'"),
 		},
+		{
+			name: "Test file not exists",
+			args: args{
+				path: "./testdata/notexists.yaml",
+			},
+			afterFunc: func() error {
+				if _, err := os.Stat("./testdata/notexists.yaml"); !os.IsNotExist(err) {
+					return fmt.Errorf("config file should not be created, err: %v", err)
+				}
+				return nil
+			},
+			wantErr: fmt.Errorf("config read failed: open ./testdata/notexists.yaml: no such file or directory"),
+		},
 		{
 			name: "Open file error",
 			args: args{
@@ -267,6 +794,7 @@ func TestNew(t *testing.T) {
 					Port:             443,
 					HealthzPort:      8080,
 					HealthzPath:      "/healthz",
+					MetricsPath:      "/metrics",
 					Timeout:          "5s",
 					ShutdownDuration: "5s",
 					ProbeWaitTime:    "3s",
@@ -368,6 +896,17 @@ func TestNew(t *testing.T) {
 						},
 					},
 				},
+				Reload: Reload{
+					Enabled:  true,
+					Interval: "10s",
+				},
+				Audit: Audit{
+					Enabled:    true,
+					Path:       "/var/log/garm/audit.log",
+					MaxSize:    100,
+					MaxBackups: 3,
+				},
+				FilePath: "./testdata/example_config.yaml",
 			},
 		},
 	}
@@ -413,6 +952,317 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestParse(t *testing.T) {
+	type args struct {
+		b []byte
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *Config
+		wantErr error
+	}{
+		{
+			name: "Test parse empty content",
+			args: args{
+				b: []byte{},
+			},
+			wantErr: fmt.Errorf("yaml parse failed: EOF"),
+		},
+		{
+			name: "Test parse valid content",
+			args: args{
+				b: []byte("version: v2.0.0\nreload:\n  enabled: true\n  interval: 1s\n"),
+			},
+			want: &Config{
+				Version: "v2.0.0",
+				Reload: Reload{
+					Enabled:  true,
+					Interval: "1s",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args.b)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("Parse() unexpected error: %v", err)
+				return
+			}
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Errorf("want error: %v, got nil", tt.wantErr)
+					return
+				}
+				if err.Error() != tt.wantErr.Error() {
+					t.Errorf("Parse() error: %v, want: %v", err, tt.wantErr)
+					return
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse()= %v, want= %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDenial_GetMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		d    Denial
+		want string
+	}{
+		{
+			name: "Test default denial message template",
+			d:    Denial{},
+			want: "_reason_",
+		},
+		{
+			name: "Test configured denial message template",
+			d: Denial{
+				Message: "_principal_ cannot _verb_ _resource_: _reason_",
+			},
+			want: "_principal_ cannot _verb_ _resource_: _reason_",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.d.GetMessage(); got != tt.want {
+				t.Errorf("GetMessage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessToken_GetJWKSURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		a         AccessToken
+		athenzURL string
+		want      string
+	}{
+		{
+			name:      "Test default JWKS URL",
+			a:         AccessToken{},
+			athenzURL: "https://zts.athenz.io/zts/v1/",
+			want:      "https://zts.athenz.io/zts/v1/oauth2/keys?rfc=true",
+		},
+		{
+			name: "Test configured JWKS URL",
+			a: AccessToken{
+				JWKSURL: "https://jwks.athenz.io/keys",
+			},
+			athenzURL: "https://zts.athenz.io/zts/v1",
+			want:      "https://jwks.athenz.io/keys",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.GetJWKSURL(tt.athenzURL); got != tt.want {
+				t.Errorf("GetJWKSURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessToken_GetRoleGroup(t *testing.T) {
+	tests := []struct {
+		name string
+		a    AccessToken
+		want string
+	}{
+		{
+			name: "Test default role group template",
+			a:    AccessToken{},
+			want: "_domain_:role._role_",
+		},
+		{
+			name: "Test configured role group template",
+			a: AccessToken{
+				RoleGroup: "athenz:_role_",
+			},
+			want: "athenz:_role_",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.GetRoleGroup(); got != tt.want {
+				t.Errorf("GetRoleGroup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserMapping_GetUsername(t *testing.T) {
+	tests := []struct {
+		name string
+		um   UserMapping
+		want string
+	}{
+		{
+			name: "Test default username template",
+			um:   UserMapping{},
+			want: "_principal_",
+		},
+		{
+			name: "Test configured username template",
+			um: UserMapping{
+				Username: "athenz:_domain_:_service_",
+			},
+			want: "athenz:_domain_:_service_",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.um.GetUsername(); got != tt.want {
+				t.Errorf("GetUsername() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserMapping_GetUID(t *testing.T) {
+	tests := []struct {
+		name string
+		um   UserMapping
+		want string
+	}{
+		{
+			name: "Test default UID template",
+			um:   UserMapping{},
+			want: "_principal_",
+		},
+		{
+			name: "Test configured UID template",
+			um: UserMapping{
+				UID: "_user_",
+			},
+			want: "_user_",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.um.GetUID(); got != tt.want {
+				t.Errorf("GetUID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_GetMetricsPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		server Server
+		want   string
+	}{
+		{
+			name:   "Test default metrics path",
+			server: Server{},
+			want:   "/metrics",
+		},
+		{
+			name: "Test configured metrics path",
+			server: Server{
+				MetricsPath: "/prometheus",
+			},
+			want: "/prometheus",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.server.GetMetricsPath(); got != tt.want {
+				t.Errorf("GetMetricsPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_GetLivenessPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		server Server
+		want   string
+	}{
+		{
+			name:   "Test default liveness path",
+			server: Server{},
+			want:   "/livez",
+		},
+		{
+			name: "Test configured liveness path",
+			server: Server{
+				LivenessPath: "/alive",
+			},
+			want: "/alive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.server.GetLivenessPath(); got != tt.want {
+				t.Errorf("GetLivenessPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_GetReadinessPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		server Server
+		want   string
+	}{
+		{
+			name:   "Test default readiness path",
+			server: Server{},
+			want:   "/readyz",
+		},
+		{
+			name: "Test configured readiness path",
+			server: Server{
+				ReadinessPath: "/ready",
+			},
+			want: "/ready",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.server.GetReadinessPath(); got != tt.want {
+				t.Errorf("GetReadinessPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadiness_GetAthenzProbePath(t *testing.T) {
+	tests := []struct {
+		name      string
+		readiness Readiness
+		want      string
+	}{
+		{
+			name:      "Test default Athenz probe path",
+			readiness: Readiness{},
+			want:      "/status",
+		},
+		{
+			name: "Test configured Athenz probe path",
+			readiness: Readiness{
+				AthenzProbePath: "/ping",
+			},
+			want: "/ping",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.readiness.GetAthenzProbePath(); got != tt.want {
+				t.Errorf("GetAthenzProbePath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestGetVersion(t *testing.T) {
 	tests := []struct {
 		name string
@@ -544,3 +1394,75 @@ func TestCheckPrefixAndSuffix(t *testing.T) {
 		})
 	}
 }
+
+func TestNamespaceDomain_Match(t *testing.T) {
+	tests := []struct {
+		name      string
+		nd        NamespaceDomain
+		namespace string
+		wantMatch string
+		want      bool
+	}{
+		{
+			name: "Check exact match",
+			nd: NamespaceDomain{
+				Namespace: "kube-system",
+			},
+			namespace: "kube-system",
+			want:      true,
+		},
+		{
+			name: "Check glob match",
+			nd: NamespaceDomain{
+				Namespace: "team-*",
+			},
+			namespace: "team-alpha",
+			wantMatch: "alpha",
+			want:      true,
+		},
+		{
+			name: "Check glob matches the whole namespace",
+			nd: NamespaceDomain{
+				Namespace: "team-*",
+			},
+			namespace: "myteam-alpha",
+			want:      false,
+		},
+		{
+			name: "Check glob quotes the other characters",
+			nd: NamespaceDomain{
+				Namespace: "team.*",
+			},
+			namespace: "team-alpha",
+			want:      false,
+		},
+		{
+			name: "Check regex match with capturing group",
+			nd: NamespaceDomain{
+				Namespace: "regex:team-([a-z]+)-[0-9]+",
+			},
+			namespace: "team-beta-01",
+			wantMatch: "beta",
+			want:      true,
+		},
+		{
+			name: "Check regex match without capturing group",
+			nd: NamespaceDomain{
+				Namespace: "regex:team-[a-z]+",
+			},
+			namespace: "team-beta",
+			want:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotMatch, got := tt.nd.Match(tt.namespace)
+			if got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+			if gotMatch != tt.wantMatch {
+				t.Errorf("Match() match = %q, want %q", gotMatch, tt.wantMatch)
+			}
+		})
+	}
+}
@@ -0,0 +1,507 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// namespacePlaceholder is replaced with the K8s namespace in Athenz domains.
+	namespacePlaceholder = "_namespace_"
+
+	// domainPlaceholder is replaced with the Athenz domain in the Athenz resource template.
+	domainPlaceholder = "_domain_"
+)
+
+var (
+	// logTraces represents the supported values of "logger.log_trace".
+	logTraces = map[string]bool{
+		"server":  true,
+		"athenz":  true,
+		"mapping": true,
+	}
+
+	// defaultActions represents the supported values of "default_action", the rule actions except "custom-domain".
+	defaultActions = []string{
+		ActionAllowBypassAthenz,
+		ActionDeny,
+		ActionAdminDomain,
+		ActionDefaultDomain,
+	}
+)
+
+// ValidationError represents all problems found in the configuration.
+type ValidationError struct {
+	// Problems represents each problem in format "${YAML path}: ${reason}".
+	Problems []string
+}
+
+// Error returns all problems, one problem per line.
+func (e *ValidationError) Error() string {
+	return "invalid config:\n\t" + strings.Join(e.Problems, "\n\t")
+}
+
+// validator collects the problems found in the configuration.
+type validator struct {
+	problems []string
+}
+
+// Validate checks the configuration, and returns *ValidationError containing every problem found, or nil if the configuration is valid.
+func (c *Config) Validate() error {
+	v := new(validator)
+
+	if c.Version != currentVersion {
+		v.addf("version", "unsupported version %q, want %q", c.Version, currentVersion)
+	}
+
+	for _, t := range strings.Split(strings.ToLower(c.Logger.LogTrace), ",") {
+		if t != "" && !logTraces[t] {
+			v.addf("logger.log_trace", "unsupported trace event %q", t)
+		}
+	}
+
+	v.duration("server.timeout", c.Server.Timeout)
+	v.duration("server.shutdown_duration", c.Server.ShutdownDuration)
+	v.duration("server.probe_wait_time", c.Server.ProbeWaitTime)
+	if c.Server.Port == c.Server.HealthzPort {
+		v.addf("server.health_check_port", "conflicts with server.port %d", c.Server.Port)
+	}
+	v.paths("server", []pathEntry{
+		{"health_check_path", c.Server.HealthzPath},
+		{"metrics_path", c.Server.GetMetricsPath()},
+		{"liveness_path", c.Server.GetLivenessPath()},
+		{"readiness_path", c.Server.GetReadinessPath()},
+	})
+	if c.Server.Readiness.AthenzProbe {
+		v.duration("server.readiness.athenz_probe_interval", c.Server.Readiness.AthenzProbeInterval)
+	}
+	v.env("server.tls.cert", c.Server.TLS.Cert)
+	v.env("server.tls.key", c.Server.TLS.Key)
+	v.env("server.tls.ca", c.Server.TLS.CA)
+
+	v.duration("athenz.timeout", c.Athenz.Timeout)
+	v.env("athenz.root_ca", c.Athenz.AthenzRootCA)
+	if c.Athenz.Cache.Enabled {
+		v.duration("athenz.cache.allow_ttl", c.Athenz.Cache.AllowTTL)
+		v.duration("athenz.cache.deny_ttl", c.Athenz.Cache.DenyTTL)
+		if c.Athenz.Cache.Size <= 0 {
+			v.addf("athenz.cache.size", "must be positive, got %d", c.Athenz.Cache.Size)
+		}
+	}
+	if c.Athenz.AccessToken.Enabled {
+		v.duration("athenz.access_token.refresh_duration", c.Athenz.AccessToken.RefreshDuration)
+		if c.Athenz.AccessToken.Issuer == "" {
+			v.addf("athenz.access_token.issuer", "must be set")
+		}
+		if len(c.Athenz.AccessToken.Audiences) == 0 {
+			v.addf("athenz.access_token.audiences", "no audiences assigned")
+		}
+		if !strings.Contains(c.Athenz.AccessToken.GetRoleGroup(), "_role_") {
+			v.addf("athenz.access_token.role_group", "%q does not contain _role_", c.Athenz.AccessToken.RoleGroup)
+		}
+	}
+	if c.Athenz.Certificate.Enabled {
+		if c.Athenz.AthenzRootCA == "" {
+			v.addf("athenz.root_ca", "must be set to verify the certificates of athenz.certificate")
+		}
+		if c.Athenz.Certificate.Audience == "" {
+			v.addf("athenz.certificate.audience", "must be set")
+		}
+		v.duration("athenz.certificate.max_lifetime", c.Athenz.Certificate.MaxLifetime)
+	}
+	if c.Athenz.Policy.Enabled {
+		v.duration("athenz.policy.refresh_duration", c.Athenz.Policy.RefreshDuration)
+		if len(c.Athenz.Policy.Domains) == 0 {
+			v.addf("athenz.policy.domains", "no domains assigned")
+		}
+		for i, d := range c.Athenz.Policy.Domains {
+			v.env(fmt.Sprintf("athenz.policy.domains[%d]", i), d)
+		}
+		v.publicKeys("athenz.policy.zts_public_keys", c.Athenz.Policy.ZTSPublicKeys)
+		v.publicKeys("athenz.policy.zms_public_keys", c.Athenz.Policy.ZMSPublicKeys)
+		if !c.Athenz.AccessToken.Enabled {
+			v.addf("athenz.policy.enabled", "requires athenz.access_token.enabled, the roles are taken only from the access tokens")
+		}
+	}
+
+	v.duration("token.refresh_duration", c.Token.RefreshDuration)
+	v.duration("token.expiration", c.Token.Expiration)
+	v.env("token.athenz_domain", c.Token.AthenzDomain)
+	v.env("token.service_name", c.Token.ServiceName)
+	v.env("token.private_key", c.Token.PrivateKey)
+
+	if c.Reload.Enabled {
+		v.duration("reload.interval", c.Reload.Interval)
+	}
+
+	if c.Audit.Enabled {
+		v.env("audit.path", c.Audit.Path)
+		if c.Audit.MaxSize < 0 {
+			v.addf("audit.max_size", "must not be negative, got %d", c.Audit.MaxSize)
+		}
+		if c.Audit.MaxBackups < 0 {
+			v.addf("audit.max_backups", "must not be negative, got %d", c.Audit.MaxBackups)
+		}
+	}
+
+	v.mapping("map_rule", c.Mapping)
+
+	return v.err()
+}
+
+// Validate checks only the mapping rules, and returns *ValidationError containing every problem found, or nil if the mapping rules are valid.
+// It is used when the mapping rules are used without running the server, e.g. explain sub-command.
+func (m Mapping) Validate() error {
+	v := new(validator)
+	v.mapping("map_rule", m)
+	return v.err()
+}
+
+// Warnings returns the mapping rules of every profile which are valid, but may not work as intended.
+//   - service_athenz_domains without "_namespace_", every namespace is checked with the same Athenz domain.
+//   - the entries of admin_access_list, white_list and black_list matching differently from legacy_list_match (see listMatchWarnings).
+func (m Mapping) Warnings() []string {
+	warnings := platformWarnings("map_rule.tld.platform", m.TLD.Platform)
+	names := make([]string, 0, len(m.Profiles))
+	for name := range m.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		warnings = append(warnings, platformWarnings(fmt.Sprintf("map_rule.profiles[%q].tld.platform", name), m.Profiles[name].Platform)...)
+	}
+	return warnings
+}
+
+// platformWarnings returns the warnings of the mapping rules of the platform.
+func platformWarnings(path string, p Platform) []string {
+	var warnings []string
+	for i, domain := range p.ServiceAthenzDomains {
+		if !strings.Contains(domain, namespacePlaceholder) {
+			warnings = append(warnings, fmt.Sprintf("%s.service_athenz_domains[%d]: %q does not contain %s, every namespace is checked with the same domain", path, i, domain, namespacePlaceholder))
+		}
+	}
+	return append(warnings, listMatchWarnings(path, p)...)
+}
+
+// listMatchWarnings returns the list entries of the platform matching differently from legacy_list_match.
+// A literal field pattern matches only the whole field value, while the legacy matching also matched the longer values, e.g. namespace "kube" matched "kube-system".
+// The platforms using legacy_list_match or the ordered rules are skipped.
+func listMatchWarnings(path string, p Platform) []string {
+	if p.LegacyListMatch || p.DefaultAction != "" {
+		return nil
+	}
+	lists := []struct {
+		name string
+		list []*RequestInfo
+	}{
+		{"admin_access_list", p.AdminAccessList},
+		{"white_list", p.WhiteList},
+		{"black_list", p.BlackList},
+	}
+	var warnings []string
+	for _, l := range lists {
+		for i, ri := range l.list {
+			for _, d := range ri.legacyDifferences() {
+				warnings = append(warnings, fmt.Sprintf("%s.%s[%d].%s", path, l.name, i, d))
+			}
+		}
+	}
+	return warnings
+}
+
+// err returns *ValidationError containing the collected problems, or nil if no problem is found.
+func (v *validator) err() error {
+	if len(v.problems) != 0 {
+		return &ValidationError{
+			Problems: v.problems,
+		}
+	}
+	return nil
+}
+
+// mapping checks the mapping rules of the default profile and the named profiles.
+func (v *validator) mapping(path string, m Mapping) {
+	v.platform(path+".tld.platform", m.TLD.Platform)
+	v.profiles(path+".profiles", m.Profiles)
+}
+
+// profiles checks every profile has a valid name, and valid mapping rules.
+func (v *validator) profiles(path string, profiles map[string]TLD) {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ppath := fmt.Sprintf("%s[%q]", path, name)
+		if name == "" || strings.Contains(name, "/") {
+			v.addf(ppath, "invalid profile name, must be non-empty and must not contain /")
+		}
+		v.platform(ppath+".tld.platform", profiles[name].Platform)
+	}
+}
+
+// platform checks the mapping rules of the platform.
+func (v *validator) platform(path string, p Platform) {
+	for i, domain := range p.ServiceAthenzDomains {
+		v.domainEnv(fmt.Sprintf("%s.service_athenz_domains[%d]", path, i), domain)
+	}
+	v.namespaceDomains(path+".namespace_domains", p.NamespaceDomains)
+	v.domainEnv(path+".athenz_service_account_prefix", p.AthenzServiceAccountPrefix)
+	if p.ResourceTemplate != "" && !strings.Contains(p.ResourceTemplate, domainPlaceholder) {
+		v.addf(path+".resource_template", "%q does not contain %s", p.ResourceTemplate, domainPlaceholder)
+	}
+
+	v.subresourceMappings(path+".subresource_mappings", p.SubresourceMappings)
+
+	v.requestInfoList(path+".admin_access_list", p.AdminAccessList, p.LegacyListMatch)
+	v.requestInfoList(path+".white_list", p.WhiteList, p.LegacyListMatch)
+	v.requestInfoList(path+".black_list", p.BlackList, p.LegacyListMatch)
+	v.rules(path+".rules", p.Rules)
+	if p.DefaultAction != "" {
+		if !contains(defaultActions, p.DefaultAction) {
+			v.addf(path+".default_action", "unsupported action %q, want one of %s", p.DefaultAction, strings.Join(defaultActions, ", "))
+		}
+		if len(p.AdminAccessList) != 0 || len(p.WhiteList) != 0 || len(p.BlackList) != 0 {
+			v.addf(path+".rules", "conflicts with admin_access_list, white_list and black_list")
+		}
+	} else if len(p.Rules) != 0 {
+		v.addf(path+".default_action", "must be set to enable rules")
+	}
+	v.groupMappings(path+".group_mappings", p.GroupMappings)
+	v.principalMappings(path+".eks.user_mappings", p.EKS.UserMappings)
+	v.principalMappings(path+".eks.system_group_mappings", p.EKS.SystemGroupMappings)
+	v.principalMappings(path+".aks.user_mappings", p.AKS.UserMappings)
+}
+
+// principalMappings checks every key is mapped to an Athenz principal.
+func (v *validator) principalMappings(path string, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if m[k] == "" {
+			v.addf(fmt.Sprintf("%s[%q]", path, k), "empty principal")
+		}
+	}
+}
+
+// namespaceDomains checks every NamespaceDomain in the list can be compiled, and assigns some domains.
+func (v *validator) namespaceDomains(path string, list []*NamespaceDomain) {
+	for i, n := range list {
+		npath := fmt.Sprintf("%s[%d]", path, i)
+		if n == nil {
+			v.addf(npath, "empty rule")
+			continue
+		}
+		_, err := regexp.Compile(n.pattern())
+		if err != nil {
+			v.addf(npath+".namespace", "invalid pattern: %v", err)
+		}
+		if len(n.Domains) == 0 {
+			v.addf(npath+".domains", "no domains assigned")
+		}
+		for j, domain := range n.Domains {
+			v.domainEnv(fmt.Sprintf("%s.domains[%d]", npath, j), strings.Replace(domain, MatchPlaceholder, "", -1))
+		}
+	}
+}
+
+// publicKeys checks the public key file paths by key ID.
+func (v *validator) publicKeys(path string, keys map[string]string) {
+	if len(keys) == 0 {
+		v.addf(path, "no public keys assigned")
+		return
+	}
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		v.env(fmt.Sprintf("%s[%q]", path, id), keys[id])
+	}
+}
+
+// subresourceMappings checks every key is in format "${resource}/${subresource}" or "${subresource}".
+func (v *validator) subresourceMappings(path string, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts := strings.Split(k, "/")
+		if len(parts) > 2 || contains(parts, "") {
+			v.addf(fmt.Sprintf("%s[%q]", path, k), "invalid key, want ${resource}/${subresource} or ${subresource}")
+		}
+	}
+}
+
+// groupMappings checks every GroupMapping in the list can be compiled, and assigns some groups.
+func (v *validator) groupMappings(path string, list []*GroupMapping) {
+	for i, g := range list {
+		gpath := fmt.Sprintf("%s[%d]", path, i)
+		if g == nil {
+			v.addf(gpath, "empty rule")
+			continue
+		}
+		if g.DomainRegex != "" {
+			_, err := regexp.Compile(g.pattern())
+			if err != nil {
+				v.addf(gpath+".domain_regex", "invalid pattern: %v", err)
+			}
+		}
+		if len(g.Groups) == 0 {
+			v.addf(gpath+".groups", "no groups assigned")
+		}
+	}
+}
+
+// pathEntry is a named API path of the health check server.
+type pathEntry struct {
+	name string
+	path string
+}
+
+// paths checks each API path does not conflict with the previous ones.
+func (v *validator) paths(path string, entries []pathEntry) {
+	for i, e := range entries {
+		for _, prev := range entries[:i] {
+			if e.path == prev.path {
+				v.addf(path+"."+e.name, "conflicts with %s.%s %q", path, prev.name, prev.path)
+				break
+			}
+		}
+	}
+}
+
+// requestInfoList checks every RequestInfo in the list can be compiled.
+// If legacy is true, the legacy pattern of the whole RequestInfo is also checked.
+func (v *validator) requestInfoList(path string, list []*RequestInfo, legacy bool) {
+	for i, ri := range list {
+		rpath := fmt.Sprintf("%s[%d]", path, i)
+		if ri == nil {
+			v.addf(rpath, "empty rule")
+			continue
+		}
+		v.requestInfo(rpath, ri)
+		if legacy {
+			_, err := regexp.Compile(ri.pattern())
+			if err != nil {
+				v.addf(rpath, "invalid legacy pattern: %v", err)
+			}
+		}
+	}
+}
+
+// rules checks every Rule in the list can be compiled, and has a supported action.
+func (v *validator) rules(path string, list []*Rule) {
+	for i, r := range list {
+		rpath := fmt.Sprintf("%s[%d]", path, i)
+		if r == nil {
+			v.addf(rpath, "empty rule")
+			continue
+		}
+		v.requestInfo(rpath, &r.RequestInfo)
+		v.pattern(rpath+".subresource", r.Subresource)
+		switch {
+		case r.Action == "":
+			v.addf(rpath+".action", "no action assigned")
+		case r.Action == ActionCustomDomain:
+			if len(r.Domains) == 0 {
+				v.addf(rpath+".domains", "no domains assigned")
+			}
+			for j, domain := range r.Domains {
+				v.domainEnv(fmt.Sprintf("%s.domains[%d]", rpath, j), domain)
+			}
+		case !contains(defaultActions, r.Action):
+			v.addf(rpath+".action", "unsupported action %q", r.Action)
+		}
+	}
+}
+
+// requestInfo checks every field pattern of the RequestInfo can be compiled.
+func (v *validator) requestInfo(path string, ri *RequestInfo) {
+	v.pattern(path+".verb", ri.Verb)
+	v.pattern(path+".namespace", ri.Namespace)
+	v.pattern(path+".api_group", ri.APIGroup)
+	v.pattern(path+".resource", ri.Resource)
+	v.pattern(path+".name", ri.Name)
+	v.pattern(path+".user", ri.User)
+	v.pattern(path+".group", ri.Group)
+}
+
+// pattern checks the field pattern can be compiled (see fieldPattern).
+func (v *validator) pattern(path, p string) {
+	_, err := regexp.Compile(fieldPattern(p))
+	if err != nil {
+		v.addf(path, "invalid pattern: %v", err)
+	}
+}
+
+// duration checks val is a valid duration.
+func (v *validator) duration(path, val string) {
+	_, err := time.ParseDuration(val)
+	if err != nil {
+		v.addf(path, "invalid duration %q", val)
+	}
+}
+
+// env checks the environment variable is set if val is an environment variable placeholder (e.g. "_ENV_").
+func (v *validator) env(path, val string) {
+	if !checkPrefixAndSuffix(val, "_", "_") {
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimSuffix(val, "_"), "_")
+	if _, ok := os.LookupEnv(name); !ok {
+		v.addf(path, "environment variable %s for %q is not set", name, val)
+	}
+}
+
+// domainEnv checks the environment variable placeholders in each dot separated part of the Athenz domain, except "_namespace_".
+func (v *validator) domainEnv(path, domain string) {
+	for _, part := range strings.Split(domain, ".") {
+		if part != namespacePlaceholder {
+			v.env(path, part)
+		}
+	}
+}
+
+// addf adds a problem of the given YAML path.
+func (v *validator) addf(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+// contains returns true if list contains val.
+func contains(list []string, val string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
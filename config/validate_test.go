@@ -0,0 +1,620 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func validConfig() Config {
+	return Config{
+		Version: currentVersion,
+		Logger: Logger{
+			LogTrace: "server,Athenz,mapping",
+		},
+		Server: Server{
+			Port:             443,
+			HealthzPort:      8080,
+			Timeout:          "5s",
+			ShutdownDuration: "5s",
+			ProbeWaitTime:    "3s",
+		},
+		Athenz: Athenz{
+			Timeout: "5s",
+		},
+		Token: Token{
+			RefreshDuration: "10s",
+			Expiration:      "5s",
+		},
+		Mapping: Mapping{
+			TLD: TLD{
+				Platform: Platform{
+					ServiceAthenzDomains: []string{"k8s._namespace_"},
+					WhiteList: []*RequestInfo{
+						{
+							Verb: "get",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        func() Config
+		beforeFunc func()
+		afterFunc  func()
+		want       []string
+	}{
+		{
+			name: "Check valid config",
+			cfg:  validConfig,
+		},
+		{
+			name: "Check environment variable placeholder is set",
+			cfg: func() Config {
+				c := validConfig()
+				c.Server.TLS.Cert = "_garm_validate_cert_"
+				c.Mapping.TLD.Platform.ServiceAthenzDomains = []string{"_garm_validate_cert_._namespace_"}
+				return c
+			},
+			beforeFunc: func() {
+				os.Setenv("garm_validate_cert", "cert")
+			},
+			afterFunc: func() {
+				os.Unsetenv("garm_validate_cert")
+			},
+		},
+		{
+			name: "Check default metrics path conflicts with health check path",
+			cfg: func() Config {
+				c := validConfig()
+				c.Server.HealthzPath = "/metrics"
+				return c
+			},
+			want: []string{
+				`server.metrics_path: conflicts with server.health_check_path "/metrics"`,
+			},
+		},
+		{
+			name: "Check probe configuration",
+			cfg: func() Config {
+				c := validConfig()
+				c.Server.ReadinessPath = "/livez"
+				c.Server.Readiness = Readiness{
+					AthenzProbe:         true,
+					AthenzProbeInterval: "10",
+				}
+				return c
+			},
+			want: []string{
+				`server.readiness_path: conflicts with server.liveness_path "/livez"`,
+				`server.readiness.athenz_probe_interval: invalid duration "10"`,
+			},
+		},
+		{
+			name: "Check legacy list match pattern",
+			cfg: func() Config {
+				c := validConfig()
+				c.Mapping.TLD.Platform.LegacyListMatch = true
+				c.Mapping.TLD.Platform.WhiteList = append(c.Mapping.TLD.Platform.WhiteList, &RequestInfo{
+					Name: "(",
+				})
+				return c
+			},
+			want: []string{
+				"map_rule.tld.platform.white_list[1]: invalid legacy pattern: error parsing regexp: missing closing ): `----(`",
+			},
+		},
+		{
+			name: "Check ordered rules",
+			cfg: func() Config {
+				c := validConfig()
+				c.Mapping.TLD.Platform.DefaultAction = ActionCustomDomain
+				c.Mapping.TLD.Platform.Rules = []*Rule{
+					{
+						RequestInfo: RequestInfo{
+							Verb: "get",
+						},
+						Action: ActionAllowBypassAthenz,
+					},
+					nil,
+					{
+						Subresource: "regex:(",
+						Action:      "allow",
+					},
+					{
+						Action: ActionCustomDomain,
+					},
+					{
+						Action:  ActionCustomDomain,
+						Domains: []string{"_garm_validate_not_set_._namespace_"},
+					},
+					{},
+				}
+				return c
+			},
+			want: []string{
+				"map_rule.tld.platform.rules[1]: empty rule",
+				"map_rule.tld.platform.rules[2].subresource: invalid pattern: error parsing regexp: missing closing ): `^(?:()$`",
+				`map_rule.tld.platform.rules[2].action: unsupported action "allow"`,
+				`map_rule.tld.platform.rules[3].domains: no domains assigned`,
+				`map_rule.tld.platform.rules[4].domains[0]: environment variable garm_validate_not_set for "_garm_validate_not_set_" is not set`,
+				`map_rule.tld.platform.rules[5].action: no action assigned`,
+				`map_rule.tld.platform.default_action: unsupported action "custom-domain", want one of allow-bypass-athenz, deny, admin-domain, default-domain`,
+				`map_rule.tld.platform.rules: conflicts with admin_access_list, white_list and black_list`,
+			},
+		},
+		{
+			name: "Check rules without default action",
+			cfg: func() Config {
+				c := validConfig()
+				c.Mapping.TLD.Platform.WhiteList = nil
+				c.Mapping.TLD.Platform.Rules = []*Rule{
+					{
+						Action: ActionDeny,
+					},
+				}
+				return c
+			},
+			want: []string{
+				`map_rule.tld.platform.default_action: must be set to enable rules`,
+			},
+		},
+		{
+			name: "Check local policy evaluation without access tokens",
+			cfg: func() Config {
+				c := validConfig()
+				c.Athenz.Policy = Policy{
+					Enabled:         true,
+					Domains:         []string{"k8s"},
+					RefreshDuration: "1h",
+					ZTSPublicKeys: map[string]string{
+						"0": "/etc/garm/keys/zts.0.pem",
+					},
+					ZMSPublicKeys: map[string]string{
+						"0": "/etc/garm/keys/zms.0.pem",
+					},
+				}
+				return c
+			},
+			want: []string{
+				`athenz.policy.enabled: requires athenz.access_token.enabled, the roles are taken only from the access tokens`,
+			},
+		},
+		{
+			name: "Check every problem is reported",
+			cfg: func() Config {
+				c := validConfig()
+				c.Version = "v0.0.0"
+				c.Logger.LogTrace = "server,unknown"
+				c.Server.HealthzPort = 443
+				c.Server.Timeout = "5"
+				c.Server.ShutdownDuration = ""
+				c.Athenz.Timeout = "dummy"
+				c.Athenz.AthenzRootCA = "_garm_validate_not_set_"
+				c.Athenz.Cache = Cache{
+					Enabled:  true,
+					AllowTTL: "30s",
+					DenyTTL:  "-",
+				}
+				c.Athenz.AccessToken = AccessToken{
+					Enabled:   true,
+					RoleGroup: "k8s:admin",
+				}
+				c.Athenz.Certificate = Certificate{
+					Enabled:     true,
+					MaxLifetime: "1",
+				}
+				c.Athenz.Policy = Policy{
+					Enabled:         true,
+					RefreshDuration: "1h",
+					ZTSPublicKeys: map[string]string{
+						"0": "_garm_validate_not_set_",
+					},
+				}
+				c.Token.Expiration = "1x"
+				c.Reload = Reload{
+					Enabled:  true,
+					Interval: "",
+				}
+				c.Audit = Audit{
+					Enabled:    true,
+					Path:       "/var/log/garm/audit.log",
+					MaxSize:    -1,
+					MaxBackups: -1,
+				}
+				c.Mapping.TLD.Platform.ServiceAthenzDomains = []string{"k8s.domain", "_garm_validate_not_set_._namespace_"}
+				c.Mapping.TLD.Platform.AthenzServiceAccountPrefix = "_garm_validate_not_set_.sa."
+				c.Mapping.TLD.Platform.NamespaceDomains = []*NamespaceDomain{
+					{
+						Namespace: "team-*",
+						Domains:   []string{"team._match_._garm_validate_not_set_"},
+					},
+					nil,
+					{
+						Namespace: "regex:(",
+					},
+				}
+				c.Mapping.TLD.Platform.ResourceTemplate = "k8s:_resource_"
+				c.Mapping.Profiles = map[string]TLD{
+					"cluster-a": {
+						Platform: Platform{
+							ServiceAthenzDomains: []string{"cluster-a._namespace_"},
+						},
+					},
+					"cluster/b": {
+						Platform: Platform{
+							ServiceAthenzDomains: []string{"cluster-b"},
+						},
+					},
+				}
+				c.Mapping.TLD.Platform.SubresourceMappings = map[string]string{
+					"pods/exec":   "interactive",
+					"pods/":       "interactive",
+					"pods/exec/x": "interactive",
+				}
+				c.Mapping.TLD.Platform.BlackList = []*RequestInfo{
+					{
+						Verb: "get",
+					},
+					{
+						Name: "regex:(",
+					},
+				}
+				c.Mapping.TLD.Platform.AdminAccessList = []*RequestInfo{nil}
+				c.Mapping.TLD.Platform.EKS.UserMappings = map[string]string{
+					"kubernetes-admin": "",
+				}
+				c.Mapping.TLD.Platform.AKS.UserMappings = map[string]string{
+					"alice@corp.onmicrosoft.com": "",
+				}
+				c.Mapping.TLD.Platform.GroupMappings = []*GroupMapping{
+					nil,
+					{
+						DomainRegex: "(",
+					},
+				}
+				return c
+			},
+			want: []string{
+				`version: unsupported version "v0.0.0", want "v2.0.0"`,
+				`logger.log_trace: unsupported trace event "unknown"`,
+				`server.timeout: invalid duration "5"`,
+				`server.shutdown_duration: invalid duration ""`,
+				`server.health_check_port: conflicts with server.port 443`,
+				`athenz.timeout: invalid duration "dummy"`,
+				`athenz.root_ca: environment variable garm_validate_not_set for "_garm_validate_not_set_" is not set`,
+				`athenz.cache.deny_ttl: invalid duration "-"`,
+				`athenz.cache.size: must be positive, got 0`,
+				`athenz.access_token.refresh_duration: invalid duration ""`,
+				`athenz.access_token.issuer: must be set`,
+				`athenz.access_token.audiences: no audiences assigned`,
+				`athenz.access_token.role_group: "k8s:admin" does not contain _role_`,
+				`athenz.certificate.audience: must be set`,
+				`athenz.certificate.max_lifetime: invalid duration "1"`,
+				`athenz.policy.domains: no domains assigned`,
+				`athenz.policy.zts_public_keys["0"]: environment variable garm_validate_not_set for "_garm_validate_not_set_" is not set`,
+				`athenz.policy.zms_public_keys: no public keys assigned`,
+				`token.expiration: invalid duration "1x"`,
+				`reload.interval: invalid duration ""`,
+				`audit.max_size: must not be negative, got -1`,
+				`audit.max_backups: must not be negative, got -1`,
+				`map_rule.tld.platform.service_athenz_domains[1]: environment variable garm_validate_not_set for "_garm_validate_not_set_" is not set`,
+				`map_rule.tld.platform.namespace_domains[0].domains[0]: environment variable garm_validate_not_set for "_garm_validate_not_set_" is not set`,
+				`map_rule.tld.platform.namespace_domains[1]: empty rule`,
+				"map_rule.tld.platform.namespace_domains[2].namespace: invalid pattern: error parsing regexp: missing closing ): `^(?:()$`",
+				`map_rule.tld.platform.namespace_domains[2].domains: no domains assigned`,
+				`map_rule.tld.platform.athenz_service_account_prefix: environment variable garm_validate_not_set for "_garm_validate_not_set_" is not set`,
+				`map_rule.tld.platform.resource_template: "k8s:_resource_" does not contain _domain_`,
+				`map_rule.tld.platform.subresource_mappings["pods/"]: invalid key, want ${resource}/${subresource} or ${subresource}`,
+				`map_rule.tld.platform.subresource_mappings["pods/exec/x"]: invalid key, want ${resource}/${subresource} or ${subresource}`,
+				`map_rule.tld.platform.admin_access_list[0]: empty rule`,
+				"map_rule.tld.platform.black_list[1].name: invalid pattern: error parsing regexp: missing closing ): `^(?:()$`",
+				`map_rule.tld.platform.group_mappings[0]: empty rule`,
+				"map_rule.tld.platform.group_mappings[1].domain_regex: invalid pattern: error parsing regexp: missing closing ): `^(?:()$`",
+				`map_rule.tld.platform.group_mappings[1].groups: no groups assigned`,
+				`map_rule.tld.platform.eks.user_mappings["kubernetes-admin"]: empty principal`,
+				`map_rule.tld.platform.aks.user_mappings["alice@corp.onmicrosoft.com"]: empty principal`,
+				`map_rule.profiles["cluster/b"]: invalid profile name, must be non-empty and must not contain /`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.beforeFunc != nil {
+				tt.beforeFunc()
+			}
+			if tt.afterFunc != nil {
+				defer tt.afterFunc()
+			}
+
+			c := tt.cfg()
+			err := c.Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Validate() unexpected error: %v", err)
+				}
+				return
+			}
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Errorf("Validate() error = %v, want *ValidationError", err)
+				return
+			}
+			if !reflect.DeepEqual(verr.Problems, tt.want) {
+				t.Errorf("Validate() problems = %q, want %q", verr.Problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapping_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  func() Config
+		want []string
+	}{
+		{
+			name: "Check runtime settings are not checked",
+			cfg: func() Config {
+				c := validConfig()
+				c.Version = "v0.0.0"
+				c.Server.Timeout = "invalid"
+				c.Server.HealthzPort = c.Server.Port
+				c.Server.TLS.Cert = "_garm_validate_unset_"
+				c.Token.PrivateKey = "_garm_validate_unset_"
+				return c
+			},
+		},
+		{
+			name: "Check invalid mapping rules",
+			cfg: func() Config {
+				c := validConfig()
+				c.Server.Timeout = "invalid"
+				c.Mapping.TLD.Platform.ServiceAthenzDomains = []string{"k8s"}
+				c.Mapping.Profiles = map[string]TLD{
+					"dev": {
+						Platform: Platform{
+							BlackList: []*RequestInfo{
+								{
+									Verb: "regex:(",
+								},
+							},
+						},
+					},
+				}
+				return c
+			},
+			want: []string{
+				"map_rule.profiles[\"dev\"].tld.platform.black_list[0].verb: invalid pattern: error parsing regexp: missing closing ): `^(?:()$`",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.cfg()
+			err := c.Mapping.Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Validate() unexpected error: %v", err)
+				}
+				return
+			}
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Errorf("Validate() error = %v, want *ValidationError", err)
+				return
+			}
+			if !reflect.DeepEqual(verr.Problems, tt.want) {
+				t.Errorf("Validate() problems = %q, want %q", verr.Problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate_example(t *testing.T) {
+	b, err := ioutil.ReadFile("../k8s/garm-config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cm struct {
+		Data map[string]string `yaml:"data"`
+	}
+	if err = yaml.Unmarshal(b, &cm); err != nil {
+		t.Fatal(err)
+	}
+
+	// the environment variable placeholders in the example
+	envs := []string{"cert", "key", "root_ca", "athenz_domain", "service_name", "athenz_private_key"}
+	for _, e := range envs {
+		os.Setenv(e, "dummy")
+	}
+	defer func() {
+		for _, e := range envs {
+			os.Unsetenv(e)
+		}
+	}()
+
+	cfg, err := Parse([]byte(cm.Data["config.yaml"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Validate(); err != nil {
+		t.Errorf("Validate() example config error: %v", err)
+	}
+}
+
+func TestMapping_Warnings(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping Mapping
+		want    []string
+	}{
+		{
+			name: "Check literal fields matched longer values in the legacy way",
+			mapping: Mapping{
+				TLD: TLD{
+					Platform: Platform{
+						BlackList: []*RequestInfo{
+							{
+								Verb:      "get",
+								Namespace: "kube",
+								APIGroup:  "*",
+								Resource:  "secrets",
+								Name:      "*",
+							},
+						},
+					},
+				},
+				Profiles: map[string]TLD{
+					"dev": {
+						Platform: Platform{
+							WhiteList: []*RequestInfo{
+								{
+									Verb:      "get",
+									Namespace: "*",
+									Resource:  "pods",
+								},
+							},
+						},
+					},
+				},
+			},
+			want: []string{
+				`map_rule.tld.platform.black_list[0].namespace: "kube" matches only the whole value, but also matched "kube-x" with legacy_list_match`,
+				`map_rule.tld.platform.black_list[0].resource: "secrets" matches only the whole value, but also matched "secrets-x" with legacy_list_match`,
+				`map_rule.profiles["dev"].tld.platform.white_list[0].verb: "get" matches only the whole value, but also matched "get-x" with legacy_list_match`,
+				`map_rule.profiles["dev"].tld.platform.white_list[0].api_group: omitted field matches any value, but matched only an empty value with legacy_list_match, use 'regex:' to match only an empty value`,
+				`map_rule.profiles["dev"].tld.platform.white_list[0].resource: "pods" matches only the whole value, but also matched "pods-x" with legacy_list_match`,
+			},
+		},
+		{
+			name: "Check omitted fields matched only empty values in the legacy way",
+			mapping: Mapping{
+				TLD: TLD{
+					Platform: Platform{
+						WhiteList: []*RequestInfo{
+							{
+								Verb:     "*",
+								APIGroup: "*",
+								Resource: "*",
+								Name:     "*",
+							},
+						},
+						AdminAccessList: []*RequestInfo{
+							{
+								Verb:      "*",
+								Namespace: "*",
+								APIGroup:  "*",
+								Resource:  "*",
+							},
+						},
+					},
+				},
+			},
+			want: []string{
+				`map_rule.tld.platform.white_list[0].namespace: omitted field matches any value, but matched only an empty value with legacy_list_match, use 'regex:' to match only an empty value`,
+			},
+		},
+		{
+			name: "Check wildcards and regular expressions are skipped",
+			mapping: Mapping{
+				TLD: TLD{
+					Platform: Platform{
+						BlackList: []*RequestInfo{
+							{
+								Verb:      "*",
+								Namespace: "*",
+								APIGroup:  "*",
+								Resource:  "*",
+								Name:      "*",
+							},
+							{
+								Verb:      "regex:get|list",
+								Namespace: "kube",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Check service domains without namespace",
+			mapping: Mapping{
+				TLD: TLD{
+					Platform: Platform{
+						ServiceAthenzDomains: []string{"athenz.garm.user", "k8s._namespace_"},
+					},
+				},
+				Profiles: map[string]TLD{
+					"dev": {
+						Platform: Platform{
+							ServiceAthenzDomains: []string{"k8s.dev"},
+						},
+					},
+				},
+			},
+			want: []string{
+				`map_rule.tld.platform.service_athenz_domains[0]: "athenz.garm.user" does not contain _namespace_, every namespace is checked with the same domain`,
+				`map_rule.profiles["dev"].tld.platform.service_athenz_domains[0]: "k8s.dev" does not contain _namespace_, every namespace is checked with the same domain`,
+			},
+		},
+		{
+			name: "Check legacy_list_match is skipped",
+			mapping: Mapping{
+				TLD: TLD{
+					Platform: Platform{
+						LegacyListMatch: true,
+						BlackList: []*RequestInfo{
+							{
+								Verb:      "get",
+								Namespace: "kube",
+								Resource:  "*",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mapping.Warnings(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Warnings() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationError_Error(t *testing.T) {
+	err := &ValidationError{
+		Problems: []string{"a: reason a", "b: reason b"},
+	}
+	want := "invalid config:\n\ta: reason a\n\tb: reason b"
+	if got := err.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
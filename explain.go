@@ -0,0 +1,112 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/garm/config"
+	"github.com/yahoojapan/garm/service"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+const (
+	// explainCommand is the sub-command name to explain a SubjectAccessReview offline.
+	explainCommand = "explain"
+
+	// stdinPath represents reading from standard input.
+	stdinPath = "-"
+)
+
+// explain reads the SubjectAccessReview JSON from path, maps it with cfg, and writes the result to w.
+// If path is "-", the SubjectAccessReview is read from standard input.
+// If profile is not empty, the mapping rules of the named profile are used instead of the default profile.
+func explain(w io.Writer, cfg config.Mapping, path, profile string) error {
+	if profile != "" {
+		tld, ok := cfg.Profiles[profile]
+		if !ok {
+			return errors.Errorf("unknown mapping profile %q", profile)
+		}
+		cfg = config.Mapping{
+			TLD: tld,
+		}
+	}
+
+	spec, err := readSubjectAccessReviewSpec(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read SubjectAccessReview")
+	}
+
+	e, err := service.Explain(context.Background(), cfg, *spec)
+	if err != nil {
+		return errors.Wrap(err, "failed to map SubjectAccessReview")
+	}
+
+	fmt.Fprintf(w, "User:\t%s\nGroups:\t%v\n", spec.User, spec.Groups)
+	fmt.Fprintf(w, "Identity:\t%s\n", e.Identity)
+	fmt.Fprintf(w, "Verb:\t%s\nNamespace:\t%s\nAPI Group:\t%s\nResource:\t%s\nResource Name:\t%s\n",
+		e.Verb, e.Namespace, e.APIGroup, e.Resource, e.Name)
+	if cfg.TLD.Platform.DefaultAction == "" {
+		fmt.Fprintf(w, "White list:\t%v\nBlack list:\t%v\nList decision:\t%s\n", e.WhiteListed, e.BlackListed, e.ListDecision())
+		fmt.Fprintf(w, "Admin access:\t%v\n", e.AdminAccess)
+	} else {
+		fmt.Fprintf(w, "Matched rule:\t%s\n", strings.Join(e.Rules, ", "))
+	}
+	fmt.Fprintf(w, "Action:\t%s\n", e.Action)
+	if e.Err != nil {
+		fmt.Fprintf(w, "Mapping error:\t%v\n", e.Err)
+		return nil
+	}
+	fmt.Fprintf(w, "Athenz access checks:\n")
+	for _, c := range e.AccessChecks {
+		fmt.Fprintf(w, "\taction: %s\tresource: %s\n", c.Action, c.Resource)
+	}
+	return nil
+}
+
+// readSubjectAccessReviewSpec decodes the v1 or v1beta1 SubjectAccessReview JSON in path, and returns its spec.
+func readSubjectAccessReviewSpec(path string) (*authz.SubjectAccessReviewSpec, error) {
+	var r io.Reader = os.Stdin
+	if path != stdinPath {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "file open failed")
+		}
+		defer f.Close()
+		r = f
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read failed")
+	}
+
+	sar, err := service.DecodeSubjectAccessReview(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "json parse failed")
+	}
+	if sar.Spec.ResourceAttributes == nil && sar.Spec.NonResourceAttributes == nil {
+		return nil, errors.New("bad authorization spec, must have one of resource or non-resource attributes")
+	}
+	return &sar.Spec, nil
+}
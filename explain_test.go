@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/garm/config"
+)
+
+func Test_explain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cfg := config.Mapping{
+		TLD: config.TLD{
+			Platform: config.Platform{
+				ServiceAthenzDomains: []string{"k8s._namespace_"},
+				AthenzUserPrefix:     "user.",
+				BlackList: []*config.RequestInfo{
+					{
+						Verb:      "delete",
+						Namespace: "*",
+						APIGroup:  "*",
+						Resource:  "*",
+						Name:      "*",
+					},
+				},
+			},
+		},
+		Profiles: map[string]config.TLD{
+			"cluster-a": {
+				Platform: config.Platform{
+					ServiceAthenzDomains: []string{"cluster-a._namespace_"},
+					AthenzUserPrefix:     "user.",
+				},
+			},
+		},
+	}
+
+	type args struct {
+		path    string
+		profile string
+	}
+	type test struct {
+		name    string
+		args    args
+		want    string
+		wantErr error
+	}
+	tests := []test{
+		{
+			name: "explain allowed request",
+			args: args{
+				path: writeFile("allowed.json", `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","spec":{"resourceAttributes":{"namespace":"ns","verb":"get","resource":"pods"},"user":"alice"}}`),
+			},
+			want: "User:\talice\nGroups:\t[]\n" +
+				"Identity:\tuser.alice\n" +
+				"Verb:\tget\nNamespace:\tns\nAPI Group:\t\nResource:\tpods\nResource Name:\t\n" +
+				"White list:\tfalse\nBlack list:\tfalse\nList decision:\tallowed, not in black_list\n" +
+				"Admin access:\tfalse\n" +
+				"Action:\tdefault-domain\n" +
+				"Athenz access checks:\n" +
+				"\taction: get\tresource: k8s.ns:pods\n",
+		},
+		{
+			name: "explain v1 request",
+			args: args{
+				path: writeFile("v1.json", `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"resourceAttributes":{"namespace":"ns","verb":"get","resource":"pods"},"user":"alice","groups":["dev"]}}`),
+			},
+			want: "User:\talice\nGroups:\t[dev]\n" +
+				"Identity:\tuser.alice\n" +
+				"Verb:\tget\nNamespace:\tns\nAPI Group:\t\nResource:\tpods\nResource Name:\t\n" +
+				"White list:\tfalse\nBlack list:\tfalse\nList decision:\tallowed, not in black_list\n" +
+				"Admin access:\tfalse\n" +
+				"Action:\tdefault-domain\n" +
+				"Athenz access checks:\n" +
+				"\taction: get\tresource: k8s.ns:pods\n",
+		},
+		{
+			name: "explain rejected request",
+			args: args{
+				path: writeFile("rejected.json", `{"spec":{"resourceAttributes":{"namespace":"ns","verb":"delete","resource":"pods"},"user":"alice"}}`),
+			},
+			want: "User:\talice\nGroups:\t[]\n" +
+				"Identity:\tuser.alice\n" +
+				"Verb:\tdelete\nNamespace:\tns\nAPI Group:\t\nResource:\tpods\nResource Name:\t\n" +
+				"White list:\tfalse\nBlack list:\ttrue\nList decision:\trejected by black_list\n" +
+				"Admin access:\tfalse\n" +
+				"Action:\tdeny\n" +
+				"Mapping error:\t----user.alice's request is not allowed----\nVerb:\tdelete\nNamespace:\tns\nAPI Group:\t\nResource:\tpods\nResource Name:\t\n\n",
+		},
+		{
+			name: "explain request with profile",
+			args: args{
+				path:    writeFile("profile.json", `{"spec":{"resourceAttributes":{"namespace":"ns","verb":"delete","resource":"pods"},"user":"alice"}}`),
+				profile: "cluster-a",
+			},
+			want: "User:\talice\nGroups:\t[]\n" +
+				"Identity:\tuser.alice\n" +
+				"Verb:\tdelete\nNamespace:\tns\nAPI Group:\t\nResource:\tpods\nResource Name:\t\n" +
+				"White list:\tfalse\nBlack list:\tfalse\nList decision:\tallowed, not in black_list\n" +
+				"Admin access:\tfalse\n" +
+				"Action:\tdefault-domain\n" +
+				"Athenz access checks:\n" +
+				"\taction: delete\tresource: cluster-a.ns:pods\n",
+		},
+		{
+			name: "explain unknown profile",
+			args: args{
+				path:    writeFile("unknown.json", `{}`),
+				profile: "cluster-b",
+			},
+			wantErr: errors.New(`unknown mapping profile "cluster-b"`),
+		},
+		{
+			name: "explain invalid json",
+			args: args{
+				path: writeFile("invalid.json", `{`),
+			},
+			wantErr: errors.New("failed to read SubjectAccessReview: json parse failed: unexpected end of JSON input"),
+		},
+		{
+			name: "explain empty spec",
+			args: args{
+				path: writeFile("empty.json", `{}`),
+			},
+			wantErr: errors.New("failed to read SubjectAccessReview: bad authorization spec, must have one of resource or non-resource attributes"),
+		},
+		{
+			name: "explain file not found",
+			args: args{
+				path: filepath.Join(dir, "notexists.json"),
+			},
+			wantErr: errors.Errorf("failed to read SubjectAccessReview: file open failed: open %s: no such file or directory", filepath.Join(dir, "notexists.json")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := new(bytes.Buffer)
+			err := explain(w, cfg, tt.args.path, tt.args.profile)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("explain() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("explain() unexpected error: %v", err)
+				return
+			}
+			if got := w.String(); got != tt.want {
+				t.Errorf("explain() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -36,24 +36,48 @@ var Version string
 
 // params is the data model for Garm command line arguments.
 type params struct {
+	command        string
 	configFilePath string
 	showVersion    bool
+	sarFilePath    string
+	profile        string
 }
 
 // parseParams parses command line arguments to params object.
+// If the first argument is a sub-command (e.g. "explain"), the flags following it are parsed for the sub-command.
 func parseParams() (*params, error) {
 	p := new(params)
-	f := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	args := os.Args[1:]
+	name := filepath.Base(os.Args[0])
+	if len(args) > 0 && (args[0] == explainCommand || args[0] == validateCommand) {
+		p.command = args[0]
+		name += " " + args[0]
+		args = args[1:]
+	}
+
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
 	f.StringVar(&p.configFilePath,
 		"f",
 		"/etc/garm/config.yaml",
 		"garm config yaml file path")
-	f.BoolVar(&p.showVersion,
-		"version",
-		false,
-		"show garm version")
+	switch p.command {
+	case explainCommand:
+		f.StringVar(&p.sarFilePath,
+			"i",
+			stdinPath,
+			"SubjectAccessReview json file path, \"-\" to read from standard input")
+		f.StringVar(&p.profile,
+			"p",
+			"",
+			"mapping profile name in map_rule.profiles, empty to use map_rule.tld")
+	case "":
+		f.BoolVar(&p.showVersion,
+			"version",
+			false,
+			"show garm version")
+	}
 
-	err := f.Parse(os.Args[1:])
+	err := f.Parse(args)
 	if err != nil {
 		return nil, errors.Wrap(err, "Parse Failed")
 	}
@@ -134,15 +158,38 @@ func main() {
 		return
 	}
 
+	if p.command == validateCommand {
+		err = validate(os.Stdout, p.configFilePath)
+		if err != nil {
+			glg.Fatal(err)
+		}
+		return
+	}
+
 	cfg, err := config.New(p.configFilePath)
 	if err != nil {
 		glg.Fatal(err)
 		return
 	}
 
-	// check versions between configuration file and config.go
-	if cfg.Version != config.GetVersion() {
-		glg.Fatal(errors.New("invalid garm config version"))
+	if p.command == explainCommand {
+		// explain runs offline, hence, only the mapping rules are checked
+		err = cfg.Mapping.Validate()
+		if err != nil {
+			glg.Fatal(err)
+			return
+		}
+		err = explain(os.Stdout, cfg.Mapping, p.sarFilePath, p.profile)
+		if err != nil {
+			glg.Fatal(err)
+		}
+		return
+	}
+
+	// check versions between configuration file and config.go, and all other configuration values
+	err = cfg.Validate()
+	if err != nil {
+		glg.Fatal(err)
 		return
 	}
 
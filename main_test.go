@@ -65,6 +65,63 @@ func TestParseParams(t *testing.T) {
 				checkErr: false,
 			}
 		}(),
+		func() test {
+			return test{
+				name: "check parseParams set explain sub-command flags",
+				beforeFunc: func() {
+					os.Args = []string{"", "explain", "-f", "/dummy/path", "-i", "/dummy/sar.json", "-p", "cluster-a"}
+				},
+				checkFunc: func(p *params) error {
+					if p.command != explainCommand {
+						return errors.Errorf("unexpected command. got: %s, want: %s", p.command, explainCommand)
+					}
+					if p.configFilePath != "/dummy/path" {
+						return errors.Errorf("unexpected file path. got: %s, want: /dummy/path", p.configFilePath)
+					}
+					if p.sarFilePath != "/dummy/sar.json" {
+						return errors.Errorf("unexpected sar file path. got: %s, want: /dummy/sar.json", p.sarFilePath)
+					}
+					if p.profile != "cluster-a" {
+						return errors.Errorf("unexpected profile. got: %s, want: cluster-a", p.profile)
+					}
+					return nil
+				},
+				checkErr: false,
+			}
+		}(),
+		func() test {
+			return test{
+				name: "check parseParams explain sub-command read from stdin by default",
+				beforeFunc: func() {
+					os.Args = []string{"", "explain"}
+				},
+				checkFunc: func(p *params) error {
+					if p.sarFilePath != stdinPath {
+						return errors.Errorf("unexpected sar file path. got: %s, want: %s", p.sarFilePath, stdinPath)
+					}
+					return nil
+				},
+				checkErr: false,
+			}
+		}(),
+		func() test {
+			return test{
+				name: "check parseParams set validate sub-command flags",
+				beforeFunc: func() {
+					os.Args = []string{"", "validate", "-f", "/dummy/path"}
+				},
+				checkFunc: func(p *params) error {
+					if p.command != validateCommand {
+						return errors.Errorf("unexpected command. got: %s, want: %s", p.command, validateCommand)
+					}
+					if p.configFilePath != "/dummy/path" {
+						return errors.Errorf("unexpected file path. got: %s, want: /dummy/path", p.configFilePath)
+					}
+					return nil
+				},
+				checkErr: false,
+			}
+		}(),
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
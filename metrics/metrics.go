@@ -0,0 +1,160 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// namespace is the prefix of all Garm metric names.
+	namespace = "garm"
+
+	// EndpointAuthn represents the authentication webhook endpoint label value.
+	EndpointAuthn = "authn"
+
+	// EndpointAuthz represents the authorization webhook endpoint label value.
+	EndpointAuthz = "authz"
+)
+
+// Outcome represents the result of a webhook request.
+type Outcome string
+
+const (
+	// OutcomeAllowed represents the request is authenticated or authorized.
+	OutcomeAllowed Outcome = "allowed"
+
+	// OutcomeDenied represents the request is rejected by Athenz.
+	OutcomeDenied Outcome = "denied"
+
+	// OutcomeBlacklisted represents the request is rejected by black_list without querying Athenz.
+	OutcomeBlacklisted Outcome = "blacklisted"
+
+	// OutcomeError represents the request failed because of an invalid request or an internal error.
+	OutcomeError Outcome = "error"
+
+	// OutcomeTimeout represents the request handling exceeded the server timeout.
+	OutcomeTimeout Outcome = "timeout"
+)
+
+var (
+	// registry holds all Garm metrics.
+	registry = prometheus.NewRegistry()
+
+	// startedAt is the time of initialization, used as the token age origin before the first token refresh.
+	startedAt = time.Now().UnixNano()
+
+	// tokenRefreshedAt is the last successful token refresh time in Unix nanoseconds.
+	tokenRefreshedAt = startedAt
+
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Total number of webhook requests by endpoint and outcome.",
+	}, []string{"endpoint", "outcome"})
+
+	adminAccess = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "admin_access_total",
+		Help:      "Total number of authorization requests mapped to the Athenz admin domain.",
+	})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "End-to-end webhook request handling latency by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	athenzDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "athenz_request_duration_seconds",
+		Help:      "Latency of the Athenz checks of a webhook request by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	tokenRefresh = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refresh_total",
+		Help:      "Total number of n-token refreshes by result.",
+	}, []string{"result"})
+
+	tokenAge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "token_age_seconds",
+		Help:      "Seconds since the last successful n-token refresh, or since Garm started if no token is loaded yet.",
+	}, func() float64 {
+		return time.Since(time.Unix(0, atomic.LoadInt64(&tokenRefreshedAt))).Seconds()
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		requests,
+		adminAccess,
+		requestDuration,
+		athenzDuration,
+		tokenRefresh,
+		tokenAge,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	// expose the refresh failure count from the beginning, for alerting on increase
+	tokenRefresh.WithLabelValues("success")
+	tokenRefresh.WithLabelValues("failure")
+}
+
+// Handler returns the HTTP handler exposing all Garm metrics in Prometheus format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest counts a webhook request with its outcome.
+func ObserveRequest(endpoint string, outcome Outcome) {
+	requests.WithLabelValues(endpoint, string(outcome)).Inc()
+}
+
+// ObserveAdminAccess counts an authorization request mapped to the Athenz admin domain.
+func ObserveAdminAccess() {
+	adminAccess.Inc()
+}
+
+// ObserveRequestDuration records the end-to-end handling latency of a webhook request.
+func ObserveRequestDuration(endpoint string, d time.Duration) {
+	requestDuration.WithLabelValues(endpoint).Observe(d.Seconds())
+}
+
+// ObserveAthenzDuration records the latency of the Athenz checks of a webhook request.
+func ObserveAthenzDuration(endpoint string, d time.Duration) {
+	athenzDuration.WithLabelValues(endpoint).Observe(d.Seconds())
+}
+
+// ObserveTokenRefresh counts a token refresh by its result, and resets the token age on success.
+func ObserveTokenRefresh(err error) {
+	if err != nil {
+		tokenRefresh.WithLabelValues("failure").Inc()
+		return
+	}
+	tokenRefresh.WithLabelValues("success").Inc()
+	atomic.StoreInt64(&tokenRefreshedAt, time.Now().UnixNano())
+}
@@ -0,0 +1,142 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHandler(t *testing.T) {
+	ObserveRequest(EndpointAuthz, OutcomeAllowed)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Handler() code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	for _, want := range []string{
+		`garm_requests_total{endpoint="authz",outcome="allowed"}`,
+		"garm_admin_access_total",
+		"garm_token_refresh_total",
+		"garm_token_age_seconds",
+		"go_goroutines",
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("Handler() body does not contain %v", want)
+		}
+	}
+}
+
+func TestObserveRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		outcome  Outcome
+	}{
+		{
+			name:     "Check authn allowed is counted",
+			endpoint: EndpointAuthn,
+			outcome:  OutcomeAllowed,
+		},
+		{
+			name:     "Check authz blacklisted is counted",
+			endpoint: EndpointAuthz,
+			outcome:  OutcomeBlacklisted,
+		},
+		{
+			name:     "Check authz timeout is counted",
+			endpoint: EndpointAuthz,
+			outcome:  OutcomeTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := requests.WithLabelValues(tt.endpoint, string(tt.outcome))
+			before := testutil.ToFloat64(c)
+			ObserveRequest(tt.endpoint, tt.outcome)
+			if got := testutil.ToFloat64(c) - before; got != 1 {
+				t.Errorf("ObserveRequest() increased = %v, want %v", got, 1)
+			}
+		})
+	}
+}
+
+func TestObserveAdminAccess(t *testing.T) {
+	before := testutil.ToFloat64(adminAccess)
+	ObserveAdminAccess()
+	if got := testutil.ToFloat64(adminAccess) - before; got != 1 {
+		t.Errorf("ObserveAdminAccess() increased = %v, want %v", got, 1)
+	}
+}
+
+func TestObserveRequestDuration(t *testing.T) {
+	ObserveRequestDuration(EndpointAuthn, time.Millisecond)
+	if got := testutil.CollectAndCount(requestDuration); got == 0 {
+		t.Errorf("ObserveRequestDuration() collected = %v, want > 0", got)
+	}
+}
+
+func TestObserveAthenzDuration(t *testing.T) {
+	ObserveAthenzDuration(EndpointAuthz, time.Millisecond)
+	if got := testutil.CollectAndCount(athenzDuration); got == 0 {
+		t.Errorf("ObserveAthenzDuration() collected = %v, want > 0", got)
+	}
+}
+
+func TestObserveTokenRefresh(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		result      string
+		wantRefresh bool
+	}{
+		{
+			name:   "Check failure keeps the token age",
+			err:    errors.New("dummy"),
+			result: "failure",
+		},
+		{
+			name:        "Check success resets the token age",
+			result:      "success",
+			wantRefresh: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tokenRefresh.WithLabelValues(tt.result)
+			before := testutil.ToFloat64(c)
+			time.Sleep(time.Millisecond * 10)
+			age := testutil.ToFloat64(tokenAge)
+
+			ObserveTokenRefresh(tt.err)
+
+			if got := testutil.ToFloat64(c) - before; got != 1 {
+				t.Errorf("ObserveTokenRefresh() %s increased = %v, want %v", tt.result, got, 1)
+			}
+			if got := testutil.ToFloat64(tokenAge); (got < age) != tt.wantRefresh {
+				t.Errorf("ObserveTokenRefresh() token age = %v, before = %v, want refreshed %v", got, age, tt.wantRefresh)
+			}
+		})
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/yahoojapan/garm/config"
 	"github.com/yahoojapan/garm/handler"
+	"github.com/yahoojapan/garm/metrics"
 )
 
 // New returns ServeMux with routes using given handler.
@@ -39,9 +40,9 @@ func New(cfg config.Server, h handler.Handler) *http.ServeMux {
 	mux := http.NewServeMux()
 	dur := parseTimeout(cfg.Timeout)
 
-	// register (route, handler) tuple to server multiplexer
+	// register (route, handler) tuple to server multiplexer, the routes with a profile name share the endpoint label, e.g. "authz"
 	for _, route := range NewRoutes(h) {
-		mux.Handle(route.Pattern, recoverWrap(routing(route.Methods, dur, route.HandlerFunc)))
+		mux.Handle(route.Pattern, recoverWrap(routing(strings.Trim(route.Pattern, "/"), route.Methods, dur, route.HandlerFunc)))
 	}
 
 	return mux
@@ -63,7 +64,8 @@ func parseTimeout(timeout string) time.Duration {
 
 // routing wraps the handler.Func and returns a new http.Handler.
 // routing helps to handle unsupported HTTP method, timeout, and the error returned from the handler.Func.
-func routing(m []string, t time.Duration, h handler.Func) http.Handler {
+// The handling latency and timeout are recorded to the metrics of the given endpoint.
+func routing(endpoint string, m []string, t time.Duration, h handler.Func) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		for _, method := range m {
 			if strings.EqualFold(r.Method, method) {
@@ -73,6 +75,9 @@ func routing(m []string, t time.Duration, h handler.Func) http.Handler {
 				ctx, cancel := context.WithTimeout(r.Context(), t)
 				defer cancel()
 				start := time.Now()
+				defer func() {
+					metrics.ObserveRequestDuration(endpoint, time.Since(start))
+				}()
 
 				// run the custom handler logic in go routine, report error to error channel
 				ech := make(chan error)
@@ -113,6 +118,7 @@ func routing(m []string, t time.Duration, h handler.Func) http.Handler {
 						return
 					case <-ctx.Done():
 						// timeout passed or parent context canceled first, it is the responsibility for handler to response to the user
+						metrics.ObserveRequest(endpoint, metrics.OutcomeTimeout)
 						err := glg.Errorf("Handler Time Out: %v", time.Since(start))
 						if err != nil {
 							glg.Fatal(errors.Wrap(err, "timeout error output failed"))
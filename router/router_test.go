@@ -152,6 +152,23 @@ func TestNewServeMux(t *testing.T) {
 						return fmt.Errorf("New() ServeMux on request %v, response body = %v, want %v", request, string(gotByte), want)
 					}
 
+					// Authorize request with mapping profile
+					recorder = httptest.NewRecorder()
+					request, err = http.NewRequest(http.MethodPost, "/authz/cluster-a", nil)
+					if err != nil {
+						return
+					}
+					serveMux.ServeHTTP(recorder, request)
+					response = recorder.Result()
+					defer response.Body.Close()
+					gotByte, err = ioutil.ReadAll(response.Body)
+					if err != nil {
+						return
+					}
+					if string(gotByte) != want {
+						return fmt.Errorf("New() ServeMux on request %v, response body = %v, want %v", request, string(gotByte), want)
+					}
+
 					return nil
 				},
 			}
@@ -202,9 +219,10 @@ func Test_parseTimeout(t *testing.T) {
 
 func Test_routing(t *testing.T) {
 	type args struct {
-		m []string
-		t time.Duration
-		h handler.Func
+		endpoint string
+		m        []string
+		t        time.Duration
+		h        handler.Func
 	}
 	type testcase struct {
 		name      string
@@ -656,7 +674,7 @@ func Test_routing(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := routing(tt.args.m, tt.args.t, tt.args.h)
+			got := routing(tt.args.endpoint, tt.args.m, tt.args.t, tt.args.h)
 			if err := tt.checkFunc(got); err != nil {
 				t.Error(err)
 			}
@@ -33,6 +33,7 @@ type Route struct {
 // NewRoutes returns routes defined for handling authenticate and authorize requests.
 // The authenticate requests will accept for only HTTP POST requests, and the endpoint is /authn.
 // The authorize requests will accept for only HTTP POST requests, and the endpoint is /authz.
+// The endpoints with a mapping profile name, /authn/<profile> and /authz/<profile>, are handled by the same handlers.
 func NewRoutes(h handler.Handler) []Route {
 	return []Route{
 		{
@@ -51,5 +52,21 @@ func NewRoutes(h handler.Handler) []Route {
 			"/authz",
 			h.Authorize,
 		},
+		{
+			"AuthenticateProfile",
+			[]string{
+				http.MethodPost,
+			},
+			"/authn/",
+			h.Authenticate,
+		},
+		{
+			"AuthorizeProfile",
+			[]string{
+				http.MethodPost,
+			},
+			"/authz/",
+			h.Authorize,
+		},
 	}
 }
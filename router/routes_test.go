@@ -45,6 +45,9 @@ func TestNewRoutes(t *testing.T) {
 					h: h,
 				},
 				checkFunc: func(got, want []Route) error {
+					if len(got) != len(want) {
+						return fmt.Errorf("got %d routes, want %d", len(got), len(want))
+					}
 					for i, g := range got {
 						w := want[i]
 						if g.Name != w.Name || !reflect.DeepEqual(g.Methods, w.Methods) || g.Pattern != w.Pattern ||
@@ -72,6 +75,22 @@ func TestNewRoutes(t *testing.T) {
 						"/authz",
 						h.Authorize,
 					},
+					{
+						"AuthenticateProfile",
+						[]string{
+							http.MethodPost,
+						},
+						"/authn/",
+						h.Authenticate,
+					},
+					{
+						"AuthorizeProfile",
+						[]string{
+							http.MethodPost,
+						},
+						"/authz/",
+						h.Authorize,
+					},
 				},
 			}
 		}(),
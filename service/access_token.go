@@ -0,0 +1,493 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
+	authn "k8s.io/api/authentication/v1beta1"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+const (
+	// jwksRetryInterval is the minimum duration between the JSON Web Key Set refreshes triggered by an unknown key ID.
+	jwksRetryInterval = time.Second * 10
+
+	// extraClientID is the key of the UserInfo extra field holding the "client_id" claim of the access token.
+	extraClientID = "athenz.io/client-id"
+
+	// extraScope is the key of the UserInfo extra field holding the roles of the access token.
+	extraScope = "athenz.io/scope"
+
+	// extraAudience is the key of the UserInfo extra field holding the domain of the roles of the access token.
+	extraAudience = "athenz.io/audience"
+
+	// extraScopeSignature is the key of the UserInfo extra field holding the signature of the roles of the access token (see scopeSigner).
+	extraScopeSignature = "athenz.io/scope-signature"
+)
+
+var (
+	// accessTokenMethods are the signing methods of the Athenz access tokens.
+	accessTokenMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+)
+
+// jwks caches the public keys of the ZTS JSON Web Key Set.
+// The keys are refreshed after the refresh duration, or on an unknown key ID.
+type jwks struct {
+	mu sync.RWMutex
+	// url is the JSON Web Key Set URL.
+	url string
+	// client sends the JSON Web Key Set requests.
+	client *http.Client
+	// refresh is the duration between each refresh.
+	refresh time.Duration
+	// keys are the public keys indexed by key ID.
+	keys map[string]interface{}
+	// checkedAt is the time of the last refresh, successful or not.
+	checkedAt time.Time
+	// fetch serializes the refreshes.
+	fetch sync.Mutex
+}
+
+// jsonWebKey is a RSA or EC public key of the JSON Web Key Set.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// accessTokenClaims are the claims of the Athenz access token.
+type accessTokenClaims struct {
+	jwt.RegisteredClaims
+	// ClientID is the principal of the client requested the access token.
+	ClientID string `json:"client_id,omitempty"`
+	// Scope is the role names of the audience domain granted to the subject.
+	Scope []string `json:"scp,omitempty"`
+}
+
+// accessTokenAuthenticator is a http.Handler verifying the Athenz access tokens locally, and passing other tokens to the Athenz authenticator.
+type accessTokenAuthenticator struct {
+	// next is the Athenz authenticator.
+	next http.Handler
+	// keys are the public keys verifying the signature.
+	keys *jwks
+	// parser verifies the signature and the time based claims.
+	parser *jwt.Parser
+	// issuer is the expected "iss" claim.
+	issuer string
+	// audiences are the accepted "aud" claims.
+	audiences []string
+	// roleGroup is the template of the K8s group of each role.
+	roleGroup string
+	// mapper maps the subject to the K8s user.
+	mapper webhook.UserMapper
+	// signer signs the roles for the policy authorizer.
+	signer *scopeSigner
+	// lp creates the logger outputting the authentication outcome with the request ID.
+	lp webhook.LogProvider
+}
+
+// scopeSigner signs the roles of the verified access tokens, and verifies them in the SubjectAccessReview.
+// The K8s groups and extra fields can be set by any authenticator of the K8s API server, hence, only the signed roles are trusted.
+// The key is generated on start, the roles signed by other Garm processes are not trusted.
+type scopeSigner struct {
+	key []byte
+}
+
+// newScopeSigner returns a scopeSigner with a random key.
+func newScopeSigner() (*scopeSigner, error) {
+	key := make([]byte, sha256.Size)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "scope signing key generation failed")
+	}
+	return &scopeSigner{key: key}, nil
+}
+
+// sign returns the signature of the roles of the domain held by the user until exp, in the format "${exp}.${mac}".
+func (s *scopeSigner) sign(user, domain string, roles []string, exp time.Time) string {
+	e := strconv.FormatInt(exp.Unix(), 10)
+	return e + "." + base64.RawURLEncoding.EncodeToString(s.mac(user, domain, e, roles))
+}
+
+// verify returns the domain and the roles in the extra fields if they are signed for the user and not expired.
+func (s *scopeSigner) verify(user string, extra map[string]authz.ExtraValue) (string, []string, bool) {
+	domain, sig := extra[extraAudience], extra[extraScopeSignature]
+	if len(domain) != 1 || len(sig) != 1 {
+		return "", nil, false
+	}
+	i := strings.Index(sig[0], ".")
+	if i < 0 {
+		return "", nil, false
+	}
+	exp, err := strconv.ParseInt(sig[0][:i], 10, 64)
+	if err != nil || !time.Now().Before(time.Unix(exp, 0)) {
+		return "", nil, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig[0][i+1:])
+	roles := []string(extra[extraScope])
+	if err != nil || !hmac.Equal(mac, s.mac(user, domain[0], sig[0][:i], roles)) {
+		return "", nil, false
+	}
+	return domain[0], roles, true
+}
+
+// mac returns the HMAC-SHA256 of the user, domain, expiry and roles.
+func (s *scopeSigner) mac(user, domain, exp string, roles []string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(strings.Join(append([]string{user, domain, exp}, roles...), "\x00")))
+	return h.Sum(nil)
+}
+
+// newAccessTokenAuthenticator returns a http.Handler authenticating the Athenz access tokens with the ZTS public keys, and passing other tokens to next.
+// The roles of the access tokens are signed by signer for the policy authorizer.
+func newAccessTokenAuthenticator(next http.Handler, cfg config.Athenz, signer *scopeSigner, lp webhook.LogProvider) (http.Handler, error) {
+	refresh, err := time.ParseDuration(cfg.AccessToken.RefreshDuration)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid access token refresh duration %s", cfg.AccessToken.RefreshDuration)
+	}
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid athenz timeout %s", cfg.Timeout)
+	}
+
+	tcfg := new(tls.Config)
+	if cfg.AthenzRootCA != "" {
+		tcfg.RootCAs, err = NewX509CertPool(config.GetActualValue(cfg.AthenzRootCA))
+		if err != nil {
+			return nil, errors.Wrap(err, "access token x509 certpool error")
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tcfg
+
+	return &accessTokenAuthenticator{
+		next: next,
+		keys: &jwks{
+			url: cfg.AccessToken.GetJWKSURL(cfg.URL),
+			client: &http.Client{
+				Timeout:   timeout,
+				Transport: transport,
+			},
+			refresh: refresh,
+		},
+		parser:    jwt.NewParser(jwt.WithValidMethods(accessTokenMethods)),
+		issuer:    cfg.AccessToken.Issuer,
+		audiences: cfg.AccessToken.Audiences,
+		roleGroup: cfg.AccessToken.GetRoleGroup(),
+		mapper:    cfg.AuthN.Mapper,
+		signer:    signer,
+		lp:        lp,
+	}, nil
+}
+
+// ServeHTTP answers the TokenReview of an access token, other requests are passed to next.
+func (a *accessTokenAuthenticator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tr, ok := readTokenReview(w, r)
+	if !ok {
+		return
+	}
+	if tr == nil || !isJWT(tr.Spec.Token) {
+		// let the authenticator handle the n-token or report the error
+		a.next.ServeHTTP(w, r)
+		return
+	}
+
+	status := a.authenticate(r.Context(), tr.Spec.Token)
+	logTokenReview(a.lp(requestIDFrom(r.Context())), "access token", r, status)
+	writeTokenReview(w, tr, status)
+}
+
+// authenticate verifies the access token, and returns the TokenReviewStatus of the subject.
+// The subject is mapped to the K8s user by the user mapper, and each role is appended to the groups.
+func (a *accessTokenAuthenticator) authenticate(ctx context.Context, token string) authn.TokenReviewStatus {
+	claims, err := a.verify(token)
+	if err != nil {
+		return authn.TokenReviewStatus{Error: err.Error()}
+	}
+
+	i := strings.LastIndex(claims.Subject, ".")
+	if i <= 0 || i == len(claims.Subject)-1 {
+		return authn.TokenReviewStatus{Error: fmt.Sprintf("invalid access token subject %q", claims.Subject)}
+	}
+	u, err := a.mapper.MapUser(ctx, claims.Subject[:i], claims.Subject[i+1:])
+	if err != nil {
+		return authn.TokenReviewStatus{Error: err.Error()}
+	}
+
+	// verify accepts only the access token of an accepted audience
+	domain := claims.Audience[0]
+	for _, role := range claims.Scope {
+		u.Groups = append(u.Groups, strings.NewReplacer("_domain_", domain, "_role_", role).Replace(a.roleGroup))
+	}
+	if claims.ClientID != "" || len(claims.Scope) != 0 {
+		if u.Extra == nil {
+			u.Extra = make(map[string]authn.ExtraValue, 4)
+		}
+		if claims.ClientID != "" {
+			u.Extra[extraClientID] = authn.ExtraValue{claims.ClientID}
+		}
+		if len(claims.Scope) != 0 {
+			u.Extra[extraScope] = authn.ExtraValue(claims.Scope)
+			u.Extra[extraAudience] = authn.ExtraValue{domain}
+			u.Extra[extraScopeSignature] = authn.ExtraValue{a.signer.sign(u.Username, domain, claims.Scope, claims.ExpiresAt.Time)}
+		}
+	}
+
+	return authn.TokenReviewStatus{
+		Authenticated: true,
+		User:          u,
+	}
+}
+
+// verify checks the signature, expiry, issuer and audience of the access token, and returns its claims.
+func (a *accessTokenAuthenticator) verify(token string) (*accessTokenClaims, error) {
+	claims := new(accessTokenClaims)
+	_, err := a.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.keys.key(kid)
+	})
+	if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&jwt.ValidationErrorExpired != 0 {
+		return nil, errors.New("access token is expired")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "access token verification failed")
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("access token has no expiry")
+	}
+	if claims.Issuer != a.issuer {
+		return nil, errors.Errorf("unexpected access token issuer %q", claims.Issuer)
+	}
+	// the roles of the scope are of the only audience, hence, the audience must be one of the accepted audiences
+	if len(claims.Audience) != 1 || !contains(a.audiences, claims.Audience[0]) {
+		return nil, errors.Errorf("unexpected access token audience %q", []string(claims.Audience))
+	}
+	return claims, nil
+}
+
+// key returns the public key of the key ID.
+// The keys are refreshed if they are older than the refresh duration, or if the key ID is unknown.
+// If the refresh fails, the cached keys are kept.
+func (j *jwks) key(kid string) (interface{}, error) {
+	j.mu.RLock()
+	k, ok := j.keys[kid]
+	checkedAt := j.checkedAt
+	j.mu.RUnlock()
+
+	elapsed := time.Since(checkedAt)
+	if elapsed > j.refresh || (!ok && elapsed > jwksRetryInterval) {
+		err := j.update(checkedAt)
+		if err != nil {
+			err = glg.Warn(errors.Wrap(err, "JSON Web Key Set refresh failed, keep using the cached keys"))
+			if err != nil {
+				glg.Fatal(err)
+			}
+		}
+		j.mu.RLock()
+		k, ok = j.keys[kid]
+		j.mu.RUnlock()
+	}
+
+	if !ok {
+		return nil, errors.Errorf("unknown key ID %q", kid)
+	}
+	return k, nil
+}
+
+// update fetches the JSON Web Key Set and replaces the cached keys, unless another request refreshed them after checkedAt.
+// The keys of unsupported types are ignored.
+func (j *jwks) update(checkedAt time.Time) error {
+	j.fetch.Lock()
+	defer j.fetch.Unlock()
+
+	j.mu.Lock()
+	if !j.checkedAt.Equal(checkedAt) {
+		j.mu.Unlock()
+		return nil
+	}
+	j.checkedAt = time.Now()
+	j.mu.Unlock()
+
+	res, err := j.client.Get(j.url)
+	if err != nil {
+		return errors.Wrap(err, "JSON Web Key Set request failed")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("JSON Web Key Set request failed: unexpected status %d", res.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&set)
+	if err != nil {
+		return errors.Wrap(err, "JSON Web Key Set decode failed")
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+	return nil
+}
+
+// publicKey returns *rsa.PublicKey or *ecdsa.PublicKey of the JSON Web Key.
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256", "prime256v1":
+			curve = elliptic.P256()
+		case "P-384", "secp384r1":
+			curve = elliptic.P384()
+		case "P-521", "secp521r1":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.Errorf("unsupported key type %q", k.Kty)
+}
+
+// decodeBigInt decodes the base64url encoded big-endian integer of the JSON Web Key.
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(b) == 0 {
+		return nil, errors.Errorf("invalid key parameter %q", s)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// readTokenReview reads the v1beta1 TokenReview in the request body, and restores the body for the next handler.
+// It returns nil if the body is not a v1beta1 TokenReview, and false if the body cannot be read, the error is written to w.
+func readTokenReview(w http.ResponseWriter, r *http.Request) (*authn.TokenReview, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	tr := new(authn.TokenReview)
+	if json.Unmarshal(body, tr) != nil || tr.APIVersion != authnV1beta1 || tr.Kind != "TokenReview" {
+		return nil, true
+	}
+	return tr, true
+}
+
+// writeTokenReview writes the TokenReview response with the given status, in the same format as the Athenz authenticator.
+func writeTokenReview(w http.ResponseWriter, tr *authn.TokenReview, status authn.TokenReviewStatus) {
+	b, err := json.Marshal(struct {
+		APIVersion string                  `json:"apiVersion"`
+		Kind       string                  `json:"kind"`
+		Status     authn.TokenReviewStatus `json:"status"`
+	}{tr.APIVersion, tr.Kind, status})
+	if err != nil {
+		http.Error(w, "internal serialization error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(b)
+	if err != nil {
+		err = glg.Error(errors.Wrap(err, "local authentication response failed"))
+		if err != nil {
+			glg.Fatal(errors.Wrap(err, "error log output failed"))
+		}
+	}
+}
+
+// logTokenReview outputs the outcome of the token authenticated locally, the token itself is never logged.
+func logTokenReview(log webhook.Logger, kind string, r *http.Request, status authn.TokenReviewStatus) {
+	if !status.Authenticated {
+		log.Printf("authn denied %s from %s -> error=%s\n", kind, r.RemoteAddr, status.Error)
+		return
+	}
+	u := status.User
+	log.Printf("authn granted %s from %s -> user=%s, uid=%s, groups=%v\n", kind, r.RemoteAddr, u.Username, u.UID, u.Groups)
+}
+
+// isJWT returns true if the token is in the JWT compact form, the n-token starts with "v=" and never does.
+func isJWT(token string) bool {
+	return !strings.HasPrefix(token, "v=") && strings.Count(token, ".") == 2
+}
+
+// contains returns true if s is in a.
+func contains(a []string, s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,602 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/kpango/glg"
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
+	authn "k8s.io/api/authentication/v1beta1"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+// accessTokenTestKeys are the signing keys of the access tokens served by the test JSON Web Key Set server.
+type accessTokenTestKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newAccessTokenTestKeys(t *testing.T) accessTokenTestKeys {
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accessTokenTestKeys{rsa: rk, ec: ek}
+}
+
+// jwksHandler serves the public keys as the JSON Web Key Set, and counts the requests.
+func (k accessTokenTestKeys) jwksHandler(count *int32) http.Handler {
+	enc := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.Bytes())
+	}
+	b, _ := json.Marshal(map[string][]jsonWebKey{
+		"keys": {
+			{Kty: "RSA", Kid: "rsa-0", N: enc(k.rsa.N), E: enc(big.NewInt(int64(k.rsa.E)))},
+			{Kty: "EC", Kid: "ec-0", Crv: "P-256", X: enc(k.ec.X), Y: enc(k.ec.Y)},
+			{Kty: "oct", Kid: "hmac-0"},
+		},
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(count, 1)
+		w.Write(b)
+	})
+}
+
+// sign returns the access token of the claims signed by the key of kid.
+func (k accessTokenTestKeys) sign(t *testing.T, kid string, claims jwt.Claims) string {
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	var key interface{} = k.rsa
+	if strings.HasPrefix(kid, "ec-") {
+		tok = jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		key = k.ec
+	}
+	tok.Header["kid"] = kid
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testAccessTokenClaims(exp time.Time) *accessTokenClaims {
+	return &accessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "https://zts.athenz.io/zts/v1",
+			Subject:   "user.alice",
+			Audience:  jwt.ClaimStrings{"k8s"},
+			ExpiresAt: jwt.NewNumericDate(exp),
+		},
+		ClientID: "k8s.kubectl",
+		Scope:    []string{"admin", "viewer"},
+	}
+}
+
+func TestNewAccessTokenAuthenticator(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Athenz
+		wantURL string
+		wantErr error
+	}{
+		{
+			name: "Check newAccessTokenAuthenticator fail with invalid refresh duration",
+			cfg: config.Athenz{
+				Timeout: "1s",
+				AccessToken: config.AccessToken{
+					RefreshDuration: "dummy",
+				},
+			},
+			wantErr: fmt.Errorf(`invalid access token refresh duration dummy: time: invalid duration "dummy"`),
+		},
+		{
+			name: "Check newAccessTokenAuthenticator fail with invalid timeout",
+			cfg: config.Athenz{
+				Timeout: "dummy",
+				AccessToken: config.AccessToken{
+					RefreshDuration: "1h",
+				},
+			},
+			wantErr: fmt.Errorf(`invalid athenz timeout dummy: time: invalid duration "dummy"`),
+		},
+		{
+			name: "Check newAccessTokenAuthenticator fail with missing root CA",
+			cfg: config.Athenz{
+				Timeout:      "1s",
+				AthenzRootCA: "./testdata/notexists.pem",
+				AccessToken: config.AccessToken{
+					RefreshDuration: "1h",
+				},
+			},
+			wantErr: fmt.Errorf("access token x509 certpool error: failed to read pem file: open ./testdata/notexists.pem: no such file or directory"),
+		},
+		{
+			name: "Check newAccessTokenAuthenticator success with default JWKS URL",
+			cfg: config.Athenz{
+				URL:     "https://zts.athenz.io/zts/v1",
+				Timeout: "1s",
+				AccessToken: config.AccessToken{
+					RefreshDuration: "1h",
+				},
+			},
+			wantURL: "https://zts.athenz.io/zts/v1/oauth2/keys?rfc=true",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newAccessTokenAuthenticator(nil, tt.cfg, &scopeSigner{}, func(string) webhook.Logger {
+				return dummyLogger("")
+			})
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("newAccessTokenAuthenticator() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("newAccessTokenAuthenticator() unexpected error: %v", err)
+				return
+			}
+			a := got.(*accessTokenAuthenticator)
+			if a.keys.url != tt.wantURL {
+				t.Errorf("jwks url = %v, want %v", a.keys.url, tt.wantURL)
+			}
+			if a.roleGroup != "_domain_:role._role_" {
+				t.Errorf("roleGroup = %v, want %v", a.roleGroup, "_domain_:role._role_")
+			}
+		})
+	}
+}
+
+func Test_accessTokenAuthenticator_ServeHTTP(t *testing.T) {
+	keys := newAccessTokenTestKeys(t)
+	var count int32
+	srv := httptest.NewServer(keys.jwksHandler(&count))
+	defer srv.Close()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("n-token"))
+	})
+	signer := &scopeSigner{key: []byte("test")}
+	newAuthenticator := func(issuer string, audiences []string) *accessTokenAuthenticator {
+		h, err := newAccessTokenAuthenticator(next, config.Athenz{
+			Timeout: "1s",
+			AccessToken: config.AccessToken{
+				JWKSURL:         srv.URL,
+				RefreshDuration: "1h",
+				Issuer:          issuer,
+				Audiences:       audiences,
+			},
+			AuthN: webhook.AuthenticationConfig{
+				Mapper: NewUserMapper(&resolve{}),
+			},
+		}, signer, func(string) webhook.Logger {
+			return dummyLogger("")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h.(*accessTokenAuthenticator)
+	}
+	review := func(token string) string {
+		return `{"apiVersion":"authentication.k8s.io/v1beta1","kind":"TokenReview","spec":{"token":"` + token + `"}}`
+	}
+	exp := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		a      *accessTokenAuthenticator
+		body   string
+		want   string
+		status authn.TokenReviewStatus
+	}{
+		{
+			name: "Check RS256 access token is authenticated",
+			a:    newAuthenticator("https://zts.athenz.io/zts/v1", []string{"k8s"}),
+			body: review(keys.sign(t, "rsa-0", testAccessTokenClaims(exp))),
+			status: authn.TokenReviewStatus{
+				Authenticated: true,
+				User: authn.UserInfo{
+					Username: "user.alice",
+					UID:      "user.alice",
+					Groups:   []string{"k8s:role.admin", "k8s:role.viewer"},
+					Extra: map[string]authn.ExtraValue{
+						"athenz.io/client-id":       {"k8s.kubectl"},
+						"athenz.io/scope":           {"admin", "viewer"},
+						"athenz.io/audience":        {"k8s"},
+						"athenz.io/scope-signature": {signer.sign("user.alice", "k8s", []string{"admin", "viewer"}, exp)},
+					},
+				},
+			},
+		},
+		{
+			name: "Check ES256 access token is authenticated",
+			a:    newAuthenticator("https://zts.athenz.io/zts/v1", []string{"k8s"}),
+			body: review(keys.sign(t, "ec-0", &accessTokenClaims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Issuer:    "https://zts.athenz.io/zts/v1",
+					Subject:   "k8s.ci",
+					Audience:  jwt.ClaimStrings{"k8s"},
+					ExpiresAt: jwt.NewNumericDate(exp),
+				},
+			})),
+			status: authn.TokenReviewStatus{
+				Authenticated: true,
+				User: authn.UserInfo{
+					Username: "k8s.ci",
+					UID:      "k8s.ci",
+				},
+			},
+		},
+		{
+			name: "Check expired access token is denied",
+			a:    newAuthenticator("https://zts.athenz.io/zts/v1", []string{"k8s"}),
+			body: review(keys.sign(t, "rsa-0", testAccessTokenClaims(time.Now().Add(-time.Minute)))),
+			status: authn.TokenReviewStatus{
+				Error: "access token is expired",
+			},
+		},
+		{
+			name: "Check access token without expiry is denied",
+			a:    newAuthenticator("https://zts.athenz.io/zts/v1", []string{"k8s"}),
+			body: review(keys.sign(t, "rsa-0", &accessTokenClaims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject: "user.alice",
+				},
+			})),
+			status: authn.TokenReviewStatus{
+				Error: "access token has no expiry",
+			},
+		},
+		{
+			name: "Check access token of unexpected issuer is denied",
+			a:    newAuthenticator("https://zts.example.com/zts/v1", []string{"k8s"}),
+			body: review(keys.sign(t, "rsa-0", testAccessTokenClaims(exp))),
+			status: authn.TokenReviewStatus{
+				Error: `unexpected access token issuer "https://zts.athenz.io/zts/v1"`,
+			},
+		},
+		{
+			name: "Check access token without issuer and audience is denied",
+			a:    newAuthenticator("https://zts.athenz.io/zts/v1", []string{"k8s"}),
+			body: review(keys.sign(t, "rsa-0", &accessTokenClaims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "user.alice",
+					ExpiresAt: jwt.NewNumericDate(exp),
+				},
+			})),
+			status: authn.TokenReviewStatus{
+				Error: `unexpected access token issuer ""`,
+			},
+		},
+		{
+			name: "Check access token of multiple audiences is denied",
+			a:    newAuthenticator("https://zts.athenz.io/zts/v1", []string{"k8s"}),
+			body: review(keys.sign(t, "rsa-0", &accessTokenClaims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Issuer:    "https://zts.athenz.io/zts/v1",
+					Subject:   "user.alice",
+					Audience:  jwt.ClaimStrings{"other", "k8s"},
+					ExpiresAt: jwt.NewNumericDate(exp),
+				},
+				Scope: []string{"admin"},
+			})),
+			status: authn.TokenReviewStatus{
+				Error: `unexpected access token audience ["other" "k8s"]`,
+			},
+		},
+		{
+			name: "Check access token of unexpected audience is denied",
+			a:    newAuthenticator("https://zts.athenz.io/zts/v1", []string{"k8s.prod"}),
+			body: review(keys.sign(t, "rsa-0", testAccessTokenClaims(exp))),
+			status: authn.TokenReviewStatus{
+				Error: `unexpected access token audience ["k8s"]`,
+			},
+		},
+		{
+			name: "Check access token of unknown key ID is denied",
+			a:    newAuthenticator("https://zts.athenz.io/zts/v1", []string{"k8s"}),
+			body: review(keys.sign(t, "rsa-1", testAccessTokenClaims(exp))),
+			status: authn.TokenReviewStatus{
+				Error: `access token verification failed: unknown key ID "rsa-1"`,
+			},
+		},
+		{
+			name: "Check access token of invalid subject is denied",
+			a:    newAuthenticator("https://zts.athenz.io/zts/v1", []string{"k8s"}),
+			body: review(keys.sign(t, "rsa-0", &accessTokenClaims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Issuer:    "https://zts.athenz.io/zts/v1",
+					Subject:   "alice",
+					Audience:  jwt.ClaimStrings{"k8s"},
+					ExpiresAt: jwt.NewNumericDate(exp),
+				},
+			})),
+			status: authn.TokenReviewStatus{
+				Error: `invalid access token subject "alice"`,
+			},
+		},
+		{
+			name: "Check n-token is passed to the Athenz authenticator",
+			a:    newAuthenticator("https://zts.athenz.io/zts/v1", []string{"k8s"}),
+			body: review("v=S1;d=k8s;n=ci;t=1;e=2;s=a.b.c"),
+			want: "n-token",
+		},
+		{
+			name: "Check invalid request is passed to the Athenz authenticator",
+			a:    newAuthenticator("https://zts.athenz.io/zts/v1", []string{"k8s"}),
+			body: "{",
+			want: "n-token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.a.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/authn", bytes.NewBufferString(tt.body)))
+			if tt.want != "" {
+				if got := w.Body.String(); got != tt.want {
+					t.Errorf("ServeHTTP() body = %v, want %v", got, tt.want)
+				}
+				return
+			}
+			var got struct {
+				APIVersion string                  `json:"apiVersion"`
+				Kind       string                  `json:"kind"`
+				Status     authn.TokenReviewStatus `json:"status"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.APIVersion != "authentication.k8s.io/v1beta1" || got.Kind != "TokenReview" {
+				t.Errorf("ServeHTTP() type = %v %v, want TokenReview", got.APIVersion, got.Kind)
+			}
+			if !reflect.DeepEqual(got.Status, tt.status) {
+				t.Errorf("ServeHTTP() status = %+v, want %+v", got.Status, tt.status)
+			}
+		})
+	}
+}
+
+func Test_scopeSigner_verify(t *testing.T) {
+	signer := &scopeSigner{key: []byte("test")}
+	exp := time.Now().Add(time.Hour)
+	sig := signer.sign("user.alice", "k8s", []string{"viewer"}, exp)
+	extra := func(domain []string, roles []string, sig []string) map[string]authz.ExtraValue {
+		return map[string]authz.ExtraValue{
+			extraAudience:       domain,
+			extraScope:          roles,
+			extraScopeSignature: sig,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		signer     *scopeSigner
+		user       string
+		extra      map[string]authz.ExtraValue
+		wantDomain string
+		wantRoles  []string
+		wantOK     bool
+	}{
+		{
+			name:       "Check signed roles are verified",
+			signer:     signer,
+			user:       "user.alice",
+			extra:      extra([]string{"k8s"}, []string{"viewer"}, []string{sig}),
+			wantDomain: "k8s",
+			wantRoles:  []string{"viewer"},
+			wantOK:     true,
+		},
+		{
+			name:   "Check added role is rejected",
+			signer: signer,
+			user:   "user.alice",
+			extra:  extra([]string{"k8s"}, []string{"viewer", "admin"}, []string{sig}),
+		},
+		{
+			name:   "Check other domain is rejected",
+			signer: signer,
+			user:   "user.alice",
+			extra:  extra([]string{"k8s.prod"}, []string{"viewer"}, []string{sig}),
+		},
+		{
+			name:   "Check other user is rejected",
+			signer: signer,
+			user:   "user.bob",
+			extra:  extra([]string{"k8s"}, []string{"viewer"}, []string{sig}),
+		},
+		{
+			name:   "Check signature of other key is rejected",
+			signer: &scopeSigner{key: []byte("other")},
+			user:   "user.alice",
+			extra:  extra([]string{"k8s"}, []string{"viewer"}, []string{sig}),
+		},
+		{
+			name:   "Check extended expiry is rejected",
+			signer: signer,
+			user:   "user.alice",
+			extra:  extra([]string{"k8s"}, []string{"viewer"}, []string{"9999999999" + sig[strings.Index(sig, "."):]}),
+		},
+		{
+			name:   "Check expired signature is rejected",
+			signer: signer,
+			user:   "user.alice",
+			extra:  extra([]string{"k8s"}, []string{"viewer"}, []string{signer.sign("user.alice", "k8s", []string{"viewer"}, time.Now().Add(-time.Minute))}),
+		},
+		{
+			name:   "Check missing signature is rejected",
+			signer: signer,
+			user:   "user.alice",
+			extra:  extra([]string{"k8s"}, []string{"viewer"}, nil),
+		},
+		{
+			name:   "Check malformed signature is rejected",
+			signer: signer,
+			user:   "user.alice",
+			extra:  extra([]string{"k8s"}, []string{"viewer"}, []string{"invalid"}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain, roles, ok := tt.signer.verify(tt.user, tt.extra)
+			if domain != tt.wantDomain || !reflect.DeepEqual(roles, tt.wantRoles) || ok != tt.wantOK {
+				t.Errorf("verify() = %v, %v, %v, want %v, %v, %v", domain, roles, ok, tt.wantDomain, tt.wantRoles, tt.wantOK)
+			}
+		})
+	}
+}
+
+func Test_jwks_key(t *testing.T) {
+	glg.Get().SetLevelMode(glg.WARN, glg.NONE)
+	keys := newAccessTokenTestKeys(t)
+	var count int32
+	srv := httptest.NewServer(keys.jwksHandler(&count))
+	defer srv.Close()
+
+	j := &jwks{
+		url:     srv.URL,
+		client:  srv.Client(),
+		refresh: time.Hour,
+	}
+
+	// fetched on first use
+	if _, err := j.key("rsa-0"); err != nil {
+		t.Errorf("key() unexpected error: %v", err)
+	}
+	if _, err := j.key("ec-0"); err != nil {
+		t.Errorf("key() unexpected error: %v", err)
+	}
+	if got := atomic.LoadInt32(&count); got != 1 {
+		t.Errorf("fetch count = %d, want 1", got)
+	}
+
+	// unsupported key type is ignored, unknown key ID is not refetched within jwksRetryInterval
+	if _, err := j.key("hmac-0"); err == nil || err.Error() != `unknown key ID "hmac-0"` {
+		t.Errorf("key() error = %v, want unknown key ID", err)
+	}
+	if got := atomic.LoadInt32(&count); got != 1 {
+		t.Errorf("fetch count = %d, want 1", got)
+	}
+
+	// unknown key ID is refetched after jwksRetryInterval
+	j.checkedAt = j.checkedAt.Add(-jwksRetryInterval * 2)
+	if _, err := j.key("rsa-1"); err == nil {
+		t.Error("key() returns no error for unknown key ID")
+	}
+	if got := atomic.LoadInt32(&count); got != 2 {
+		t.Errorf("fetch count = %d, want 2", got)
+	}
+
+	// cached keys are kept if the refresh fails
+	j.checkedAt = j.checkedAt.Add(-time.Hour * 2)
+	srv.Close()
+	if _, err := j.key("rsa-0"); err != nil {
+		t.Errorf("key() unexpected error after refresh failure: %v", err)
+	}
+}
+
+func Test_jsonWebKey_publicKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		k       jsonWebKey
+		wantErr error
+	}{
+		{
+			name:    "Check unsupported key type",
+			k:       jsonWebKey{Kty: "oct"},
+			wantErr: fmt.Errorf(`unsupported key type "oct"`),
+		},
+		{
+			name:    "Check unsupported curve",
+			k:       jsonWebKey{Kty: "EC", Crv: "P-224"},
+			wantErr: fmt.Errorf(`unsupported curve "P-224"`),
+		},
+		{
+			name:    "Check invalid RSA modulus",
+			k:       jsonWebKey{Kty: "RSA", N: "!", E: "AQAB"},
+			wantErr: fmt.Errorf(`invalid key parameter "!"`),
+		},
+		{
+			name: "Check Athenz curve name",
+			k:    jsonWebKey{Kty: "EC", Crv: "prime256v1", X: "AQ", Y: "Ag"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.k.publicKey()
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("publicKey() unexpected error: %v", err)
+				return
+			}
+			if tt.wantErr != nil && (err == nil || err.Error() != tt.wantErr.Error()) {
+				t.Errorf("publicKey() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_isJWT(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{
+			name:  "Check JWT",
+			token: "eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJ1c2VyLmFsaWNlIn0.c2ln",
+			want:  true,
+		},
+		{
+			name:  "Check n-token with dots in the signature",
+			token: "v=S1;d=k8s;n=ci;s=a.b.c",
+			want:  false,
+		},
+		{
+			name:  "Check opaque token",
+			token: "dummy-token",
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isJWT(tt.token); got != tt.want {
+				t.Errorf("isJWT() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
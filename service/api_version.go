@@ -0,0 +1,188 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/garm/metrics"
+	authnv1 "k8s.io/api/authentication/v1"
+	authn "k8s.io/api/authentication/v1beta1"
+	authzv1 "k8s.io/api/authorization/v1"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+const (
+	// authzV1 represents the apiVersion of the v1 SubjectAccessReview.
+	authzV1 = "authorization.k8s.io/v1"
+	// authzV1beta1 represents the apiVersion of the v1beta1 SubjectAccessReview handled by the webhook library.
+	authzV1beta1 = "authorization.k8s.io/v1beta1"
+	// authnV1 represents the apiVersion of the v1 TokenReview.
+	authnV1 = "authentication.k8s.io/v1"
+	// authnV1beta1 represents the apiVersion of the v1beta1 TokenReview handled by the webhook library.
+	authnV1beta1 = "authentication.k8s.io/v1beta1"
+)
+
+// convertRequest replaces the body of the v1 request to the endpoint with the v1beta1 one, as the webhook library only handles v1beta1.
+// It returns the apiVersion of the converted request, or "" if the body is passed to the webhook library as it is, e.g. v1beta1 or invalid JSON.
+func convertRequest(r *http.Request, endpoint string) (string, error) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "request body read failed")
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	var tm struct {
+		APIVersion string `json:"apiVersion"`
+	}
+	if json.Unmarshal(b, &tm) != nil {
+		return "", nil
+	}
+
+	var conv []byte
+	switch {
+	case endpoint == metrics.EndpointAuthz && tm.APIVersion == authzV1:
+		conv, err = subjectAccessReviewToV1beta1(b)
+	case endpoint == metrics.EndpointAuthn && tm.APIVersion == authnV1:
+		conv, err = tokenReviewToV1beta1(b)
+	default:
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(conv))
+	r.ContentLength = int64(len(conv))
+	return tm.APIVersion, nil
+}
+
+// DecodeSubjectAccessReview decodes the v1 or v1beta1 SubjectAccessReview JSON as v1beta1.
+func DecodeSubjectAccessReview(b []byte) (*authz.SubjectAccessReview, error) {
+	var tm struct {
+		APIVersion string `json:"apiVersion"`
+	}
+	err := json.Unmarshal(b, &tm)
+	if err != nil {
+		return nil, err
+	}
+	if tm.APIVersion == authzV1 {
+		b, err = subjectAccessReviewToV1beta1(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var sar authz.SubjectAccessReview
+	err = json.Unmarshal(b, &sar)
+	if err != nil {
+		return nil, err
+	}
+	return &sar, nil
+}
+
+// subjectAccessReviewToV1beta1 converts the v1 SubjectAccessReview JSON to v1beta1.
+func subjectAccessReviewToV1beta1(b []byte) ([]byte, error) {
+	var v1 authzv1.SubjectAccessReview
+	err := json.Unmarshal(b, &v1)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid v1 SubjectAccessReview")
+	}
+
+	sar := authz.SubjectAccessReview{
+		TypeMeta:   v1.TypeMeta,
+		ObjectMeta: v1.ObjectMeta,
+		Spec: authz.SubjectAccessReviewSpec{
+			User:   v1.Spec.User,
+			Groups: v1.Spec.Groups,
+			UID:    v1.Spec.UID,
+		},
+	}
+	sar.APIVersion = authzV1beta1
+	if ra := v1.Spec.ResourceAttributes; ra != nil {
+		sar.Spec.ResourceAttributes = &authz.ResourceAttributes{
+			Namespace:   ra.Namespace,
+			Verb:        ra.Verb,
+			Group:       ra.Group,
+			Version:     ra.Version,
+			Resource:    ra.Resource,
+			Subresource: ra.Subresource,
+			Name:        ra.Name,
+		}
+	}
+	if nra := v1.Spec.NonResourceAttributes; nra != nil {
+		sar.Spec.NonResourceAttributes = &authz.NonResourceAttributes{
+			Path: nra.Path,
+			Verb: nra.Verb,
+		}
+	}
+	if v1.Spec.Extra != nil {
+		sar.Spec.Extra = make(map[string]authz.ExtraValue, len(v1.Spec.Extra))
+		for k, v := range v1.Spec.Extra {
+			sar.Spec.Extra[k] = authz.ExtraValue(v)
+		}
+	}
+	return json.Marshal(sar)
+}
+
+// tokenReviewToV1beta1 converts the v1 TokenReview JSON to v1beta1.
+func tokenReviewToV1beta1(b []byte) ([]byte, error) {
+	var v1 authnv1.TokenReview
+	err := json.Unmarshal(b, &v1)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid v1 TokenReview")
+	}
+
+	tr := authn.TokenReview{
+		TypeMeta:   v1.TypeMeta,
+		ObjectMeta: v1.ObjectMeta,
+		Spec: authn.TokenReviewSpec{
+			Token:     v1.Spec.Token,
+			Audiences: v1.Spec.Audiences,
+		},
+	}
+	tr.APIVersion = authnV1beta1
+	return json.Marshal(tr)
+}
+
+// convertResponse replaces the apiVersion of the response buffered in rec with the apiVersion of the request before conversion.
+// The status of v1 and v1beta1 share the same JSON format, hence, only the apiVersion is replaced.
+// It does nothing if the request is not converted, or the response is not a review, e.g. the internal errors.
+func convertResponse(rec *responseRecorder, apiVersion string) {
+	if apiVersion == "" || rec.status != http.StatusOK {
+		return
+	}
+	var resp struct {
+		APIVersion string          `json:"apiVersion"`
+		Kind       string          `json:"kind"`
+		Status     json.RawMessage `json:"status"`
+	}
+	if json.Unmarshal(rec.body.Bytes(), &resp) != nil {
+		return
+	}
+	resp.APIVersion = apiVersion
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	rec.body.Reset()
+	rec.body.Write(b)
+}
@@ -0,0 +1,258 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yahoojapan/garm/metrics"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+func Test_convertRequest(t *testing.T) {
+	type args struct {
+		body     string
+		endpoint string
+	}
+	tests := []struct {
+		name        string
+		args        args
+		want        string
+		wantBody    string
+		wantErrText string
+	}{
+		{
+			name: "Check v1 SubjectAccessReview is converted",
+			args: args{
+				body:     `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"resourceAttributes":{"namespace":"ns","verb":"get","version":"v1","resource":"pods","subresource":"log","name":"nginx"},"user":"alice","groups":["admins"],"extra":{"scopes":["a"]},"uid":"uid-1"}}`,
+				endpoint: metrics.EndpointAuthz,
+			},
+			want:     "authorization.k8s.io/v1",
+			wantBody: `{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1beta1","metadata":{"creationTimestamp":null},"spec":{"resourceAttributes":{"namespace":"ns","verb":"get","version":"v1","resource":"pods","subresource":"log","name":"nginx"},"user":"alice","group":["admins"],"extra":{"scopes":["a"]},"uid":"uid-1"},"status":{"allowed":false}}`,
+		},
+		{
+			name: "Check v1 non-resource SubjectAccessReview is converted",
+			args: args{
+				body:     `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"nonResourceAttributes":{"path":"/healthz","verb":"get"},"user":"alice"}}`,
+				endpoint: metrics.EndpointAuthz,
+			},
+			want:     "authorization.k8s.io/v1",
+			wantBody: `{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1beta1","metadata":{"creationTimestamp":null},"spec":{"nonResourceAttributes":{"path":"/healthz","verb":"get"},"user":"alice"},"status":{"allowed":false}}`,
+		},
+		{
+			name: "Check v1 TokenReview is converted",
+			args: args{
+				body:     `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"dummy-token","audiences":["garm"]}}`,
+				endpoint: metrics.EndpointAuthn,
+			},
+			want:     "authentication.k8s.io/v1",
+			wantBody: `{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1beta1","metadata":{"creationTimestamp":null},"spec":{"token":"dummy-token","audiences":["garm"]},"status":{"user":{}}}`,
+		},
+		{
+			name: "Check v1beta1 SubjectAccessReview is passed as it is",
+			args: args{
+				body:     `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview"}`,
+				endpoint: metrics.EndpointAuthz,
+			},
+			want:     "",
+			wantBody: `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview"}`,
+		},
+		{
+			name: "Check v1 TokenReview to authorization endpoint is passed as it is",
+			args: args{
+				body:     `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview"}`,
+				endpoint: metrics.EndpointAuthz,
+			},
+			want:     "",
+			wantBody: `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview"}`,
+		},
+		{
+			name: "Check invalid JSON is passed as it is",
+			args: args{
+				body:     `{`,
+				endpoint: metrics.EndpointAuthz,
+			},
+			want:     "",
+			wantBody: `{`,
+		},
+		{
+			name: "Check invalid v1 SubjectAccessReview",
+			args: args{
+				body:     `{"apiVersion":"authorization.k8s.io/v1","spec":{"user":1}}`,
+				endpoint: metrics.EndpointAuthz,
+			},
+			wantErrText: "invalid v1 SubjectAccessReview: ",
+		},
+		{
+			name: "Check invalid v1 TokenReview",
+			args: args{
+				body:     `{"apiVersion":"authentication.k8s.io/v1","spec":{"token":1}}`,
+				endpoint: metrics.EndpointAuthn,
+			},
+			wantErrText: "invalid v1 TokenReview: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "http://dummy.url", strings.NewReader(tt.args.body))
+			got, err := convertRequest(r, tt.args.endpoint)
+			if tt.wantErrText != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErrText) {
+					t.Errorf("convertRequest() error = %v, want prefix %v", err, tt.wantErrText)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("convertRequest() unexpected error: %v", err)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("convertRequest() = %v, want %v", got, tt.want)
+			}
+			b, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.wantBody {
+				t.Errorf("convertRequest() body = %s, want %s", b, tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_convertResponse(t *testing.T) {
+	type args struct {
+		status     int
+		body       string
+		apiVersion string
+	}
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			name: "Check apiVersion is replaced",
+			args: args{
+				status:     http.StatusOK,
+				body:       `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","status":{"allowed":true}}`,
+				apiVersion: "authorization.k8s.io/v1",
+			},
+			want: `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","status":{"allowed":true}}`,
+		},
+		{
+			name: "Check request not converted",
+			args: args{
+				status:     http.StatusOK,
+				body:       `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","status":{"allowed":true}}`,
+				apiVersion: "",
+			},
+			want: `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","status":{"allowed":true}}`,
+		},
+		{
+			name: "Check error response is kept",
+			args: args{
+				status:     http.StatusBadRequest,
+				body:       "bad request\n",
+				apiVersion: "authorization.k8s.io/v1",
+			},
+			want: "bad request\n",
+		},
+		{
+			name: "Check invalid JSON response is kept",
+			args: args{
+				status:     http.StatusOK,
+				body:       "{",
+				apiVersion: "authorization.k8s.io/v1",
+			},
+			want: "{",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &responseRecorder{
+				ResponseWriter: httptest.NewRecorder(),
+				status:         tt.args.status,
+				buffered:       true,
+			}
+			rec.body.WriteString(tt.args.body)
+			convertResponse(rec, tt.args.apiVersion)
+			if got := rec.body.String(); got != tt.want {
+				t.Errorf("convertResponse() body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSubjectAccessReview(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    authz.SubjectAccessReviewSpec
+		wantErr bool
+	}{
+		{
+			name: "Check v1 SubjectAccessReview",
+			body: `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"resourceAttributes":{"verb":"get","resource":"pods"},"user":"alice","groups":["dev"]}}`,
+			want: authz.SubjectAccessReviewSpec{
+				ResourceAttributes: &authz.ResourceAttributes{
+					Verb:     "get",
+					Resource: "pods",
+				},
+				User:   "alice",
+				Groups: []string{"dev"},
+			},
+		},
+		{
+			name: "Check v1beta1 SubjectAccessReview",
+			body: `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","spec":{"resourceAttributes":{"verb":"get","resource":"pods"},"user":"alice","group":["dev"]}}`,
+			want: authz.SubjectAccessReviewSpec{
+				ResourceAttributes: &authz.ResourceAttributes{
+					Verb:     "get",
+					Resource: "pods",
+				},
+				User:   "alice",
+				Groups: []string{"dev"},
+			},
+		},
+		{
+			name:    "Check invalid JSON",
+			body:    `{`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeSubjectAccessReview([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DecodeSubjectAccessReview() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got.Spec, tt.want) {
+				t.Errorf("DecodeSubjectAccessReview() = %+v, want %+v", got.Spec, tt.want)
+			}
+		})
+	}
+}
@@ -17,13 +17,20 @@ limitations under the License.
 package service
 
 import (
+	"bytes"
 	"crypto/tls"
+	"encoding/json"
 	"net/http"
 	"time"
 
+	"github.com/kpango/glg"
 	"github.com/pkg/errors"
 	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/audit"
 	"github.com/yahoojapan/garm/config"
+	"github.com/yahoojapan/garm/metrics"
+	authn "k8s.io/api/authentication/v1beta1"
+	authz "k8s.io/api/authorization/v1beta1"
 )
 
 // Athenz interface is used to send HTTP requests to Athenz server.
@@ -42,11 +49,20 @@ type athenz struct {
 	authn http.Handler
 	// authz is Athenz authorizer.
 	authz http.Handler
+	// auditor writes the audit records, nil if audit log is disabled.
+	auditor audit.Auditor
+	// denial replaces the reason of the rejected authorization requests, nil if the reason is not configured.
+	denial *denial
 }
 
 // NewAthenz creates a new Athenz object that can handle HTTP requests based on the given configuration.
 // The HTTP handlers will use the given logger for logging.
-func NewAthenz(cfg config.Athenz, log Logger) (Athenz, error) {
+// If cfg.Cache.Enabled is true, the authorization decisions are cached in front of the Athenz authorizer.
+// If cfg.AccessToken.Enabled is true, the Athenz access tokens are verified locally in front of the Athenz authenticator.
+// If cfg.Certificate.Enabled is true, the assertions signed with the Athenz X.509 service certificates are verified locally as well.
+// If auditor is not nil, an audit record is written for every request.
+// If policies is not nil, the access checks are evaluated with its policies in front of the decision cache and the Athenz authorizer.
+func NewAthenz(cfg config.Athenz, log Logger, auditor audit.Auditor, policies PolicyStore) (Athenz, error) {
 	athenzTimeout, err := time.ParseDuration(cfg.Timeout)
 	if err != nil {
 		return nil, errors.Wrap(err, "athenz timeout parse failed")
@@ -62,6 +78,7 @@ func NewAthenz(cfg config.Athenz, log Logger) (Athenz, error) {
 	}
 	cfg.AuthN.Config = c
 	cfg.AuthZ.Config = c
+	cfg.AuthZ.HelpMessage = cfg.Denial.HelpMessage
 	cfg.AuthZ.AthenzX509 = func() (*tls.Config, error) {
 		pool, err := NewX509CertPool(config.GetActualValue(cfg.AthenzRootCA))
 		if err != nil {
@@ -70,21 +87,231 @@ func NewAthenz(cfg config.Athenz, log Logger) (Athenz, error) {
 		return &tls.Config{RootCAs: pool}, err
 	}
 
+	var cache *decisionCache
+	if cfg.Cache.Enabled {
+		cache, err = newDecisionCache(cfg.Cache)
+		if err != nil {
+			return nil, errors.Wrap(err, "authorization cache instantiate failed")
+		}
+	}
+
+	authorizer := requestHandler(c.LogProvider, func(lp webhook.LogProvider) http.Handler {
+		authzCfg := cfg.AuthZ
+		authzCfg.LogProvider = lp
+		return webhook.NewAuthorizer(authzCfg)
+	})
+	if cache != nil {
+		authorizer = newCachedAuthorizer(authorizer, cache, cfg.AuthZ.Mapper, c.LogProvider)
+	}
+	// the roles of the access tokens are signed for the local policy evaluation
+	signer, err := newScopeSigner()
+	if err != nil {
+		return nil, err
+	}
+	if policies != nil {
+		// the local decisions depend on the roles, which are not in the cache key
+		authorizer = newPolicyAuthorizer(authorizer, policies, cfg.AuthZ.Mapper, signer, c.LogProvider)
+	}
+
+	authenticator := requestHandler(c.LogProvider, func(lp webhook.LogProvider) http.Handler {
+		authnCfg := cfg.AuthN
+		authnCfg.LogProvider = lp
+		return webhook.NewAuthenticator(authnCfg)
+	})
+	if cfg.AccessToken.Enabled {
+		authenticator, err = newAccessTokenAuthenticator(authenticator, cfg, signer, c.LogProvider)
+		if err != nil {
+			return nil, errors.Wrap(err, "access token authenticator instantiate failed")
+		}
+	}
+	if cfg.Certificate.Enabled {
+		authenticator, err = newCertificateAuthenticator(authenticator, cfg, c.LogProvider)
+		if err != nil {
+			return nil, errors.Wrap(err, "certificate authenticator instantiate failed")
+		}
+	}
+
 	return &athenz{
 		authConfig: cfg,
-		authn:      webhook.NewAuthenticator(cfg.AuthN),
-		authz:      webhook.NewAuthorizer(cfg.AuthZ),
+		authn:      authenticator,
+		authz:      authorizer,
+		auditor:    auditor,
+		denial:     newDenial(cfg.Denial),
 	}, nil
 }
 
-// AthenzAuthenticator passes the request to a.authn HTTP handler to handle.
+// requestHandler returns a http.Handler creating the webhook library handler by newHandler for each request.
+// The LogProvider given to newHandler ignores the request ID generated by the webhook library, and uses the request ID in the trace instead,
+// hence, the webhook library logs and the audit record of the same request share the same request ID.
+// Creating the webhook library handler only copies its configuration.
+func requestHandler(lp webhook.LogProvider, newHandler func(webhook.LogProvider) http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestIDFrom(r.Context())
+		newHandler(func(string) webhook.Logger {
+			return lp(id)
+		}).ServeHTTP(w, r)
+	})
+}
+
+// responseRecorder is a http.ResponseWriter recording the status code and body written to the underlying http.ResponseWriter.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	// buffered holds the response until flush is called, instead of passing it to the underlying http.ResponseWriter.
+	buffered bool
+}
+
+// WriteHeader records the status code and passes it to the underlying http.ResponseWriter.
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	if !r.buffered {
+		r.ResponseWriter.WriteHeader(code)
+	}
+}
+
+// Write records b and passes it to the underlying http.ResponseWriter.
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	if r.buffered {
+		return len(b), nil
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// flush passes the buffered response to the underlying http.ResponseWriter.
+func (r *responseRecorder) flush() error {
+	if !r.buffered || r.status == 0 {
+		return nil
+	}
+	r.ResponseWriter.WriteHeader(r.status)
+	_, err := r.ResponseWriter.Write(r.body.Bytes())
+	return err
+}
+
+// AthenzAuthenticator passes the request to a.authn HTTP handler to handle, and records the request metrics and audit record.
+// The v1 TokenReview is converted to v1beta1 for a.authn, and the response echoes the apiVersion of the request.
 func (a *athenz) AthenzAuthenticator(w http.ResponseWriter, r *http.Request) error {
-	a.authn.ServeHTTP(w, r)
+	start := time.Now()
+	ctx, t := withTrace(r.Context())
+	t.request = newProfileRequest(r, metrics.EndpointAuthn)
+	version, err := convertRequest(r, metrics.EndpointAuthn)
+	rec := &responseRecorder{ResponseWriter: w, buffered: version != ""}
+	if err != nil {
+		http.Error(rec, err.Error(), http.StatusBadRequest)
+	} else {
+		a.authn.ServeHTTP(rec, r.WithContext(ctx))
+	}
+	convertResponse(rec, version)
+	err = rec.flush()
+	if err != nil {
+		err = glg.Error(errors.Wrap(err, "authentication response write failed"))
+		if err != nil {
+			glg.Fatal(err)
+		}
+	}
+
+	outcome := metrics.OutcomeTimeout
+	// timeout is recorded by the router
+	if ctx.Err() == nil {
+		outcome = authnOutcome(rec)
+		metrics.ObserveRequest(metrics.EndpointAuthn, outcome)
+	}
+	a.audit(authnRecord(start, t, rec, outcome))
 	return nil
 }
 
-// AthenzAuthorizer passes the request to a.authz HTTP handler to handle.
+// AthenzAuthorizer passes the request to a.authz HTTP handler to handle, and records the request metrics and audit record.
+// The v1 SubjectAccessReview is converted to v1beta1 for a.authz, and the response echoes the apiVersion of the request.
 func (a *athenz) AthenzAuthorizer(w http.ResponseWriter, r *http.Request) error {
-	a.authz.ServeHTTP(w, r)
+	start := time.Now()
+	ctx, t := withTrace(r.Context())
+	t.request = newProfileRequest(r, metrics.EndpointAuthz)
+	version, err := convertRequest(r, metrics.EndpointAuthz)
+	rec := &responseRecorder{ResponseWriter: w, buffered: a.denial != nil || version != ""}
+	if err != nil {
+		http.Error(rec, err.Error(), http.StatusBadRequest)
+	} else {
+		a.authz.ServeHTTP(rec, r.WithContext(ctx))
+	}
+	if a.denial != nil {
+		a.denial.rewrite(rec, t)
+	}
+	convertResponse(rec, version)
+	err = rec.flush()
+	if err != nil {
+		err = glg.Error(errors.Wrap(err, "authorization response write failed"))
+		if err != nil {
+			glg.Fatal(err)
+		}
+	}
+
+	if len(t.checks) != 0 && !t.cached && !t.local {
+		metrics.ObserveAthenzDuration(metrics.EndpointAuthz, time.Since(t.mappedAt))
+	}
+	if t.action == config.ActionAdminDomain {
+		metrics.ObserveAdminAccess()
+	}
+	outcome := metrics.OutcomeTimeout
+	// timeout is recorded by the router
+	if ctx.Err() == nil {
+		outcome = authzOutcome(rec, t)
+		metrics.ObserveRequest(metrics.EndpointAuthz, outcome)
+	}
+	a.audit(authzRecord(start, t, rec, outcome))
 	return nil
 }
+
+// audit writes the audit record if audit log is enabled.
+func (a *athenz) audit(r audit.Record) {
+	if a.auditor == nil {
+		return
+	}
+	err := a.auditor.Audit(r)
+	if err != nil {
+		err = glg.Error(errors.Wrap(err, "audit log write failed"))
+		if err != nil {
+			glg.Fatal(err)
+		}
+	}
+}
+
+// authnOutcome returns the outcome of the TokenReview response recorded in rec.
+func authnOutcome(rec *responseRecorder) metrics.Outcome {
+	var tr struct {
+		Status authn.TokenReviewStatus `json:"status"`
+	}
+	if rec.status != http.StatusOK || json.Unmarshal(rec.body.Bytes(), &tr) != nil {
+		return metrics.OutcomeError
+	}
+	if tr.Status.Authenticated {
+		return metrics.OutcomeAllowed
+	}
+	return metrics.OutcomeDenied
+}
+
+// authzOutcome returns the outcome of the SubjectAccessReview response recorded in rec.
+// A rejection is counted as denied only if Athenz answered that the principal does not have access, other rejections are errors.
+func authzOutcome(rec *responseRecorder, t *trace) metrics.Outcome {
+	var sar struct {
+		Status authz.SubjectAccessReviewStatus `json:"status"`
+	}
+	if rec.status != http.StatusOK || json.Unmarshal(rec.body.Bytes(), &sar) != nil {
+		return metrics.OutcomeError
+	}
+	switch {
+	case sar.Status.Allowed:
+		return metrics.OutcomeAllowed
+	case t.action == config.ActionDeny:
+		return metrics.OutcomeBlacklisted
+	case isAthenzDenial(sar.Status, t.identity, t.checks):
+		return metrics.OutcomeDenied
+	default:
+		return metrics.OutcomeError
+	}
+}
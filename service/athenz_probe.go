@@ -0,0 +1,161 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/garm/config"
+)
+
+// AthenzProbe represents an interface to check the Athenz server is reachable periodically.
+type AthenzProbe interface {
+	StartAthenzProbe(context.Context) AthenzProbe
+	Ready() error
+}
+
+type athenzProbe struct {
+	// url is the Athenz API URL requested by the probe.
+	url string
+	// interval is the duration between each probe.
+	interval time.Duration
+	// client sends the probe requests.
+	client *http.Client
+	// result is the probeResult of the last probe.
+	result *atomic.Value
+}
+
+// probeResult wraps the error of a probe, since atomic.Value cannot store nil.
+type probeResult struct {
+	err error
+}
+
+var (
+	// ErrAthenzNotProbed represents the error that the Athenz server is not probed yet
+	ErrAthenzNotProbed = errors.New("athenz not probed yet")
+)
+
+// NewAthenzProbe returns an AthenzProbe requesting "athenz.url" + "server.readiness.athenz_probe_path" every "server.readiness.athenz_probe_interval".
+func NewAthenzProbe(cfg config.Athenz, rcfg config.Readiness) (AthenzProbe, error) {
+	dur, err := time.ParseDuration(rcfg.AthenzProbeInterval)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid athenz probe interval %s", rcfg.AthenzProbeInterval)
+	}
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid athenz timeout %s", cfg.Timeout)
+	}
+
+	tcfg := new(tls.Config)
+	if cfg.AthenzRootCA != "" {
+		tcfg.RootCAs, err = NewX509CertPool(config.GetActualValue(cfg.AthenzRootCA))
+		if err != nil {
+			return nil, errors.Wrap(err, "athenz probe x509 certpool error")
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tcfg
+
+	return &athenzProbe{
+		url:      strings.TrimSuffix(cfg.URL, "/") + rcfg.GetAthenzProbePath(),
+		interval: dur,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+		result: new(atomic.Value),
+	}, nil
+}
+
+// StartAthenzProbe returns an AthenzProbe.
+// It starts a go routine to probe the Athenz server periodically.
+func (p *athenzProbe) StartAthenzProbe(ctx context.Context) AthenzProbe {
+	go func() {
+		p.update(ctx)
+
+		ticker := time.NewTicker(p.interval)
+		for {
+			select {
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			case <-ticker.C:
+				p.update(ctx)
+			}
+		}
+	}()
+	return p
+}
+
+// Ready returns nil if the last probe succeeded, otherwise returns the reason.
+func (p *athenzProbe) Ready() error {
+	r := p.result.Load()
+	if r == nil {
+		return ErrAthenzNotProbed
+	}
+	return r.(probeResult).err
+}
+
+// update probes the Athenz server, and stores the result. The failure is logged only when the result changes.
+func (p *athenzProbe) update(ctx context.Context) {
+	err := p.probe(ctx)
+	prev := p.Ready()
+	p.result.Store(probeResult{err: err})
+
+	if err != nil && (prev == nil || prev == ErrAthenzNotProbed) {
+		err = glg.Error(errors.Wrap(err, "athenz probe failed"))
+		if err != nil {
+			glg.Fatal(err)
+		}
+	}
+	if err == nil && prev != nil && prev != ErrAthenzNotProbed {
+		err = glg.Info("athenz probe recovered")
+		if err != nil {
+			glg.Fatal(err)
+		}
+	}
+}
+
+// probe sends a HTTP GET request to the Athenz server, and returns error if the response status is not 2xx.
+func (p *athenzProbe) probe(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, p.url, nil)
+	if err != nil {
+		return errors.Wrap(err, "athenz probe request creation failed")
+	}
+	res, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "athenz probe request failed")
+	}
+	defer res.Body.Close()
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	if err != nil {
+		return errors.Wrap(err, "athenz probe response read failed")
+	}
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return errors.Errorf("athenz probe %s returns %s", p.url, res.Status)
+	}
+	return nil
+}
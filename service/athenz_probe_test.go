@@ -0,0 +1,128 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kpango/glg"
+	"github.com/yahoojapan/garm/config"
+)
+
+func TestNewAthenzProbe(t *testing.T) {
+	type args struct {
+		cfg  config.Athenz
+		rcfg config.Readiness
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantURL string
+		wantErr error
+	}{
+		{
+			name: "Check NewAthenzProbe success",
+			args: args{
+				cfg: config.Athenz{
+					URL:     "https://athenz.io/zts/v1/",
+					Timeout: "1s",
+				},
+				rcfg: config.Readiness{
+					AthenzProbeInterval: "1s",
+				},
+			},
+			wantURL: "https://athenz.io/zts/v1/status",
+		},
+		{
+			name: "Check NewAthenzProbe fail with invalid root CA",
+			args: args{
+				cfg: config.Athenz{
+					Timeout:      "1s",
+					AthenzRootCA: "./testdata/notexists.pem",
+				},
+				rcfg: config.Readiness{
+					AthenzProbeInterval: "1s",
+				},
+			},
+			wantErr: fmt.Errorf("athenz probe x509 certpool error: failed to read pem file: open ./testdata/notexists.pem: no such file or directory"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewAthenzProbe(tt.args.cfg, tt.args.rcfg)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("NewAthenzProbe() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("NewAthenzProbe() unexpected error: %v", err)
+				return
+			}
+			if got.(*athenzProbe).url != tt.wantURL {
+				t.Errorf("NewAthenzProbe() url = %v, want %v", got.(*athenzProbe).url, tt.wantURL)
+			}
+		})
+	}
+}
+
+func Test_athenzProbe_StartAthenzProbe(t *testing.T) {
+	glg.Get().SetLevelMode(glg.ERR, glg.NONE)
+	glg.Get().SetLevelMode(glg.INFO, glg.NONE)
+
+	status := int32(http.StatusInternalServerError)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	p, err := NewAthenzProbe(config.Athenz{
+		URL:     srv.URL,
+		Timeout: "1s",
+	}, config.Readiness{
+		AthenzProbeInterval: "10ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Ready(); err != ErrAthenzNotProbed {
+		t.Errorf("Ready() before probe = %v, want %v", err, ErrAthenzNotProbed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.StartAthenzProbe(ctx)
+
+	time.Sleep(time.Millisecond * 50)
+	want := fmt.Sprintf("athenz probe %s/status returns 500 Internal Server Error", srv.URL)
+	if err := p.Ready(); err == nil || err.Error() != want {
+		t.Errorf("Ready() on failure = %v, want %v", err, want)
+	}
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	time.Sleep(time.Millisecond * 50)
+	if err := p.Ready(); err != nil {
+		t.Errorf("Ready() on recovery = %v, want nil", err)
+	}
+}
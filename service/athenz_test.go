@@ -19,17 +19,20 @@ package service
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	webhook "github.com/yahoo/k8s-athenz-webhook"
 	"github.com/yahoojapan/garm/config"
+	"github.com/yahoojapan/garm/metrics"
 
 	authn "k8s.io/api/authentication/v1beta1"
 	authz "k8s.io/api/authorization/v1beta1"
@@ -195,6 +198,22 @@ func TestNewAthenz(t *testing.T) {
 				},
 			}
 		}(),
+		{
+			name: "Check NewAthenz fail with invalid cache size",
+			args: args{
+				cfg: config.Athenz{
+					Timeout: "1s",
+					Cache: config.Cache{
+						Enabled:  true,
+						AllowTTL: "1s",
+						DenyTTL:  "1s",
+					},
+				},
+				log: NewLogger(config.Logger{}),
+			},
+			want:      nil,
+			wantError: fmt.Errorf("authorization cache instantiate failed: invalid cache size 0"),
+		},
 		{
 			name:      "Check NewAthenz fail with nil cfg",
 			args:      args{},
@@ -240,7 +259,7 @@ func TestNewAthenz(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := NewAthenz(tt.args.cfg, tt.args.log)
+			got, err := NewAthenz(tt.args.cfg, tt.args.log, nil, nil)
 			if !reflect.DeepEqual(errToStr(err), errToStr(tt.wantError)) {
 				t.Errorf("NewAthenz() error = %v, wantError %v", err, tt.wantError)
 				return
@@ -334,6 +353,38 @@ func Test_athenz_AthenzAuthenticator(t *testing.T) {
 			},
 			checkFunc: cmpResponse,
 		},
+		{
+			name: "Check AthenzAuthenticator handles v1 TokenReview",
+			fields: fields{
+				authn: versionTestAuthenticator(t),
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "http://dummy.url", bytes.NewBufferString(`{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"dummy-token"}}`)),
+			},
+			wantError: nil,
+			want: &httptest.ResponseRecorder{
+				Code: 200,
+				Body: bytes.NewBufferString(`{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","status":{"authenticated":true,"user":{"username":"dummy-token"}}}`),
+			},
+			checkFunc: cmpResponse,
+		},
+		{
+			name: "Check AthenzAuthenticator handles v1beta1 TokenReview",
+			fields: fields{
+				authn: versionTestAuthenticator(t),
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "http://dummy.url", bytes.NewBufferString(`{"apiVersion":"authentication.k8s.io/v1beta1","kind":"TokenReview","spec":{"token":"dummy-token"}}`)),
+			},
+			wantError: nil,
+			want: &httptest.ResponseRecorder{
+				Code: 200,
+				Body: bytes.NewBufferString(`{"apiVersion":"authentication.k8s.io/v1beta1","kind":"TokenReview","status":{"authenticated":true,"user":{"username":"dummy-token"}}}`),
+			},
+			checkFunc: cmpResponse,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -358,6 +409,7 @@ func Test_athenz_AthenzAuthorizer(t *testing.T) {
 		authConfig config.Athenz
 		authn      http.Handler
 		authz      http.Handler
+		denial     *denial
 	}
 	type args struct {
 		w http.ResponseWriter
@@ -411,6 +463,31 @@ func Test_athenz_AthenzAuthorizer(t *testing.T) {
 			},
 			checkFunc: cmpResponse,
 		},
+		{
+			name: "Check AthenzAuthorizer passes trace to the handler",
+			fields: fields{
+				authz: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if traceFrom(r.Context()) == nil {
+						http.Error(w, "trace not found", http.StatusInternalServerError)
+						return
+					}
+					_, err := io.WriteString(w, "trace found")
+					if err != nil {
+						t.Error(err)
+					}
+				}),
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "http://dummy.url", nil),
+			},
+			wantError: nil,
+			want: &httptest.ResponseRecorder{
+				Code: 200,
+				Body: bytes.NewBufferString("trace found"),
+			},
+			checkFunc: cmpResponse,
+		},
 		{
 			name: "Check AthenzAuthorizer fail with HTTP error",
 			fields: fields{
@@ -429,6 +506,94 @@ func Test_athenz_AthenzAuthorizer(t *testing.T) {
 			},
 			checkFunc: cmpResponse,
 		},
+		{
+			name: "Check AthenzAuthorizer replaces the denial reason",
+			fields: fields{
+				authz: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					traceFrom(r.Context()).mapped(mapping{
+						spec: authz.SubjectAccessReviewSpec{
+							ResourceAttributes: &authz.ResourceAttributes{
+								Verb:     "delete",
+								Resource: "nodes",
+							},
+						},
+						action: config.ActionDeny,
+						rules:  []string{ruleBlackList},
+					})
+					_, err := io.WriteString(w, `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","status":{"allowed":false,"reason":"help","evaluationError":"mapping error"}}`)
+					if err != nil {
+						t.Error(err)
+					}
+				}),
+				denial: &denial{
+					message: "cannot _verb_ _resource_, _reason_.",
+					help:    " help",
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "http://dummy.url", nil),
+			},
+			wantError: nil,
+			want: &httptest.ResponseRecorder{
+				Code: 200,
+				Body: bytes.NewBufferString(`{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","status":{"allowed":false,"reason":"cannot delete nodes, rejected by black_list. help","evaluationError":"mapping error"}}`),
+			},
+			checkFunc: cmpResponse,
+		},
+		{
+			name: "Check AthenzAuthorizer handles v1 SubjectAccessReview",
+			fields: fields{
+				authz: versionTestAuthorizer(),
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "http://dummy.url", bytes.NewBufferString(`{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"resourceAttributes":{"namespace":"ns","verb":"get","resource":"pods"},"user":"alice","groups":["admins"]}}`)),
+			},
+			wantError: nil,
+			want: &httptest.ResponseRecorder{
+				Code: 200,
+				Body: bytes.NewBufferString(`{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","status":{"allowed":true}}`),
+			},
+			checkFunc: cmpResponse,
+		},
+		{
+			name: "Check AthenzAuthorizer handles v1beta1 SubjectAccessReview",
+			fields: fields{
+				authz: versionTestAuthorizer(),
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "http://dummy.url", bytes.NewBufferString(`{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","spec":{"resourceAttributes":{"namespace":"ns","verb":"get","resource":"pods"},"user":"alice","group":["admins"]}}`)),
+			},
+			wantError: nil,
+			want: &httptest.ResponseRecorder{
+				Code: 200,
+				Body: bytes.NewBufferString(`{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","status":{"allowed":true}}`),
+			},
+			checkFunc: cmpResponse,
+		},
+		{
+			name: "Check AthenzAuthorizer fail with invalid v1 SubjectAccessReview",
+			fields: fields{
+				authz: versionTestAuthorizer(),
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest("POST", "http://dummy.url", bytes.NewBufferString(`{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"user":1}}`)),
+			},
+			wantError: nil,
+			want: &httptest.ResponseRecorder{
+				Code: 400,
+				Body: bytes.NewBufferString("invalid v1 SubjectAccessReview: "),
+			},
+			checkFunc: func(got, want *httptest.ResponseRecorder) error {
+				if got.Code != want.Code || !strings.HasPrefix(got.Body.String(), want.Body.String()) {
+					return fmt.Errorf("athenz.AthenzAuthorizer() code = %v, body = %v, wanted code %v, body prefix %v", got.Code, got.Body.String(), want.Code, want.Body.String())
+				}
+				return nil
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -436,6 +601,7 @@ func Test_athenz_AthenzAuthorizer(t *testing.T) {
 				authConfig: tt.fields.authConfig,
 				authn:      tt.fields.authn,
 				authz:      tt.fields.authz,
+				denial:     tt.fields.denial,
 			}
 			if err := a.AthenzAuthorizer(tt.args.w, tt.args.r); !reflect.DeepEqual(err, tt.wantError) {
 				t.Errorf("athenz.AthenzAuthorizer() error = %v, wantError %v", err, tt.wantError)
@@ -447,3 +613,227 @@ func Test_athenz_AthenzAuthorizer(t *testing.T) {
 		})
 	}
 }
+
+func Test_requestHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		newHandler func(webhook.LogProvider) http.Handler
+	}{
+		{
+			name: "Check webhook authorizer logs with the request ID of the trace",
+			newHandler: func(lp webhook.LogProvider) http.Handler {
+				return webhook.NewAuthorizer(webhook.AuthorizationConfig{
+					Config: webhook.Config{
+						LogProvider: lp,
+					},
+					Mapper: cacheTestMapper{},
+				})
+			},
+		},
+		{
+			name: "Check webhook authenticator logs with the request ID of the trace",
+			newHandler: func(lp webhook.LogProvider) http.Handler {
+				return webhook.NewAuthenticator(webhook.AuthenticationConfig{
+					Config: webhook.Config{
+						LogProvider: lp,
+					},
+					Mapper: dummyMapper(""),
+				})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []string
+			h := requestHandler(func(requestID string) webhook.Logger {
+				ids = append(ids, requestID)
+				return dummyLogger(requestID)
+			}, tt.newHandler)
+
+			ctx, tr := withTrace(context.Background())
+			// the invalid request is logged by the webhook library
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("{")).WithContext(ctx))
+			if len(ids) == 0 {
+				t.Error("requestHandler() webhook library did not log")
+			}
+			for _, id := range ids {
+				if id != tr.requestID {
+					t.Errorf("requestHandler() logged with request ID %v, want %v", id, tr.requestID)
+				}
+			}
+		})
+	}
+}
+
+func Test_authnOutcome(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   metrics.Outcome
+	}{
+		{
+			name:   "Check authenticated",
+			status: http.StatusOK,
+			body:   `{"status":{"authenticated":true}}`,
+			want:   metrics.OutcomeAllowed,
+		},
+		{
+			name:   "Check not authenticated",
+			status: http.StatusOK,
+			body:   `{"status":{"authenticated":false,"error":"invalid token"}}`,
+			want:   metrics.OutcomeDenied,
+		},
+		{
+			name:   "Check bad request",
+			status: http.StatusBadRequest,
+			body:   "bad request",
+			want:   metrics.OutcomeError,
+		},
+		{
+			name:   "Check invalid response body",
+			status: http.StatusOK,
+			body:   "{",
+			want:   metrics.OutcomeError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+			rec.WriteHeader(tt.status)
+			_, err := rec.Write([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := authnOutcome(rec); got != tt.want {
+				t.Errorf("authnOutcome() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_authzOutcome(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		trace  trace
+		want   metrics.Outcome
+	}{
+		{
+			name:   "Check allowed",
+			status: http.StatusOK,
+			body:   `{"status":{"allowed":true}}`,
+			want:   metrics.OutcomeAllowed,
+		},
+		{
+			name:   "Check denied by Athenz",
+			status: http.StatusOK,
+			body:   `{"status":{"allowed":false,"evaluationError":"principal user.name does not have access to any of 'get on k8s:pods' resources"}}`,
+			trace: trace{
+				mapping: mapping{
+					identity: "user.name",
+					checks: []webhook.AthenzAccessCheck{
+						{
+							Action:   "get",
+							Resource: "k8s:pods",
+						},
+					},
+				},
+			},
+			want: metrics.OutcomeDenied,
+		},
+		{
+			name:   "Check rejected by black_list",
+			status: http.StatusOK,
+			body:   `{"status":{"allowed":false,"evaluationError":"mapping error: request is not allowed"}}`,
+			trace: trace{
+				mapping: mapping{
+					action: config.ActionDeny,
+					rules:  []string{ruleBlackList},
+				},
+			},
+			want: metrics.OutcomeBlacklisted,
+		},
+		{
+			name:   "Check Athenz internal error",
+			status: http.StatusOK,
+			body:   `{"status":{"allowed":false,"reason":"internal setup error.","evaluationError":"401 Unauthorized"}}`,
+			want:   metrics.OutcomeError,
+		},
+		{
+			name:   "Check bad request",
+			status: http.StatusBadRequest,
+			body:   "bad request",
+			want:   metrics.OutcomeError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+			rec.WriteHeader(tt.status)
+			_, err := rec.Write([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := authzOutcome(rec, &tt.trace); got != tt.want {
+				t.Errorf("authzOutcome() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// versionTestAuthorizer returns the webhook authorizer allowing the requests of the "admins" group without querying Athenz.
+func versionTestAuthorizer() http.Handler {
+	return webhook.NewAuthorizer(webhook.AuthorizationConfig{
+		Config: webhook.Config{
+			LogProvider: func(requestID string) webhook.Logger {
+				return dummyLogger(requestID)
+			},
+		},
+		Mapper: NewResourceMapper(&resolve{
+			cfg: config.Platform{
+				WhiteListLocalAllow: true,
+				WhiteList: []*config.RequestInfo{
+					{
+						Verb:      "*",
+						Namespace: "*",
+						APIGroup:  "*",
+						Resource:  "*",
+						Name:      "*",
+						Group:     "admins",
+					},
+				},
+			},
+		}),
+	})
+}
+
+// versionTestAuthenticator returns the handler authenticating the token of the v1beta1 TokenReview as the username.
+func versionTestAuthenticator(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tr authn.TokenReview
+		err := json.NewDecoder(r.Body).Decode(&tr)
+		if err != nil || tr.APIVersion != "authentication.k8s.io/v1beta1" {
+			http.Error(w, fmt.Sprintf("unsupported request %v: %v", tr, err), http.StatusBadRequest)
+			return
+		}
+		b, err := json.Marshal(struct {
+			APIVersion string                  `json:"apiVersion"`
+			Kind       string                  `json:"kind"`
+			Status     authn.TokenReviewStatus `json:"status"`
+		}{tr.APIVersion, tr.Kind, authn.TokenReviewStatus{
+			Authenticated: true,
+			User: authn.UserInfo{
+				Username: tr.Spec.Token,
+			},
+		}})
+		if err != nil {
+			t.Error(err)
+		}
+		_, err = w.Write(b)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
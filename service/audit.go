@@ -0,0 +1,87 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yahoojapan/garm/audit"
+	"github.com/yahoojapan/garm/metrics"
+	authn "k8s.io/api/authentication/v1beta1"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+// authnRecord returns the audit record of the TokenReview request.
+// The user is taken from the response, hence, the token in the request is never recorded.
+func authnRecord(start time.Time, t *trace, rec *responseRecorder, outcome metrics.Outcome) audit.Record {
+	r := newRecord(start, t, metrics.EndpointAuthn, outcome)
+
+	var tr struct {
+		Status authn.TokenReviewStatus `json:"status"`
+	}
+	if rec.status != http.StatusOK || json.Unmarshal(rec.body.Bytes(), &tr) != nil {
+		r.Error = strings.TrimSpace(rec.body.String())
+		return r
+	}
+	r.User = tr.Status.User.Username
+	r.Groups = tr.Status.User.Groups
+	r.Error = tr.Status.Error
+	return r
+}
+
+// authzRecord returns the audit record of the SubjectAccessReview request.
+func authzRecord(start time.Time, t *trace, rec *responseRecorder, outcome metrics.Outcome) audit.Record {
+	r := newRecord(start, t, metrics.EndpointAuthz, outcome)
+	r.User = t.spec.User
+	r.Groups = t.spec.Groups
+	r.ResourceAttributes = t.spec.ResourceAttributes
+	r.NonResourceAttributes = t.spec.NonResourceAttributes
+	r.Action = t.action
+	r.MatchedRules = t.rules
+	r.Cached = t.cached
+	r.LocalPolicy = t.local
+	for _, c := range t.checks {
+		r.AccessChecks = append(r.AccessChecks, c.String())
+	}
+
+	var sar struct {
+		Status authz.SubjectAccessReviewStatus `json:"status"`
+	}
+	if rec.status != http.StatusOK || json.Unmarshal(rec.body.Bytes(), &sar) != nil {
+		r.Error = strings.TrimSpace(rec.body.String())
+		return r
+	}
+	r.Reason = sar.Status.Reason
+	r.Error = sar.Status.EvaluationError
+	return r
+}
+
+// newRecord returns the audit record with the common fields of both endpoints.
+func newRecord(start time.Time, t *trace, endpoint string, outcome metrics.Outcome) audit.Record {
+	return audit.Record{
+		Time:           start,
+		RequestID:      t.requestID,
+		Endpoint:       endpoint,
+		Profile:        t.profile,
+		Identity:       t.identity,
+		Decision:       string(outcome),
+		LatencySeconds: time.Since(start).Seconds(),
+	}
+}
@@ -0,0 +1,289 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/audit"
+	"github.com/yahoojapan/garm/config"
+	"github.com/yahoojapan/garm/metrics"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+func Test_authnRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		outcome metrics.Outcome
+		want    audit.Record
+	}{
+		{
+			name:    "Check authenticated user is recorded",
+			status:  http.StatusOK,
+			body:    `{"status":{"authenticated":true,"user":{"username":"athenz.user","groups":["athenz.group"]}}}`,
+			outcome: metrics.OutcomeAllowed,
+			want: audit.Record{
+				RequestID: "id",
+				Endpoint:  metrics.EndpointAuthn,
+				User:      "athenz.user",
+				Groups:    []string{"athenz.group"},
+				Identity:  "athenz.user",
+				Decision:  "allowed",
+			},
+		},
+		{
+			name:    "Check authentication error is recorded",
+			status:  http.StatusOK,
+			body:    `{"status":{"authenticated":false,"error":"invalid token"}}`,
+			outcome: metrics.OutcomeDenied,
+			want: audit.Record{
+				RequestID: "id",
+				Endpoint:  metrics.EndpointAuthn,
+				Identity:  "athenz.user",
+				Decision:  "denied",
+				Error:     "invalid token",
+			},
+		},
+		{
+			name:    "Check HTTP error is recorded",
+			status:  http.StatusBadRequest,
+			body:    "bad request\n",
+			outcome: metrics.OutcomeError,
+			want: audit.Record{
+				RequestID: "id",
+				Endpoint:  metrics.EndpointAuthn,
+				Identity:  "athenz.user",
+				Decision:  "error",
+				Error:     "bad request",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+			rec.WriteHeader(tt.status)
+			_, err := rec.Write([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := &trace{
+				requestID: "id",
+				mapping: mapping{
+					identity: "athenz.user",
+				},
+			}
+			start := time.Now()
+			got := authnRecord(start, tr, rec, tt.outcome)
+			if got.LatencySeconds < 0 {
+				t.Errorf("authnRecord() LatencySeconds = %v, want non-negative", got.LatencySeconds)
+			}
+			tt.want.Time = start
+			tt.want.LatencySeconds = got.LatencySeconds
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("authnRecord() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_authzRecord(t *testing.T) {
+	spec := authz.SubjectAccessReviewSpec{
+		User:   "user",
+		Groups: []string{"group"},
+		ResourceAttributes: &authz.ResourceAttributes{
+			Namespace: "ns",
+			Verb:      "get",
+			Resource:  "pods",
+		},
+	}
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		trace   trace
+		outcome metrics.Outcome
+		want    audit.Record
+	}{
+		{
+			name:   "Check allowed request is recorded",
+			status: http.StatusOK,
+			body:   `{"status":{"allowed":true}}`,
+			trace: trace{
+				requestID: "id",
+				mapping: mapping{
+					spec:     spec,
+					identity: "athenz.user",
+					action:   config.ActionAdminDomain,
+					rules:    []string{ruleAdminAccessList},
+					checks: []webhook.AthenzAccessCheck{
+						{
+							Action:   "get",
+							Resource: "k8s.admin:pods",
+						},
+					},
+				},
+				cached: true,
+			},
+			outcome: metrics.OutcomeAllowed,
+			want: audit.Record{
+				RequestID:          "id",
+				Endpoint:           metrics.EndpointAuthz,
+				User:               "user",
+				Groups:             []string{"group"},
+				ResourceAttributes: spec.ResourceAttributes,
+				Identity:           "athenz.user",
+				AccessChecks:       []string{"get on k8s.admin:pods"},
+				Action:             config.ActionAdminDomain,
+				MatchedRules:       []string{ruleAdminAccessList},
+				Cached:             true,
+				Decision:           "allowed",
+			},
+		},
+		{
+			name:   "Check request decided by local policies is recorded",
+			status: http.StatusOK,
+			body:   `{"status":{"allowed":true}}`,
+			trace: trace{
+				requestID: "id",
+				mapping: mapping{
+					spec:     spec,
+					identity: "athenz.user",
+					checks: []webhook.AthenzAccessCheck{
+						{
+							Action:   "get",
+							Resource: "k8s:pods",
+						},
+					},
+				},
+				local: true,
+			},
+			outcome: metrics.OutcomeAllowed,
+			want: audit.Record{
+				RequestID:          "id",
+				Endpoint:           metrics.EndpointAuthz,
+				User:               "user",
+				Groups:             []string{"group"},
+				ResourceAttributes: spec.ResourceAttributes,
+				Identity:           "athenz.user",
+				AccessChecks:       []string{"get on k8s:pods"},
+				LocalPolicy:        true,
+				Decision:           "allowed",
+			},
+		},
+		{
+			name:   "Check denied request is recorded",
+			status: http.StatusOK,
+			body:   `{"status":{"allowed":false,"reason":"denied","evaluationError":"mapping error: request is not allowed"}}`,
+			trace: trace{
+				requestID: "id",
+				mapping: mapping{
+					spec:   spec,
+					action: config.ActionDeny,
+					rules:  []string{ruleBlackList},
+				},
+			},
+			outcome: metrics.OutcomeBlacklisted,
+			want: audit.Record{
+				RequestID:          "id",
+				Endpoint:           metrics.EndpointAuthz,
+				User:               "user",
+				Groups:             []string{"group"},
+				ResourceAttributes: spec.ResourceAttributes,
+				Action:             config.ActionDeny,
+				MatchedRules:       []string{ruleBlackList},
+				Decision:           "blacklisted",
+				Reason:             "denied",
+				Error:              "mapping error: request is not allowed",
+			},
+		},
+		{
+			name:   "Check HTTP error is recorded",
+			status: http.StatusInternalServerError,
+			body:   "internal error\n",
+			trace: trace{
+				requestID: "id",
+			},
+			outcome: metrics.OutcomeError,
+			want: audit.Record{
+				RequestID: "id",
+				Endpoint:  metrics.EndpointAuthz,
+				Decision:  "error",
+				Error:     "internal error",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+			rec.WriteHeader(tt.status)
+			_, err := rec.Write([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			got := authzRecord(start, &tt.trace, rec, tt.outcome)
+			tt.want.Time = start
+			tt.want.LatencySeconds = got.LatencySeconds
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("authzRecord() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_athenz_audit(t *testing.T) {
+	buf := new(bytes.Buffer)
+	a := &athenz{
+		authz: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceFrom(r.Context()).mapped(mapping{
+				identity: "athenz.user",
+			})
+			_, err := io.WriteString(w, `{"status":{"allowed":true}}`)
+			if err != nil {
+				t.Error(err)
+			}
+		}),
+		auditor: audit.NewWithWriter(buf),
+	}
+	err := a.AthenzAuthorizer(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://dummy.url", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got audit.Record
+	err = json.Unmarshal(buf.Bytes(), &got)
+	if err != nil {
+		t.Fatalf("audit record = %q, unmarshal error: %v", buf.String(), err)
+	}
+	if got.RequestID == "" || got.Endpoint != metrics.EndpointAuthz || got.Identity != "athenz.user" || got.Decision != "allowed" {
+		t.Errorf("audit record = %+v, want allowed authz record of athenz.user", got)
+	}
+
+	// audit is disabled
+	a.auditor = nil
+	a.audit(audit.Record{})
+}
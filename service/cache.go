@@ -0,0 +1,246 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+// decisionCache is a size bounded LRU cache of authorization decisions, each decision expires after its TTL.
+type decisionCache struct {
+	mu sync.Mutex
+	// allowTTL is the duration to cache an allowed decision.
+	allowTTL time.Duration
+	// denyTTL is the duration to cache a decision denied by Athenz.
+	denyTTL time.Duration
+	// size is the maximum number of cached decisions.
+	size int
+	// ll holds the cached decisions, from the most recently used to the least recently used.
+	ll *list.List
+	// items indexes the elements of ll by key.
+	items map[string]*list.Element
+}
+
+// decision is a cached authorization decision.
+type decision struct {
+	key    string
+	status authz.SubjectAccessReviewStatus
+	expiry time.Time
+}
+
+// cachedAuthorizer is a http.Handler answering SubjectAccessReview from decisionCache, and passing the cache misses to the Athenz authorizer.
+type cachedAuthorizer struct {
+	// next is the Athenz authorizer.
+	next http.Handler
+	// cache holds the authorization decisions.
+	cache *decisionCache
+	// mapper creates the cache key from the request.
+	mapper webhook.ResourceMapper
+	// lp creates the logger outputting the cache hit and miss with the request ID.
+	lp webhook.LogProvider
+}
+
+// newDecisionCache returns a decisionCache based on the given configuration.
+func newDecisionCache(cfg config.Cache) (*decisionCache, error) {
+	allow, err := time.ParseDuration(cfg.AllowTTL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid cache allow_ttl %s", cfg.AllowTTL)
+	}
+	deny, err := time.ParseDuration(cfg.DenyTTL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid cache deny_ttl %s", cfg.DenyTTL)
+	}
+	if cfg.Size <= 0 {
+		return nil, errors.Errorf("invalid cache size %d", cfg.Size)
+	}
+	return &decisionCache{
+		allowTTL: allow,
+		denyTTL:  deny,
+		size:     cfg.Size,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element, cfg.Size),
+	}, nil
+}
+
+// get returns the unexpired decision of the key, and marks it as the most recently used.
+func (c *decisionCache) get(key string) (authz.SubjectAccessReviewStatus, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return authz.SubjectAccessReviewStatus{}, false
+	}
+	d := e.Value.(*decision)
+	if time.Now().After(d.expiry) {
+		c.ll.Remove(e)
+		delete(c.items, key)
+		return authz.SubjectAccessReviewStatus{}, false
+	}
+	c.ll.MoveToFront(e)
+	return d.status, true
+}
+
+// set caches the decision of the key with the TTL of allowed or denied decisions.
+// The least recently used decision is evicted if the cache is full.
+func (c *decisionCache) set(key string, status authz.SubjectAccessReviewStatus) {
+	ttl := c.allowTTL
+	if !status.Allowed {
+		ttl = c.denyTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := &decision{
+		key:    key,
+		status: status,
+		expiry: time.Now().Add(ttl),
+	}
+	if e, ok := c.items[key]; ok {
+		e.Value = d
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(d)
+	if c.ll.Len() > c.size {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.items, e.Value.(*decision).key)
+	}
+}
+
+// newCachedAuthorizer returns a http.Handler caching the authorization decisions of next.
+func newCachedAuthorizer(next http.Handler, cache *decisionCache, mapper webhook.ResourceMapper, lp webhook.LogProvider) http.Handler {
+	return &cachedAuthorizer{
+		next:   next,
+		cache:  cache,
+		mapper: mapper,
+		lp:     lp,
+	}
+}
+
+// ServeHTTP answers the request from the cache if the same principal requested the same Athenz access checks recently.
+// Requests without Athenz access checks (e.g. rejected by black_list) are always passed to next.
+// Only the decisions allowed or denied by Athenz are cached, errors are not cached.
+func (c *cachedAuthorizer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var sar authz.SubjectAccessReview
+	if json.Unmarshal(body, &sar) != nil {
+		// let the authorizer report the error
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	principal, checks, err := c.mapper.MapResource(r.Context(), sar.Spec)
+	if err != nil || len(checks) == 0 {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	key := cacheKey(principal, checks)
+	if status, ok := c.cache.get(key); ok {
+		if t := traceFrom(r.Context()); t != nil {
+			t.cached = true
+		}
+		c.lp(requestIDFrom(r.Context())).Printf("authz cache hit %s -> allowed=%t\n", key, status.Allowed)
+		writeStatus(w, sar, status)
+		return
+	}
+	c.lp(requestIDFrom(r.Context())).Printf("authz cache miss %s\n", key)
+
+	rec := &responseRecorder{ResponseWriter: w}
+	c.next.ServeHTTP(rec, r)
+
+	var res struct {
+		Status authz.SubjectAccessReviewStatus `json:"status"`
+	}
+	if rec.status != http.StatusOK || json.Unmarshal(rec.body.Bytes(), &res) != nil {
+		return
+	}
+	if res.Status.Allowed || isAthenzDenial(res.Status, principal, checks) {
+		c.cache.set(key, res.Status)
+	}
+}
+
+// cacheKey returns the cache key of the principal and the Athenz access checks.
+func cacheKey(principal string, checks []webhook.AthenzAccessCheck) string {
+	return principal + ": " + quoteChecks(checks)
+}
+
+// quoteChecks returns the quoted Athenz access checks joined by ",", e.g. "'get on k8s:pods','get on k8s.ns:pods'".
+func quoteChecks(checks []webhook.AthenzAccessCheck) string {
+	list := make([]string, 0, len(checks))
+	for _, c := range checks {
+		list = append(list, "'"+c.String()+"'")
+	}
+	return strings.Join(list, ",")
+}
+
+// writeStatus writes the SubjectAccessReview response with the given status, in the same format as the Athenz authorizer.
+func writeStatus(w http.ResponseWriter, sar authz.SubjectAccessReview, status authz.SubjectAccessReviewStatus) {
+	b, err := json.Marshal(struct {
+		APIVersion string                          `json:"apiVersion"`
+		Kind       string                          `json:"kind"`
+		Status     authz.SubjectAccessReviewStatus `json:"status"`
+	}{sar.APIVersion, sar.Kind, status})
+	if err != nil {
+		http.Error(w, "internal serialization error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(b)
+	if err != nil {
+		err = glg.Error(errors.Wrap(err, "cached authorization response failed"))
+		if err != nil {
+			glg.Fatal(errors.Wrap(err, "error log output failed"))
+		}
+	}
+}
+
+// isAthenzDenial returns true if the status is the rejection answered by Athenz that the principal does not have access to any of the checks.
+func isAthenzDenial(status authz.SubjectAccessReviewStatus, principal string, checks []webhook.AthenzAccessCheck) bool {
+	return !status.Allowed && len(checks) != 0 && status.EvaluationError == athenzDenialMessage(principal, checks)
+}
+
+// athenzDenialMessage returns the evaluation error set by the webhook authorizer when Athenz answered that the principal does not have access to any of the checks.
+// The webhook library has no other way to tell the denial from the errors, the message is pinned by Test_athenzDenialMessage.
+func athenzDenialMessage(principal string, checks []webhook.AthenzAccessCheck) string {
+	return fmt.Sprintf("principal %s does not have access to any of %s resources", principal, quoteChecks(checks))
+}
@@ -0,0 +1,450 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+// cacheTestMapper is a mock implementation of webhook.ResourceMapper, mapping the verb to the action.
+type cacheTestMapper struct{}
+
+// MapResource returns an access check of the verb, or error if the verb is "error", or no checks if the verb is "none".
+func (cacheTestMapper) MapResource(ctx context.Context, spec authz.SubjectAccessReviewSpec) (string, []webhook.AthenzAccessCheck, error) {
+	switch spec.ResourceAttributes.Verb {
+	case "error":
+		return "", nil, fmt.Errorf("mapping error")
+	case "none":
+		return spec.User, nil, nil
+	}
+	return spec.User, []webhook.AthenzAccessCheck{
+		{
+			Action:   spec.ResourceAttributes.Verb,
+			Resource: "k8s:pods",
+		},
+	}, nil
+}
+
+func TestNewDecisionCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Cache
+		wantErr error
+	}{
+		{
+			name: "Check newDecisionCache success",
+			cfg: config.Cache{
+				AllowTTL: "1s",
+				DenyTTL:  "0s",
+				Size:     1,
+			},
+		},
+		{
+			name: "Check newDecisionCache fail with invalid size",
+			cfg: config.Cache{
+				AllowTTL: "1s",
+				DenyTTL:  "1s",
+			},
+			wantErr: fmt.Errorf("invalid cache size 0"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newDecisionCache(tt.cfg)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("newDecisionCache() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("newDecisionCache() unexpected error: %v", err)
+				return
+			}
+			if got.size != tt.cfg.Size || got.allowTTL != time.Second || got.denyTTL != 0 {
+				t.Errorf("newDecisionCache() = %+v", got)
+			}
+		})
+	}
+}
+
+func Test_decisionCache(t *testing.T) {
+	allowed := authz.SubjectAccessReviewStatus{Allowed: true}
+	denied := authz.SubjectAccessReviewStatus{EvaluationError: "principal p does not have access to any of 'a' resources"}
+
+	tests := []struct {
+		name      string
+		cfg       config.Cache
+		setFunc   func(c *decisionCache)
+		key       string
+		want      authz.SubjectAccessReviewStatus
+		wantFound bool
+	}{
+		{
+			name: "Check cached allowed decision",
+			cfg:  config.Cache{AllowTTL: "1m", DenyTTL: "1m", Size: 2},
+			setFunc: func(c *decisionCache) {
+				c.set("a", allowed)
+			},
+			key:       "a",
+			want:      allowed,
+			wantFound: true,
+		},
+		{
+			name: "Check cached denied decision",
+			cfg:  config.Cache{AllowTTL: "1m", DenyTTL: "1m", Size: 2},
+			setFunc: func(c *decisionCache) {
+				c.set("a", denied)
+			},
+			key:       "a",
+			want:      denied,
+			wantFound: true,
+		},
+		{
+			name: "Check denied decision is not cached with zero deny_ttl",
+			cfg:  config.Cache{AllowTTL: "1m", DenyTTL: "0s", Size: 2},
+			setFunc: func(c *decisionCache) {
+				c.set("a", denied)
+			},
+			key: "a",
+		},
+		{
+			name: "Check expired decision",
+			cfg:  config.Cache{AllowTTL: "10ms", DenyTTL: "1m", Size: 2},
+			setFunc: func(c *decisionCache) {
+				c.set("a", allowed)
+				time.Sleep(time.Millisecond * 20)
+			},
+			key: "a",
+		},
+		{
+			name: "Check least recently used decision is evicted",
+			cfg:  config.Cache{AllowTTL: "1m", DenyTTL: "1m", Size: 2},
+			setFunc: func(c *decisionCache) {
+				c.set("a", allowed)
+				c.set("b", allowed)
+				c.get("a")
+				c.set("c", allowed)
+			},
+			key: "b",
+		},
+		{
+			name: "Check recently used decision is kept",
+			cfg:  config.Cache{AllowTTL: "1m", DenyTTL: "1m", Size: 2},
+			setFunc: func(c *decisionCache) {
+				c.set("a", allowed)
+				c.set("b", allowed)
+				c.get("a")
+				c.set("c", allowed)
+			},
+			key:       "a",
+			want:      allowed,
+			wantFound: true,
+		},
+		{
+			name: "Check decision is overwritten",
+			cfg:  config.Cache{AllowTTL: "1m", DenyTTL: "1m", Size: 1},
+			setFunc: func(c *decisionCache) {
+				c.set("a", allowed)
+				c.set("a", denied)
+			},
+			key:       "a",
+			want:      denied,
+			wantFound: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newDecisionCache(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.setFunc(c)
+			got, found := c.get(tt.key)
+			if found != tt.wantFound || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("get() = %v, %v, want %v, %v", got, found, tt.want, tt.wantFound)
+			}
+			if c.ll.Len() != len(c.items) || c.ll.Len() > c.size {
+				t.Errorf("cache size = %d, items = %d, max = %d", c.ll.Len(), len(c.items), c.size)
+			}
+		})
+	}
+}
+
+func Test_cachedAuthorizer_ServeHTTP(t *testing.T) {
+	newRequest := func(user, verb string) *http.Request {
+		b, err := json.Marshal(authz.SubjectAccessReview{
+			Spec: authz.SubjectAccessReviewSpec{
+				User: user,
+				ResourceAttributes: &authz.ResourceAttributes{
+					Verb: verb,
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return httptest.NewRequest(http.MethodPost, "/authz", bytes.NewReader(b))
+	}
+	// next answers the status by the verb, and counts the calls
+	newNext := func(calls *int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*calls++
+			var sar authz.SubjectAccessReview
+			err := json.NewDecoder(r.Body).Decode(&sar)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			status := authz.SubjectAccessReviewStatus{}
+			switch sar.Spec.ResourceAttributes.Verb {
+			case "get":
+				status.Allowed = true
+			case "delete":
+				status.EvaluationError = "principal " + sar.Spec.User + " does not have access to any of 'delete on k8s:pods' resources"
+			default:
+				status.EvaluationError = "401 Unauthorized"
+				status.Reason = "internal setup error."
+			}
+			writeStatus(w, sar, status)
+		})
+	}
+
+	tests := []struct {
+		name      string
+		requests  []*http.Request
+		wantCalls int
+		wantBody  string
+	}{
+		{
+			name:      "Check allowed decision is cached",
+			requests:  []*http.Request{newRequest("user", "get"), newRequest("user", "get")},
+			wantCalls: 1,
+			wantBody:  `{"apiVersion":"","kind":"","status":{"allowed":true}}`,
+		},
+		{
+			name:      "Check denied decision is cached",
+			requests:  []*http.Request{newRequest("user", "delete"), newRequest("user", "delete")},
+			wantCalls: 1,
+			wantBody:  `{"apiVersion":"","kind":"","status":{"allowed":false,"evaluationError":"principal user does not have access to any of 'delete on k8s:pods' resources"}}`,
+		},
+		{
+			name:      "Check decision of another principal is not used",
+			requests:  []*http.Request{newRequest("user", "get"), newRequest("other", "get")},
+			wantCalls: 2,
+			wantBody:  `{"apiVersion":"","kind":"","status":{"allowed":true}}`,
+		},
+		{
+			name:      "Check error is not cached",
+			requests:  []*http.Request{newRequest("user", "update"), newRequest("user", "update")},
+			wantCalls: 2,
+			wantBody:  `{"apiVersion":"","kind":"","status":{"allowed":false,"reason":"internal setup error.","evaluationError":"401 Unauthorized"}}`,
+		},
+		{
+			name:      "Check mapping error is passed to next",
+			requests:  []*http.Request{newRequest("user", "error"), newRequest("user", "error")},
+			wantCalls: 2,
+			wantBody:  `{"apiVersion":"","kind":"","status":{"allowed":false,"reason":"internal setup error.","evaluationError":"401 Unauthorized"}}`,
+		},
+		{
+			name:      "Check request without access checks is passed to next",
+			requests:  []*http.Request{newRequest("user", "none"), newRequest("user", "none")},
+			wantCalls: 2,
+			wantBody:  `{"apiVersion":"","kind":"","status":{"allowed":false,"reason":"internal setup error.","evaluationError":"401 Unauthorized"}}`,
+		},
+		{
+			name:      "Check invalid request is passed to next",
+			requests:  []*http.Request{httptest.NewRequest(http.MethodPost, "/authz", bytes.NewBufferString("{"))},
+			wantCalls: 1,
+			wantBody:  "unexpected EOF\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, err := newDecisionCache(config.Cache{AllowTTL: "1m", DenyTTL: "1m", Size: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var calls int
+			h := newCachedAuthorizer(newNext(&calls), cache, cacheTestMapper{}, func(string) webhook.Logger {
+				return dummyLogger("")
+			})
+
+			var got *httptest.ResponseRecorder
+			for _, r := range tt.requests {
+				got = httptest.NewRecorder()
+				h.ServeHTTP(got, r)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("ServeHTTP() next called %d times, want %d", calls, tt.wantCalls)
+			}
+			if got.Body.String() != tt.wantBody {
+				t.Errorf("ServeHTTP() body = %v, want %v", got.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_cachedAuthorizer_ServeHTTP_trace(t *testing.T) {
+	cache, err := newDecisionCache(config.Cache{AllowTTL: "1m", DenyTTL: "1m", Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.set("user: 'get on k8s:pods'", authz.SubjectAccessReviewStatus{Allowed: true})
+	h := newCachedAuthorizer(http.NotFoundHandler(), cache, cacheTestMapper{}, func(string) webhook.Logger {
+		return dummyLogger("")
+	})
+
+	ctx, tr := withTrace(context.Background())
+	r := httptest.NewRequest(http.MethodPost, "/authz", bytes.NewBufferString(`{"spec":{"user":"user","resourceAttributes":{"verb":"get"}}}`))
+	h.ServeHTTP(httptest.NewRecorder(), r.WithContext(ctx))
+	if !tr.cached {
+		t.Error("ServeHTTP() trace is not marked as cached on cache hit")
+	}
+}
+
+func Test_cacheKey(t *testing.T) {
+	got := cacheKey("user.name", []webhook.AthenzAccessCheck{
+		{
+			Action:   "get",
+			Resource: "k8s:pods",
+		},
+		{
+			Action:   "get",
+			Resource: "admin:pods",
+		},
+	})
+	want := "user.name: 'get on k8s:pods','get on admin:pods'"
+	if got != want {
+		t.Errorf("cacheKey() = %v, want %v", got, want)
+	}
+}
+
+func Test_writeStatus(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeStatus(w, authz.SubjectAccessReview{}, authz.SubjectAccessReviewStatus{Allowed: true})
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("writeStatus() Content-Type = %v, want %v", got, "application/json")
+	}
+	if want := `{"apiVersion":"","kind":"","status":{"allowed":true}}`; w.Body.String() != want {
+		t.Errorf("writeStatus() body = %v, want %v", w.Body.String(), want)
+	}
+}
+
+func Test_isAthenzDenial(t *testing.T) {
+	checks := []webhook.AthenzAccessCheck{
+		{
+			Action:   "get",
+			Resource: "k8s:pods",
+		},
+	}
+	tests := []struct {
+		name   string
+		status authz.SubjectAccessReviewStatus
+		checks []webhook.AthenzAccessCheck
+		want   bool
+	}{
+		{
+			name:   "Check Athenz denial",
+			status: authz.SubjectAccessReviewStatus{EvaluationError: "principal user does not have access to any of 'get on k8s:pods' resources"},
+			checks: checks,
+			want:   true,
+		},
+		{
+			name:   "Check allowed",
+			status: authz.SubjectAccessReviewStatus{Allowed: true},
+			checks: checks,
+		},
+		{
+			name:   "Check denial of other access checks",
+			status: authz.SubjectAccessReviewStatus{EvaluationError: "principal user does not have access to any of 'delete on k8s:pods' resources"},
+			checks: checks,
+		},
+		{
+			name:   "Check mapping error",
+			status: authz.SubjectAccessReviewStatus{EvaluationError: "mapping error: ----user's request is not allowed----"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAthenzDenial(tt.status, "user", tt.checks); got != tt.want {
+				t.Errorf("isAthenzDenial() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_athenzDenialMessage(t *testing.T) {
+	// Athenz answers that the principal does not have access
+	zts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"granted":false}`))
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	defer zts.Close()
+
+	authorizer := webhook.NewAuthorizer(webhook.AuthorizationConfig{
+		Config: webhook.Config{
+			ZMSEndpoint: zts.URL,
+			ZTSEndpoint: zts.URL,
+			AuthHeader:  "Athenz-Principal-Auth",
+			LogProvider: func(requestID string) webhook.Logger {
+				return dummyLogger(requestID)
+			},
+		},
+		Token: func() (string, error) {
+			return "ntoken", nil
+		},
+		Mapper: cacheTestMapper{},
+	})
+	w := httptest.NewRecorder()
+	authorizer.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/authz", bytes.NewBufferString(
+		`{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","spec":{"user":"user.alice","resourceAttributes":{"verb":"get"}}}`)))
+
+	var res struct {
+		Status authz.SubjectAccessReviewStatus `json:"status"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("webhook authorizer response = %v, error: %v", w.Body.String(), err)
+	}
+	checks := []webhook.AthenzAccessCheck{
+		{
+			Action:   "get",
+			Resource: "k8s:pods",
+		},
+	}
+	if want := athenzDenialMessage("user.alice", checks); res.Status.EvaluationError != want {
+		t.Errorf("athenzDenialMessage() = %v, webhook authorizer answered %v", want, res.Status.EvaluationError)
+	}
+	if !isAthenzDenial(res.Status, "user.alice", checks) {
+		t.Errorf("isAthenzDenial() = false for the denial of the webhook authorizer %v", res.Status)
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
+	authn "k8s.io/api/authentication/v1beta1"
+)
+
+const (
+	// extraCertificateSerial is the key of the UserInfo extra field holding the serial number of the certificate.
+	extraCertificateSerial = "athenz.io/certificate-serial"
+)
+
+// certificateAuthenticator is a http.Handler authenticating the assertions signed with the Athenz X.509 service certificates, and passing other tokens to next.
+type certificateAuthenticator struct {
+	// next is the next authenticator.
+	next http.Handler
+	// roots are the Athenz root CA certificates.
+	roots *x509.CertPool
+	// parser verifies the signature and the time based claims.
+	parser *jwt.Parser
+	// audience is the expected "aud" claim.
+	audience string
+	// maxLifetime is the maximum duration between the "iat" and "exp" claims.
+	maxLifetime time.Duration
+	// mapper maps the principal of the certificate to the K8s user.
+	mapper webhook.UserMapper
+	// lp creates the logger outputting the authentication outcome with the request ID.
+	lp webhook.LogProvider
+}
+
+// newCertificateAuthenticator returns a http.Handler authenticating the certificate assertions, and passing other tokens to next.
+func newCertificateAuthenticator(next http.Handler, cfg config.Athenz, lp webhook.LogProvider) (http.Handler, error) {
+	lifetime, err := time.ParseDuration(cfg.Certificate.MaxLifetime)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid certificate max lifetime %s", cfg.Certificate.MaxLifetime)
+	}
+	roots, err := newRootCertPool(config.GetActualValue(cfg.AthenzRootCA))
+	if err != nil {
+		return nil, errors.Wrap(err, "certificate x509 certpool error")
+	}
+
+	return &certificateAuthenticator{
+		next:        next,
+		roots:       roots,
+		parser:      jwt.NewParser(jwt.WithValidMethods(accessTokenMethods)),
+		audience:    cfg.Certificate.Audience,
+		maxLifetime: lifetime,
+		mapper:      cfg.AuthN.Mapper,
+		lp:          lp,
+	}, nil
+}
+
+// newRootCertPool returns the pool of the CA certificates in the PEM file.
+// Unlike NewX509CertPool, the system CA certificates are not included, as any public CA could issue a certificate of the same CN.
+func newRootCertPool(path string) (*x509.CertPool, error) {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read pem file")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(c) {
+		return nil, errors.New("Certification Failed")
+	}
+	return pool, nil
+}
+
+// ServeHTTP answers the TokenReview of a certificate assertion, other requests are passed to next.
+func (c *certificateAuthenticator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tr, ok := readTokenReview(w, r)
+	if !ok {
+		return
+	}
+	if tr == nil || !isCertificateAssertion(tr.Spec.Token) {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	status := c.authenticate(r.Context(), tr.Spec.Token)
+	logTokenReview(c.lp(requestIDFrom(r.Context())), "certificate assertion", r, status)
+	writeTokenReview(w, tr, status)
+}
+
+// authenticate verifies the certificate assertion, and returns the TokenReviewStatus of the principal of the certificate.
+func (c *certificateAuthenticator) authenticate(ctx context.Context, token string) authn.TokenReviewStatus {
+	cert, err := c.verify(token)
+	if err != nil {
+		return authn.TokenReviewStatus{Error: err.Error()}
+	}
+
+	domain, service, err := certificatePrincipal(cert)
+	if err != nil {
+		return authn.TokenReviewStatus{Error: err.Error()}
+	}
+	u, err := c.mapper.MapUser(ctx, domain, service)
+	if err != nil {
+		return authn.TokenReviewStatus{Error: err.Error()}
+	}
+	if u.Extra == nil {
+		u.Extra = make(map[string]authn.ExtraValue, 1)
+	}
+	u.Extra[extraCertificateSerial] = authn.ExtraValue{cert.SerialNumber.String()}
+
+	return authn.TokenReviewStatus{
+		Authenticated: true,
+		User:          u,
+	}
+}
+
+// verify checks the certificate chain in the "x5c" header against the Athenz root CA, the signature with the certificate,
+// and the expiry, lifetime and audience of the assertion. It returns the verified certificate.
+func (c *certificateAuthenticator) verify(token string) (*x509.Certificate, error) {
+	var cert *x509.Certificate
+	claims := new(jwt.RegisteredClaims)
+	_, err := c.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		chain, err := parseCertificateChain(t.Header["x5c"])
+		if err != nil {
+			return nil, err
+		}
+		intermediates := x509.NewCertPool()
+		for _, ic := range chain[1:] {
+			intermediates.AddCert(ic)
+		}
+		_, err = chain[0].Verify(x509.VerifyOptions{
+			Roots:         c.roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "certificate verification failed")
+		}
+		cert = chain[0]
+		return cert.PublicKey, nil
+	})
+	if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&jwt.ValidationErrorExpired != 0 {
+		return nil, errors.New("certificate assertion is expired")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "certificate assertion verification failed")
+	}
+	if claims.ExpiresAt == nil || claims.IssuedAt == nil {
+		return nil, errors.New("certificate assertion has no expiry or issued time")
+	}
+	if lifetime := claims.ExpiresAt.Sub(claims.IssuedAt.Time); lifetime > c.maxLifetime {
+		return nil, errors.Errorf("certificate assertion lifetime %s exceeds %s", lifetime, c.maxLifetime)
+	}
+	if !claims.VerifyAudience(c.audience, true) {
+		return nil, errors.Errorf("unexpected certificate assertion audience %q", []string(claims.Audience))
+	}
+	return cert, nil
+}
+
+// parseCertificateChain parses the "x5c" header, the base64 DER certificates from the leaf to the intermediates.
+func parseCertificateChain(x5c interface{}) ([]*x509.Certificate, error) {
+	list, ok := x5c.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, errors.New("no certificate in x5c header")
+	}
+	chain := make([]*x509.Certificate, 0, len(list))
+	for i, v := range list {
+		s, _ := v.(string)
+		der, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, errors.Errorf("invalid certificate x5c[%d]", i)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid certificate x5c[%d]", i)
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
+
+// certificatePrincipal returns the Athenz domain and service of the certificate.
+// The SPIFFE URI SAN "spiffe://${domain}/sa/${service}" is preferred, otherwise the CN "${domain}.${service}" is used.
+// Role certificates, i.e. the SPIFFE URI SAN "spiffe://${domain}/ra/${role}" or the CN "${domain}:role.${role}", are rejected.
+func certificatePrincipal(cert *x509.Certificate) (string, string, error) {
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" && strings.HasPrefix(u.Path, "/ra/") {
+			return "", "", errors.Errorf("role certificate %q is not a principal", u.String())
+		}
+	}
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" && strings.HasPrefix(u.Path, "/sa/") {
+			service := strings.TrimPrefix(u.Path, "/sa/")
+			if u.Host != "" && service != "" && !strings.Contains(service, "/") {
+				return u.Host, service, nil
+			}
+		}
+	}
+	cn := cert.Subject.CommonName
+	i := strings.LastIndex(cn, ".")
+	if i <= 0 || i == len(cn)-1 || strings.Contains(cn, ":") {
+		return "", "", errors.Errorf("invalid certificate principal %q", cn)
+	}
+	return cn[:i], cn[i+1:], nil
+}
+
+// isCertificateAssertion returns true if the token is a JWT with the "x5c" header.
+func isCertificateAssertion(token string) bool {
+	if !isJWT(token) {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(token[:strings.Index(token, ".")], "="))
+	if err != nil {
+		return false
+	}
+	var header struct {
+		X5C []string `json:"x5c"`
+	}
+	return json.Unmarshal(b, &header) == nil && len(header.X5C) != 0
+}
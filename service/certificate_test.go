@@ -0,0 +1,427 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
+	authn "k8s.io/api/authentication/v1beta1"
+)
+
+// certificateTestCA is a CA issuing the test certificates.
+type certificateTestCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// certificateTestLeaf is a test certificate and its private key.
+type certificateTestLeaf struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCertificateTestCA(t *testing.T, cn string) certificateTestCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificateTestCA{cert: cert, key: key}
+}
+
+// issue returns the client certificate of cn and the SPIFFE URI SAN issued by the CA.
+func (ca certificateTestCA) issue(t *testing.T, serial int64, cn, spiffe string, usage x509.ExtKeyUsage) certificateTestLeaf {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if spiffe != "" {
+		u, err := url.Parse(spiffe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.URIs = []*url.URL{u}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificateTestLeaf{cert: cert, key: key}
+}
+
+// assertion returns the assertion of the claims signed with the certificate, the certificate is in the "x5c" header.
+func (l certificateTestLeaf) assertion(t *testing.T, claims jwt.Claims) string {
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	tok.Header["x5c"] = []string{base64.StdEncoding.EncodeToString(l.cert.Raw)}
+	s, err := tok.SignedString(l.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testAssertionClaims(aud string, lifetime time.Duration) *jwt.RegisteredClaims {
+	now := time.Now()
+	return &jwt.RegisteredClaims{
+		Audience:  jwt.ClaimStrings{aud},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+	}
+}
+
+func writeTestCA(t *testing.T, dir string, ca certificateTestCA) string {
+	path := filepath.Join(dir, "ca.pem")
+	err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewCertificateAuthenticator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caPath := writeTestCA(t, dir, newCertificateTestCA(t, "Athenz Test CA"))
+	emptyPath := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(emptyPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     config.Athenz
+		wantErr error
+	}{
+		{
+			name: "Check newCertificateAuthenticator fail with invalid max lifetime",
+			cfg: config.Athenz{
+				Certificate: config.Certificate{
+					MaxLifetime: "dummy",
+				},
+			},
+			wantErr: fmt.Errorf(`invalid certificate max lifetime dummy: time: invalid duration "dummy"`),
+		},
+		{
+			name: "Check newCertificateAuthenticator fail with missing root CA",
+			cfg: config.Athenz{
+				AthenzRootCA: "./testdata/notexists.pem",
+				Certificate: config.Certificate{
+					MaxLifetime: "10m",
+				},
+			},
+			wantErr: fmt.Errorf("certificate x509 certpool error: failed to read pem file: open ./testdata/notexists.pem: no such file or directory"),
+		},
+		{
+			name: "Check newCertificateAuthenticator fail with empty root CA",
+			cfg: config.Athenz{
+				AthenzRootCA: emptyPath,
+				Certificate: config.Certificate{
+					MaxLifetime: "10m",
+				},
+			},
+			wantErr: fmt.Errorf("certificate x509 certpool error: Certification Failed"),
+		},
+		{
+			name: "Check newCertificateAuthenticator success",
+			cfg: config.Athenz{
+				AthenzRootCA: caPath,
+				Certificate: config.Certificate{
+					Audience:    "https://garm.example.com",
+					MaxLifetime: "10m",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newCertificateAuthenticator(nil, tt.cfg, func(string) webhook.Logger {
+				return dummyLogger("")
+			})
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("newCertificateAuthenticator() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("newCertificateAuthenticator() unexpected error: %v", err)
+				return
+			}
+			c := got.(*certificateAuthenticator)
+			if c.audience != tt.cfg.Certificate.Audience || c.maxLifetime != time.Minute*10 {
+				t.Errorf("newCertificateAuthenticator() = %+v, want audience %v and max lifetime 10m", c, tt.cfg.Certificate.Audience)
+			}
+		})
+	}
+}
+
+func Test_certificateAuthenticator_ServeHTTP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newCertificateTestCA(t, "Athenz Test CA")
+	other := newCertificateTestCA(t, "Other Test CA")
+	const aud = "https://garm.example.com"
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("next"))
+	})
+	h, err := newCertificateAuthenticator(next, config.Athenz{
+		AthenzRootCA: writeTestCA(t, dir, ca),
+		Certificate: config.Certificate{
+			Audience:    aud,
+			MaxLifetime: "10m",
+		},
+		AuthN: webhook.AuthenticationConfig{
+			Mapper: NewUserMapper(&resolve{}),
+		},
+	}, func(string) webhook.Logger {
+		return dummyLogger("")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := func(token string) string {
+		return `{"apiVersion":"authentication.k8s.io/v1beta1","kind":"TokenReview","spec":{"token":"` + token + `"}}`
+	}
+	leaf := ca.issue(t, 10, "k8s.ci", "", x509.ExtKeyUsageClientAuth)
+
+	tests := []struct {
+		name   string
+		body   string
+		want   string
+		status authn.TokenReviewStatus
+	}{
+		{
+			name: "Check assertion of CN is authenticated",
+			body: review(leaf.assertion(t, testAssertionClaims(aud, time.Minute))),
+			status: authn.TokenReviewStatus{
+				Authenticated: true,
+				User: authn.UserInfo{
+					Username: "k8s.ci",
+					UID:      "k8s.ci",
+					Extra: map[string]authn.ExtraValue{
+						"athenz.io/certificate-serial": {"10"},
+					},
+				},
+			},
+		},
+		{
+			name: "Check assertion of SPIFFE URI is authenticated",
+			body: review(ca.issue(t, 11, "ignored", "spiffe://k8s.prod/sa/deployer", x509.ExtKeyUsageClientAuth).assertion(t, testAssertionClaims(aud, time.Minute))),
+			status: authn.TokenReviewStatus{
+				Authenticated: true,
+				User: authn.UserInfo{
+					Username: "k8s.prod.deployer",
+					UID:      "k8s.prod.deployer",
+					Extra: map[string]authn.ExtraValue{
+						"athenz.io/certificate-serial": {"11"},
+					},
+				},
+			},
+		},
+		{
+			name: "Check assertion of untrusted CA is denied",
+			body: review(other.issue(t, 12, "k8s.ci", "", x509.ExtKeyUsageClientAuth).assertion(t, testAssertionClaims(aud, time.Minute))),
+			status: authn.TokenReviewStatus{
+				Error: "certificate assertion verification failed: certificate verification failed: x509: certificate signed by unknown authority",
+			},
+		},
+		{
+			name: "Check assertion of server certificate is denied",
+			body: review(ca.issue(t, 13, "k8s.ci", "", x509.ExtKeyUsageServerAuth).assertion(t, testAssertionClaims(aud, time.Minute))),
+			status: authn.TokenReviewStatus{
+				Error: "certificate assertion verification failed: certificate verification failed: x509: certificate specifies an incompatible key usage",
+			},
+		},
+		{
+			name: "Check expired assertion is denied",
+			body: review(leaf.assertion(t, &jwt.RegisteredClaims{
+				Audience:  jwt.ClaimStrings{aud},
+				IssuedAt:  jwt.NewNumericDate(time.Now().Add(-time.Minute * 2)),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+			})),
+			status: authn.TokenReviewStatus{
+				Error: "certificate assertion is expired",
+			},
+		},
+		{
+			name: "Check assertion of long lifetime is denied",
+			body: review(leaf.assertion(t, testAssertionClaims(aud, time.Hour))),
+			status: authn.TokenReviewStatus{
+				Error: "certificate assertion lifetime 1h0m0s exceeds 10m0s",
+			},
+		},
+		{
+			name: "Check assertion without issued time is denied",
+			body: review(leaf.assertion(t, &jwt.RegisteredClaims{
+				Audience:  jwt.ClaimStrings{aud},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			})),
+			status: authn.TokenReviewStatus{
+				Error: "certificate assertion has no expiry or issued time",
+			},
+		},
+		{
+			name: "Check assertion of unexpected audience is denied",
+			body: review(leaf.assertion(t, testAssertionClaims("https://other.example.com", time.Minute))),
+			status: authn.TokenReviewStatus{
+				Error: `unexpected certificate assertion audience ["https://other.example.com"]`,
+			},
+		},
+		{
+			name: "Check assertion of invalid principal is denied",
+			body: review(ca.issue(t, 14, "ci", "", x509.ExtKeyUsageClientAuth).assertion(t, testAssertionClaims(aud, time.Minute))),
+			status: authn.TokenReviewStatus{
+				Error: `invalid certificate principal "ci"`,
+			},
+		},
+		{
+			name: "Check assertion of role certificate CN is denied",
+			body: review(ca.issue(t, 15, "k8s:role.admin", "", x509.ExtKeyUsageClientAuth).assertion(t, testAssertionClaims(aud, time.Minute))),
+			status: authn.TokenReviewStatus{
+				Error: `invalid certificate principal "k8s:role.admin"`,
+			},
+		},
+		{
+			name: "Check assertion of role certificate SPIFFE URI is denied",
+			body: review(ca.issue(t, 16, "k8s.ci", "spiffe://k8s/ra/admin", x509.ExtKeyUsageClientAuth).assertion(t, testAssertionClaims(aud, time.Minute))),
+			status: authn.TokenReviewStatus{
+				Error: `role certificate "spiffe://k8s/ra/admin" is not a principal`,
+			},
+		},
+		{
+			name: "Check JWT without x5c header is passed to next",
+			body: review("eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJ1c2VyLmFsaWNlIn0.c2ln"),
+			want: "next",
+		},
+		{
+			name: "Check n-token is passed to next",
+			body: review("v=S1;d=k8s;n=ci;s=a.b.c"),
+			want: "next",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/authn", bytes.NewBufferString(tt.body)))
+			if tt.want != "" {
+				if got := w.Body.String(); got != tt.want {
+					t.Errorf("ServeHTTP() body = %v, want %v", got, tt.want)
+				}
+				return
+			}
+			var got struct {
+				Status authn.TokenReviewStatus `json:"status"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Status, tt.status) {
+				t.Errorf("ServeHTTP() status = %+v, want %+v", got.Status, tt.status)
+			}
+		})
+	}
+}
+
+func Test_parseCertificateChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		x5c     interface{}
+		wantErr error
+	}{
+		{
+			name:    "Check missing x5c header",
+			x5c:     nil,
+			wantErr: fmt.Errorf("no certificate in x5c header"),
+		},
+		{
+			name:    "Check invalid base64",
+			x5c:     []interface{}{"!"},
+			wantErr: fmt.Errorf("invalid certificate x5c[0]"),
+		},
+		{
+			name:    "Check invalid certificate",
+			x5c:     []interface{}{"AAAA"},
+			wantErr: fmt.Errorf("invalid certificate x5c[0]: x509: malformed certificate"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseCertificateChain(tt.x5c)
+			if err == nil || err.Error() != tt.wantErr.Error() {
+				t.Errorf("parseCertificateChain() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
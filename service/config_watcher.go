@@ -0,0 +1,146 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"reflect"
+	"time"
+
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/garm/config"
+)
+
+// ConfigWatcher represents an interface to watch the configuration file, and reload the mapping rules on change.
+type ConfigWatcher interface {
+	StartConfigWatcher(context.Context) ConfigWatcher
+}
+
+type configWatcher struct {
+	// cfg is the last successfully applied configuration.
+	cfg config.Config
+	// checksum is the checksum of the last checked configuration file content.
+	checksum [sha256.Size]byte
+	// interval is the duration between each configuration file check.
+	interval time.Duration
+	// mapper receives the reloaded mapping rules.
+	mapper Mapper
+}
+
+// NewConfigWatcher returns a ConfigWatcher watching cfg.FilePath.
+// The given cfg should be the configuration currently in use, and the reloaded mapping rules will be applied to m.
+func NewConfigWatcher(cfg config.Config, m Mapper) (ConfigWatcher, error) {
+	dur, err := time.ParseDuration(cfg.Reload.Interval)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid reload interval %s", cfg.Reload.Interval)
+	}
+
+	b, err := ioutil.ReadFile(cfg.FilePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "config read failed")
+	}
+
+	return &configWatcher{
+		cfg:      cfg,
+		checksum: sha256.Sum256(b),
+		interval: dur,
+		mapper:   m,
+	}, nil
+}
+
+// StartConfigWatcher returns a ConfigWatcher.
+// It starts a go routine to check the configuration file periodically.
+func (w *configWatcher) StartConfigWatcher(ctx context.Context) ConfigWatcher {
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		for {
+			select {
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			case <-ticker.C:
+				err := w.check()
+				if err != nil {
+					err = glg.Error(errors.Wrap(err, "config reload failed, keep using the last valid config"))
+					if err != nil {
+						glg.Fatal(err)
+					}
+				}
+			}
+		}
+	}()
+	return w
+}
+
+// check reads the configuration file, and reloads the mapping rules if the content is changed.
+// The file is read through its path on every check, hence, K8s ConfigMap updates (symlink swap) are also detected.
+// An invalid configuration is rejected and reported once, the last valid configuration stays active.
+func (w *configWatcher) check() error {
+	b, err := ioutil.ReadFile(w.cfg.FilePath)
+	if err != nil {
+		return errors.Wrap(err, "config read failed")
+	}
+
+	sum := sha256.Sum256(b)
+	if sum == w.checksum {
+		return nil
+	}
+	w.checksum = sum
+
+	cfg, err := config.Parse(b)
+	if err != nil {
+		return err
+	}
+	cfg.FilePath = w.cfg.FilePath
+
+	err = cfg.Validate()
+	if err != nil {
+		return err
+	}
+
+	if !isSameExceptMapping(w.cfg, *cfg) {
+		err = glg.Warn("config changes other than map_rule require restart, only map_rule is reloaded")
+		if err != nil {
+			return errors.Wrap(err, "config reload warning output failed")
+		}
+	}
+
+	err = w.mapper.Reload(cfg.Mapping)
+	if err != nil {
+		return err
+	}
+	w.cfg = *cfg
+
+	for _, warning := range cfg.Mapping.Warnings() {
+		err = glg.Warn(warning)
+		if err != nil {
+			return errors.Wrap(err, "mapping warning output failed")
+		}
+	}
+
+	return glg.Infof("config reloaded from %s", w.cfg.FilePath)
+}
+
+// isSameExceptMapping returns true if both configurations are the same after ignoring "map_rule".
+func isSameExceptMapping(a, b config.Config) bool {
+	a.Mapping = config.Mapping{}
+	b.Mapping = config.Mapping{}
+	return reflect.DeepEqual(a, b)
+}
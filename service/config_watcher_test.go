@@ -0,0 +1,279 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kpango/glg"
+	"github.com/yahoojapan/garm/config"
+)
+
+const (
+	watcherTestConfig = `version: v2.0.0
+server:
+  port: 443
+  health_check_port: 8080
+  timeout: 1s
+  shutdown_duration: 1s
+  probe_wait_time: 1s
+athenz:
+  timeout: 1s
+token:
+  refresh_duration: 1s
+  expiration: 1s
+map_rule:
+  tld:
+    platform:
+      service_athenz_domains:
+        - %s._namespace_
+`
+)
+
+func TestNewConfigWatcher(t *testing.T) {
+	type args struct {
+		cfg config.Config
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr error
+	}{
+		{
+			name: "Check NewConfigWatcher fail with invalid interval",
+			args: args{
+				cfg: config.Config{
+					Reload: config.Reload{
+						Interval: "dummy",
+					},
+				},
+			},
+			wantErr: fmt.Errorf(`invalid reload interval dummy: time: invalid duration "dummy"`),
+		},
+		{
+			name: "Check NewConfigWatcher fail with missing file",
+			args: args{
+				cfg: config.Config{
+					Reload: config.Reload{
+						Interval: "1s",
+					},
+					FilePath: "./testdata/notexists.yaml",
+				},
+			},
+			wantErr: fmt.Errorf("config read failed: open ./testdata/notexists.yaml: no such file or directory"),
+		},
+		{
+			name: "Check NewConfigWatcher success",
+			args: args{
+				cfg: config.Config{
+					Reload: config.Reload{
+						Interval: "1s",
+					},
+					FilePath: "./testdata/dummyToken",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMapper(config.Mapping{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := NewConfigWatcher(tt.args.cfg, m)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("NewConfigWatcher() unexpected error: %v", err)
+				return
+			}
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("NewConfigWatcher() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if got == nil {
+				t.Error("NewConfigWatcher() returns nil")
+			}
+		})
+	}
+}
+
+func Test_configWatcher_StartConfigWatcher(t *testing.T) {
+	glg.Get().SetLevelMode(glg.ERR, glg.NONE)
+	dir, err := ioutil.TempDir("", "garm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	domainOf := func(m Mapper) string {
+		return m.(*mapper).load().resource.(*resourceMapper).res.BuildDomainsFromNamespace("")[0]
+	}
+
+	write(fmt.Sprintf(watcherTestConfig, "before"))
+	cfg, err := config.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Reload.Interval = "10ms"
+
+	m, err := NewMapper(cfg.Mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewConfigWatcher(*cfg, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w.StartConfigWatcher(ctx)
+
+	// valid config is applied
+	write(fmt.Sprintf(watcherTestConfig, "after"))
+	time.Sleep(time.Millisecond * 100)
+	if got := domainOf(m); got != "after" {
+		t.Errorf("reloaded domain = %v, want %v", got, "after")
+	}
+
+	// invalid config is rejected, last valid config is kept
+	write("version: v0.0.0\n")
+	time.Sleep(time.Millisecond * 100)
+	if got := domainOf(m); got != "after" {
+		t.Errorf("domain after invalid reload = %v, want %v", got, "after")
+	}
+
+	// context canceled, no more reload
+	cancel()
+	time.Sleep(time.Millisecond * 50)
+	write(fmt.Sprintf(watcherTestConfig, "canceled"))
+	time.Sleep(time.Millisecond * 100)
+	if got := domainOf(m); got != "after" {
+		t.Errorf("domain after cancel = %v, want %v", got, "after")
+	}
+}
+
+func Test_configWatcher_check(t *testing.T) {
+	warnings := new(bytes.Buffer)
+	glg.Get().SetLevelWriter(glg.WARN, warnings).SetLevelMode(glg.WARN, glg.WRITER)
+	defer glg.Get().SetLevelMode(glg.WARN, glg.NONE)
+	glg.Get().SetLevelMode(glg.INFO, glg.NONE)
+	dir, err := ioutil.TempDir("", "garm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+
+	tests := []struct {
+		name     string
+		content  string
+		wantErr  error
+		wantWarn string
+	}{
+		{
+			name:    "Check unchanged content",
+			content: fmt.Sprintf(watcherTestConfig, "before"),
+		},
+		{
+			name:    "Check invalid yaml",
+			content: "version: [",
+			wantErr: fmt.Errorf("yaml parse failed: yaml: line 1: did not find expected node content"),
+		},
+		{
+			name:    "Check invalid config",
+			content: strings.Replace(fmt.Sprintf(watcherTestConfig, "after"), "v2.0.0", "v0.0.0", 1),
+			wantErr: fmt.Errorf("invalid config:\n\tversion: unsupported version \"v0.0.0\", want \"v2.0.0\""),
+		},
+		{
+			name:    "Check invalid pattern",
+			content: fmt.Sprintf(watcherTestConfig, "after") + "      black_list:\n        - verb: \"regex:(\"\n",
+			wantErr: fmt.Errorf("invalid config:\n\tmap_rule.tld.platform.black_list[0].verb: invalid pattern: error parsing regexp: missing closing ): `^(?:()$`"),
+		},
+		{
+			name:    "Check unknown platform",
+			content: fmt.Sprintf(watcherTestConfig, "after") + "    platform:\n      name: unknown\n",
+			wantErr: fmt.Errorf(`resolver instantiate failed: unknown platform "unknown", registered platforms: aks, eks, k8s`),
+		},
+		{
+			name:    "Check valid content",
+			content: fmt.Sprintf(watcherTestConfig, "after"),
+		},
+		{
+			name:     "Check warnings of the reloaded mapping rules",
+			content:  fmt.Sprintf(watcherTestConfig, "after") + "      white_list:\n        - verb: '*'\n          api_group: '*'\n          resource: '*'\n          name: '*'\n",
+			wantWarn: "map_rule.tld.platform.white_list[0].namespace: omitted field matches any value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(path, []byte(fmt.Sprintf(watcherTestConfig, "before")), 0600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := config.New(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Reload.Interval = "1s"
+			m, err := NewMapper(cfg.Mapping)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := NewConfigWatcher(*cfg, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			warnings.Reset()
+			err = w.(*configWatcher).check()
+			if got := warnings.String(); !strings.Contains(got, tt.wantWarn) {
+				t.Errorf("check() warnings = %v, want %v", got, tt.wantWarn)
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("check() unexpected error: %v", err)
+				return
+			}
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("check() error = %v, want %v", err, tt.wantErr)
+				}
+				// the same content is reported only once
+				if err = w.(*configWatcher).check(); err != nil {
+					t.Errorf("check() second call error = %v, want nil", err)
+				}
+			}
+		})
+	}
+}
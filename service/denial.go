@@ -0,0 +1,100 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/yahoojapan/garm/config"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+// denial replaces the reason of the rejected SubjectAccessReview responses.
+type denial struct {
+	// message is the template of the reason.
+	message string
+	// help is appended to the reason.
+	help string
+}
+
+// newDenial returns a denial of the given configuration, or nil if the reason should not be replaced.
+func newDenial(cfg config.Denial) *denial {
+	if cfg.Message == "" && cfg.HelpMessage == "" {
+		return nil
+	}
+	return &denial{
+		message: cfg.GetMessage(),
+		help:    cfg.HelpMessage,
+	}
+}
+
+// rewrite replaces the reason of the SubjectAccessReview response buffered in rec, if the request is rejected by Garm directly or denied by Athenz.
+// Other responses, e.g. the internal errors, are kept as they are.
+func (d *denial) rewrite(rec *responseRecorder, t *trace) {
+	var sar struct {
+		APIVersion string                          `json:"apiVersion"`
+		Kind       string                          `json:"kind"`
+		Status     authz.SubjectAccessReviewStatus `json:"status"`
+	}
+	if rec.status != http.StatusOK || json.Unmarshal(rec.body.Bytes(), &sar) != nil {
+		return
+	}
+
+	var reason string
+	switch {
+	case sar.Status.Allowed:
+		return
+	case t.action == config.ActionDeny:
+		reason = "rejected by " + strings.Join(t.rules, ", ")
+	case isAthenzDenial(sar.Status, t.identity, t.checks):
+		reason = "denied by Athenz"
+	default:
+		return
+	}
+
+	sar.Status.Reason = d.reason(t, reason)
+	b, err := json.Marshal(sar)
+	if err != nil {
+		return
+	}
+	rec.body.Reset()
+	rec.body.Write(b)
+}
+
+// reason returns the reason by replacing the placeholders in the template with the request attributes in t, and appends the help message.
+// For K8s non-resource requests, "_resource_" is replaced with the path.
+func (d *denial) reason(t *trace, reason string) string {
+	var verb, namespace, group, resource, sub, name string
+	if ra := t.spec.ResourceAttributes; ra != nil {
+		verb, namespace, group, resource, sub, name = ra.Verb, ra.Namespace, ra.Group, ra.Resource, ra.Subresource, ra.Name
+	} else if nra := t.spec.NonResourceAttributes; nra != nil {
+		verb, resource = nra.Verb, nra.Path
+	}
+	return strings.NewReplacer(
+		"_reason_", reason,
+		"_user_", t.spec.User,
+		"_principal_", t.identity,
+		"_verb_", verb,
+		"_namespace_", namespace,
+		"_api_group_", group,
+		"_resource_", resource,
+		"_subresource_", sub,
+		"_name_", name,
+	).Replace(d.message) + d.help
+}
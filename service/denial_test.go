@@ -0,0 +1,215 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+func Test_newDenial(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Denial
+		want *denial
+	}{
+		{
+			name: "Check newDenial, not configured",
+			want: nil,
+		},
+		{
+			name: "Check newDenial, help message only",
+			cfg: config.Denial{
+				HelpMessage: " See https://access.example.com",
+			},
+			want: &denial{
+				message: "_reason_",
+				help:    " See https://access.example.com",
+			},
+		},
+		{
+			name: "Check newDenial, message",
+			cfg: config.Denial{
+				Message: "_principal_: _reason_",
+			},
+			want: &denial{
+				message: "_principal_: _reason_",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newDenial(tt.cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newDenial() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_denial_rewrite(t *testing.T) {
+	d := &denial{
+		message: "_principal_ cannot _verb_ _resource_/_subresource_ in _namespace_: _reason_.",
+		help:    " See https://access.example.com",
+	}
+	spec := authz.SubjectAccessReviewSpec{
+		ResourceAttributes: &authz.ResourceAttributes{
+			Namespace:   "ns",
+			Verb:        "create",
+			Resource:    "pods",
+			Subresource: "exec",
+		},
+		User: "alice",
+	}
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		trace  *trace
+		want   string
+	}{
+		{
+			name:   "Check rewrite, rejected by Garm",
+			status: http.StatusOK,
+			body:   `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","status":{"allowed":false,"evaluationError":"mapping error"}}`,
+			trace: &trace{
+				mapping: mapping{
+					spec:     spec,
+					identity: "user.alice",
+					action:   config.ActionDeny,
+					rules:    []string{"rules[1]"},
+				},
+			},
+			want: `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","status":{"allowed":false,"reason":"user.alice cannot create pods/exec in ns: rejected by rules[1]. See https://access.example.com","evaluationError":"mapping error"}}`,
+		},
+		{
+			name:   "Check rewrite, denied by Athenz",
+			status: http.StatusOK,
+			body:   `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","status":{"allowed":false,"evaluationError":"principal user.alice does not have access to any of 'create on k8s.ns:pods.exec' resources"}}`,
+			trace: &trace{
+				mapping: mapping{
+					spec:     spec,
+					identity: "user.alice",
+					action:   config.ActionDefaultDomain,
+					checks: []webhook.AthenzAccessCheck{
+						{
+							Action:   "create",
+							Resource: "k8s.ns:pods.exec",
+						},
+					},
+				},
+			},
+			want: `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","status":{"allowed":false,"reason":"user.alice cannot create pods/exec in ns: denied by Athenz. See https://access.example.com","evaluationError":"principal user.alice does not have access to any of 'create on k8s.ns:pods.exec' resources"}}`,
+		},
+		{
+			name:   "Check rewrite, allowed request is kept",
+			status: http.StatusOK,
+			body:   `{"status":{"allowed":true}}`,
+			trace:  &trace{},
+			want:   `{"status":{"allowed":true}}`,
+		},
+		{
+			name:   "Check rewrite, internal error is kept",
+			status: http.StatusOK,
+			body:   `{"status":{"allowed":false,"reason":"internal setup error. See https://access.example.com","evaluationError":"401 Unauthorized"}}`,
+			trace:  &trace{},
+			want:   `{"status":{"allowed":false,"reason":"internal setup error. See https://access.example.com","evaluationError":"401 Unauthorized"}}`,
+		},
+		{
+			name:   "Check rewrite, HTTP error is kept",
+			status: http.StatusBadRequest,
+			body:   "bad request",
+			trace:  &trace{},
+			want:   "bad request",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &responseRecorder{
+				ResponseWriter: httptest.NewRecorder(),
+				status:         tt.status,
+				buffered:       true,
+			}
+			rec.body.WriteString(tt.body)
+			d.rewrite(rec, tt.trace)
+			if got := rec.body.String(); got != tt.want {
+				t.Errorf("rewrite() body = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_denial_reason(t *testing.T) {
+	d := &denial{
+		message: "_user_ (_principal_) cannot _verb_ _resource_ _name_ of _api_group_: _reason_",
+	}
+	tests := []struct {
+		name   string
+		trace  *trace
+		reason string
+		want   string
+	}{
+		{
+			name: "Check reason, resource request",
+			trace: &trace{
+				mapping: mapping{
+					spec: authz.SubjectAccessReviewSpec{
+						ResourceAttributes: &authz.ResourceAttributes{
+							Verb:     "delete",
+							Group:    "apps",
+							Resource: "deployments",
+							Name:     "web",
+						},
+						User: "alice",
+					},
+					identity: "user.alice",
+				},
+			},
+			reason: "rejected by black_list",
+			want:   "alice (user.alice) cannot delete deployments web of apps: rejected by black_list",
+		},
+		{
+			name: "Check reason, non-resource request",
+			trace: &trace{
+				mapping: mapping{
+					spec: authz.SubjectAccessReviewSpec{
+						NonResourceAttributes: &authz.NonResourceAttributes{
+							Verb: "get",
+							Path: "/metrics",
+						},
+						User: "alice",
+					},
+					identity: "user.alice",
+				},
+			},
+			reason: "denied by Athenz",
+			want:   "alice (user.alice) cannot get /metrics  of : denied by Athenz",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.reason(tt.trace, tt.reason); got != tt.want {
+				t.Errorf("reason() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
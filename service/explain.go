@@ -0,0 +1,104 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+// Explanation represents how a K8s authorization request is mapped to Athenz access checks.
+type Explanation struct {
+	// Identity represents the Athenz principal mapped from the K8s user.
+	Identity string
+	// Verb represents the mapped K8s verb used for list matching.
+	Verb string
+	// Namespace represents the mapped K8s namespace used for list matching.
+	Namespace string
+	// APIGroup represents the mapped K8s API group used for list matching.
+	APIGroup string
+	// Resource represents the mapped K8s resource used for list matching.
+	Resource string
+	// Name represents the mapped K8s resource name used for list matching.
+	Name string
+	// WhiteListed represents the request matches white_list.
+	WhiteListed bool
+	// BlackListed represents the request matches black_list.
+	BlackListed bool
+	// AdminAccess represents the request matches admin_access_list.
+	AdminAccess bool
+	// Action represents the action applied to the request, e.g. config.ActionDeny.
+	Action string
+	// Rules represents the names of the matched rules, e.g. "rules[0]", "default_action" or "white_list".
+	Rules []string
+	// AccessChecks represents the Athenz access checks. Any one of them granted allows the request.
+	AccessChecks []webhook.AthenzAccessCheck
+	// Err represents the mapping error, e.g. the request is rejected by black_list.
+	Err error
+}
+
+// Explain maps spec with the given mapping rules, and returns the details of each mapping step.
+// It does not send any request to Athenz. It returns an error if the Resolver cannot be created from cfg.
+func Explain(ctx context.Context, cfg config.Mapping, spec authz.SubjectAccessReviewSpec) (*Explanation, error) {
+	res, err := NewResolver(cfg)
+	if err != nil {
+		return nil, err
+	}
+	m := &resourceMapper{
+		res: res,
+	}
+	a := m.mapAttributes(spec)
+	req := config.RequestInfo{
+		Verb:      a.verb,
+		Namespace: a.namespace,
+		APIGroup:  a.group,
+		Resource:  a.resource,
+		Name:      a.name,
+	}
+
+	e := &Explanation{
+		Identity:    m.res.PrincipalFromUser(a.user, a.groups),
+		Verb:        a.verb,
+		Namespace:   a.namespace,
+		APIGroup:    a.group,
+		Resource:    a.resource,
+		Name:        a.name,
+		WhiteListed: matchList(cfg.TLD.Platform.WhiteList, req, a.user, a.groups, cfg.TLD.Platform.LegacyListMatch),
+		BlackListed: matchList(cfg.TLD.Platform.BlackList, req, a.user, a.groups, cfg.TLD.Platform.LegacyListMatch),
+		AdminAccess: matchList(cfg.TLD.Platform.AdminAccessList, req, a.user, a.groups, cfg.TLD.Platform.LegacyListMatch),
+	}
+	ctx, t := withTrace(ctx)
+	_, e.AccessChecks, e.Err = m.MapResource(ctx, spec)
+	e.Action = t.action
+	e.Rules = t.rules
+	return e, nil
+}
+
+// ListDecision returns the request filtering result in human readable format.
+func (e *Explanation) ListDecision() string {
+	switch {
+	case e.WhiteListed:
+		return "allowed by white_list"
+	case e.BlackListed:
+		return "rejected by black_list"
+	default:
+		return "allowed, not in black_list"
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+func TestExplain(t *testing.T) {
+	allList := func(verb string) []*config.RequestInfo {
+		return []*config.RequestInfo{
+			{
+				Verb:      verb,
+				Namespace: "*",
+				APIGroup:  "*",
+				Resource:  "*",
+				Name:      "*",
+			},
+		}
+	}
+	type args struct {
+		cfg  config.Mapping
+		spec authz.SubjectAccessReviewSpec
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *Explanation
+		wantErr bool
+	}{
+		{
+			name: "Check Explain, admin access",
+			args: args{
+				cfg: config.Mapping{
+					TLD: config.TLD{
+						Platform: config.Platform{
+							ServiceAthenzDomains: []string{"k8s._namespace_"},
+							AdminAthenzDomain:    "k8s.admin",
+							WhiteList:            allList("get"),
+							BlackList:            allList("get"),
+							AdminAccessList:      allList("get"),
+						},
+					},
+				},
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace: "ns",
+						Verb:      "get",
+						Resource:  "pods",
+					},
+					User: "user.name",
+				},
+			},
+			want: &Explanation{
+				Identity:    "user.name",
+				Verb:        "get",
+				Namespace:   "ns",
+				Resource:    "pods",
+				WhiteListed: true,
+				BlackListed: true,
+				AdminAccess: true,
+				Action:      config.ActionAdminDomain,
+				Rules:       []string{ruleWhiteList, ruleAdminAccessList},
+				AccessChecks: []webhook.AthenzAccessCheck{
+					{
+						Resource: "k8s.admin:k8s.ns.pods",
+						Action:   "get",
+					},
+					{
+						Resource: "k8s.admin:pods",
+						Action:   "get",
+					},
+				},
+			},
+		},
+		{
+			name: "Check Explain, rejected by black list",
+			args: args{
+				cfg: config.Mapping{
+					TLD: config.TLD{
+						Platform: config.Platform{
+							ServiceAthenzDomains: []string{"k8s._namespace_"},
+							BlackList:            allList("delete"),
+						},
+					},
+				},
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace: "ns",
+						Verb:      "delete",
+						Resource:  "pods",
+					},
+					User: "user.name",
+				},
+			},
+			want: &Explanation{
+				Identity:    "user.name",
+				Verb:        "delete",
+				Namespace:   "ns",
+				Resource:    "pods",
+				BlackListed: true,
+				Action:      config.ActionDeny,
+				Rules:       []string{ruleBlackList},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Check Explain, custom domain by rules",
+			args: args{
+				cfg: config.Mapping{
+					TLD: config.TLD{
+						Platform: config.Platform{
+							ServiceAthenzDomains: []string{"k8s._namespace_"},
+							Rules: []*config.Rule{
+								{
+									RequestInfo: config.RequestInfo{
+										Verb:      "*",
+										Namespace: "*",
+										Resource:  "pods",
+									},
+									Subresource: "exec",
+									Action:      config.ActionCustomDomain,
+									Domains:     []string{"k8s.shell._namespace_"},
+								},
+							},
+							DefaultAction: config.ActionDeny,
+						},
+					},
+				},
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace:   "ns",
+						Verb:        "create",
+						Resource:    "pods",
+						Subresource: "exec",
+					},
+					User: "user.name",
+				},
+			},
+			want: &Explanation{
+				Identity:  "user.name",
+				Verb:      "create",
+				Namespace: "ns",
+				Resource:  "pods.exec",
+				Action:    config.ActionCustomDomain,
+				Rules:     []string{"rules[0]"},
+				AccessChecks: []webhook.AthenzAccessCheck{
+					{
+						Resource: "k8s.shell.ns:pods.exec",
+						Action:   "create",
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Explain(context.Background(), tt.args.cfg, tt.args.spec)
+			if err != nil {
+				t.Errorf("Explain() unexpected error: %v", err)
+				return
+			}
+			if (got.Err != nil) != tt.wantErr {
+				t.Errorf("Explain() error = %v, wantErr %v", got.Err, tt.wantErr)
+				return
+			}
+			got.Err = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Explain() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExplanation_ListDecision(t *testing.T) {
+	tests := []struct {
+		name string
+		e    *Explanation
+		want string
+	}{
+		{
+			name: "Check ListDecision, white list wins",
+			e: &Explanation{
+				WhiteListed: true,
+				BlackListed: true,
+			},
+			want: "allowed by white_list",
+		},
+		{
+			name: "Check ListDecision, black list",
+			e: &Explanation{
+				BlackListed: true,
+			},
+			want: "rejected by black_list",
+		},
+		{
+			name: "Check ListDecision, not listed",
+			e:    &Explanation{},
+			want: "allowed, not in black_list",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.e.ListDecision(); got != tt.want {
+				t.Errorf("ListDecision() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
+	authn "k8s.io/api/authentication/v1beta1"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+// Mapper is both ResourceMapper and UserMapper, and its mapping rules can be replaced at runtime.
+// The mapping rules of each request are selected from the named profiles, see mappers.selectProfile.
+type Mapper interface {
+	ResourceMapper
+	UserMapper
+	// Reload replaces the mapping rules. Requests being mapped keep using the previous mapping rules.
+	// The mapping rules are not replaced if the new Resolver of any profile cannot be created.
+	Reload(config.Mapping) error
+}
+
+// mapper implements Mapper by delegating to the mappers stored in an atomic variable.
+type mapper struct {
+	// mappers stores the current *mappers.
+	mappers *atomic.Value
+}
+
+// mappers is the profileMappers of each profile created from the same mapping rules, and how the profile is selected.
+type mappers struct {
+	// profileMappers is the default profile "map_rule.tld".
+	*profileMappers
+	// profiles is the named profiles "map_rule.profiles".
+	profiles map[string]*profileMappers
+	// header is the HTTP request header selecting the profile.
+	header string
+	// extraKey is the key of the SubjectAccessReview extra selecting the profile.
+	extraKey string
+}
+
+// profileMappers is a pair of ResourceMapper and UserMapper created from the same Resolver.
+type profileMappers struct {
+	resource ResourceMapper
+	user     UserMapper
+}
+
+// NewMapper returns a Mapper using the given mapping rules.
+func NewMapper(cfg config.Mapping) (Mapper, error) {
+	m := &mapper{
+		mappers: new(atomic.Value),
+	}
+	err := m.Reload(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload creates a new Resolver of each profile from cfg, and atomically swaps the ResourceMappers and UserMappers with the new ones.
+func (m *mapper) Reload(cfg config.Mapping) error {
+	def, err := newProfileMappers(cfg.TLD)
+	if err != nil {
+		return errors.Wrap(err, "resolver instantiate failed")
+	}
+	profiles := make(map[string]*profileMappers, len(cfg.Profiles))
+	for name, tld := range cfg.Profiles {
+		profiles[name], err = newProfileMappers(tld)
+		if err != nil {
+			return errors.Wrapf(err, "resolver instantiate failed for profile %q", name)
+		}
+	}
+	m.mappers.Store(&mappers{
+		profileMappers: def,
+		profiles:       profiles,
+		header:         cfg.ProfileHeader,
+		extraKey:       cfg.ProfileExtraKey,
+	})
+	return nil
+}
+
+// newProfileMappers returns the ResourceMapper and UserMapper of the mapping rules of tld.
+func newProfileMappers(tld config.TLD) (*profileMappers, error) {
+	resolver, err := NewResolver(config.Mapping{TLD: tld})
+	if err != nil {
+		return nil, err
+	}
+	return &profileMappers{
+		resource: NewResourceMapper(resolver),
+		user:     NewUserMapper(resolver),
+	}, nil
+}
+
+// MapResource maps the request using the ResourceMapper of the selected profile.
+// The request is mapped only once, the result is recorded in the trace of ctx and returned to the later calls with the same trace,
+// hence, the decision cache and the Athenz authorizer handling the same request share the same principal and access checks.
+func (m *mapper) MapResource(ctx context.Context, spec authz.SubjectAccessReviewSpec) (string, []webhook.AthenzAccessCheck, error) {
+	t := traceFrom(ctx)
+	if t != nil && t.resource != nil {
+		return t.resource.principal, t.resource.checks, t.resource.err
+	}
+
+	principal, checks, err := m.mapResource(ctx, spec)
+	if t != nil {
+		t.resource = &resourceResult{
+			principal: principal,
+			checks:    checks,
+			err:       err,
+		}
+	}
+	return principal, checks, err
+}
+
+// mapResource maps the request using the ResourceMapper of the selected profile.
+func (m *mapper) mapResource(ctx context.Context, spec authz.SubjectAccessReviewSpec) (string, []webhook.AthenzAccessCheck, error) {
+	p, err := m.load().selectProfile(ctx, spec.Extra)
+	if err != nil {
+		return "", nil, err
+	}
+	return p.resource.MapResource(ctx, spec)
+}
+
+// MapUser maps the principal using the UserMapper of the selected profile.
+func (m *mapper) MapUser(ctx context.Context, domain, service string) (authn.UserInfo, error) {
+	p, err := m.load().selectProfile(ctx, nil)
+	if err != nil {
+		return authn.UserInfo{}, err
+	}
+	return p.user.MapUser(ctx, domain, service)
+}
+
+// load returns the current mappers.
+func (m *mapper) load() *mappers {
+	return m.mappers.Load().(*mappers)
+}
+
+// selectProfile returns the profileMappers of the profile selected by the request, in order of
+// 1. the profile name in the URL path, e.g. "/authz/${name}"
+// 2. the HTTP request header
+// 3. the SubjectAccessReview extra, only for authorization requests
+// If no profile is selected, the default profile is returned. The selected profile name is recorded in the trace of ctx.
+func (ms *mappers) selectProfile(ctx context.Context, extra map[string]authz.ExtraValue) (*profileMappers, error) {
+	t := traceFrom(ctx)
+	var name string
+	if t != nil {
+		name = t.request.name
+		if name == "" && ms.header != "" {
+			name = t.request.header.Get(ms.header)
+		}
+	}
+	if name == "" && ms.extraKey != "" && len(extra[ms.extraKey]) != 0 {
+		name = extra[ms.extraKey][0]
+	}
+	if name == "" {
+		return ms.profileMappers, nil
+	}
+
+	if t != nil {
+		t.profile = name
+	}
+	p, ok := ms.profiles[name]
+	if !ok {
+		return nil, errors.Errorf("unknown mapping profile %q", name)
+	}
+	return p, nil
+}
@@ -0,0 +1,440 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
+	authn "k8s.io/api/authentication/v1beta1"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+func TestNewMapper(t *testing.T) {
+	type args struct {
+		cfg config.Mapping
+	}
+	tests := []struct {
+		name      string
+		args      args
+		checkFunc func(Mapper) error
+		wantErr   error
+	}{
+		{
+			name: "Check NewMapper stores mappers created from the mapping rules",
+			args: args{
+				cfg: config.Mapping{
+					TLD: config.TLD{
+						Platform: config.Platform{
+							Name: "k8s",
+						},
+					},
+				},
+			},
+			checkFunc: func(got Mapper) error {
+				m := got.(*mapper).load()
+				want := &K8SResolve{resolve{
+					cfg: config.Platform{
+						Name: "k8s",
+					},
+					athenzSAPrefix: "",
+				}}
+				if !reflect.DeepEqual(m.resource.(*resourceMapper).res, want) {
+					return fmt.Errorf("resource mapper resolver = %v, want %v", m.resource.(*resourceMapper).res, want)
+				}
+				if !reflect.DeepEqual(m.user.(*userMapper).res, want) {
+					return fmt.Errorf("user mapper resolver = %v, want %v", m.user.(*userMapper).res, want)
+				}
+				return nil
+			},
+		},
+		{
+			name: "Check NewMapper fail with unknown platform",
+			args: args{
+				cfg: config.Mapping{
+					TLD: config.TLD{
+						Platform: config.Platform{
+							Name: "unknown",
+						},
+					},
+				},
+			},
+			wantErr: fmt.Errorf(`resolver instantiate failed: unknown platform "unknown", registered platforms: aks, eks, k8s`),
+		},
+		{
+			name: "Check NewMapper fail with unknown platform of profile",
+			args: args{
+				cfg: config.Mapping{
+					Profiles: map[string]config.TLD{
+						"cluster-a": {
+							Platform: config.Platform{
+								Name: "unknown",
+							},
+						},
+					},
+				},
+			},
+			wantErr: fmt.Errorf(`resolver instantiate failed for profile "cluster-a": unknown platform "unknown", registered platforms: aks, eks, k8s`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMapper(tt.args.cfg)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("NewMapper() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("NewMapper() unexpected error: %v", err)
+				return
+			}
+			if err := tt.checkFunc(got); err != nil {
+				t.Errorf("NewMapper() error = %v", err)
+			}
+		})
+	}
+}
+
+func Test_mapper_Reload(t *testing.T) {
+	type args struct {
+		cfg config.Mapping
+	}
+	tests := []struct {
+		name    string
+		before  config.Mapping
+		args    args
+		want    authn.UserInfo
+		wantAC  []webhook.AthenzAccessCheck
+		wantErr error
+	}{
+		{
+			name: "Check Reload swaps the mapping rules",
+			before: config.Mapping{
+				TLD: config.TLD{
+					Platform: config.Platform{
+						ServiceAthenzDomains: []string{"before"},
+					},
+				},
+			},
+			args: args{
+				cfg: config.Mapping{
+					TLD: config.TLD{
+						Platform: config.Platform{
+							ServiceAthenzDomains: []string{"after"},
+						},
+					},
+				},
+			},
+			want: authn.UserInfo{
+				Username: "domain.service",
+				UID:      "domain.service",
+			},
+			wantAC: []webhook.AthenzAccessCheck{
+				{
+					Resource: "after:pods",
+					Action:   "get",
+				},
+			},
+		},
+		{
+			name: "Check Reload keeps the mapping rules with invalid pattern",
+			before: config.Mapping{
+				TLD: config.TLD{
+					Platform: config.Platform{
+						ServiceAthenzDomains: []string{"before"},
+					},
+				},
+			},
+			args: args{
+				cfg: config.Mapping{
+					TLD: config.TLD{
+						Platform: config.Platform{
+							ServiceAthenzDomains: []string{"after"},
+							BlackList: []*config.RequestInfo{
+								{Resource: "regex:("},
+							},
+						},
+					},
+				},
+			},
+			want: authn.UserInfo{
+				Username: "domain.service",
+				UID:      "domain.service",
+			},
+			wantAC: []webhook.AthenzAccessCheck{
+				{
+					Resource: "before:pods",
+					Action:   "get",
+				},
+			},
+			wantErr: fmt.Errorf("resolver instantiate failed: black_list[0]: invalid pattern \"regex:(\": error parsing regexp: missing closing ): `^(?:()$`"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMapper(tt.before)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = m.Reload(tt.args.cfg)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("Reload() unexpected error: %v", err)
+				return
+			}
+			if tt.wantErr != nil && (err == nil || err.Error() != tt.wantErr.Error()) {
+				t.Errorf("Reload() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+
+			_, gotAC, err := m.MapResource(context.Background(), authz.SubjectAccessReviewSpec{
+				ResourceAttributes: &authz.ResourceAttributes{
+					Verb:     "get",
+					Resource: "pods",
+				},
+			})
+			if err != nil {
+				t.Errorf("MapResource() unexpected error: %v", err)
+				return
+			}
+			if !reflect.DeepEqual(gotAC, tt.wantAC) {
+				t.Errorf("MapResource() = %v, want %v", gotAC, tt.wantAC)
+			}
+
+			got, err := m.MapUser(context.Background(), "domain", "service")
+			if err != nil {
+				t.Errorf("MapUser() unexpected error: %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MapUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mapper_MapResource(t *testing.T) {
+	newMapping := func(domain string) config.Mapping {
+		return config.Mapping{
+			TLD: config.TLD{
+				Platform: config.Platform{
+					ServiceAthenzDomains: []string{domain},
+				},
+			},
+		}
+	}
+	spec := authz.SubjectAccessReviewSpec{
+		User: "user",
+		ResourceAttributes: &authz.ResourceAttributes{
+			Verb:     "get",
+			Resource: "pods",
+		},
+	}
+	tests := []struct {
+		name       string
+		ctx        func() context.Context
+		wantResult string
+	}{
+		{
+			name: "Check the request is mapped only once with trace",
+			ctx: func() context.Context {
+				ctx, _ := withTrace(context.Background())
+				return ctx
+			},
+			wantResult: "before:pods",
+		},
+		{
+			name:       "Check the request is mapped every time without trace",
+			ctx:        context.Background,
+			wantResult: "after:pods",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMapper(newMapping("before"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := tt.ctx()
+			_, _, err = m.MapResource(ctx, spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = m.Reload(newMapping("after")); err != nil {
+				t.Fatal(err)
+			}
+
+			_, got, err := m.MapResource(ctx, spec)
+			if err != nil {
+				t.Errorf("MapResource() unexpected error: %v", err)
+				return
+			}
+			if len(got) != 1 || got[0].Resource != tt.wantResult {
+				t.Errorf("MapResource() = %v, want resource %v", got, tt.wantResult)
+			}
+		})
+	}
+}
+
+func Test_mapper_selectProfile(t *testing.T) {
+	cfg := config.Mapping{
+		TLD: config.TLD{
+			Platform: config.Platform{
+				ServiceAthenzDomains: []string{"default"},
+			},
+		},
+		Profiles: map[string]config.TLD{
+			"cluster-a": {
+				Platform: config.Platform{
+					ServiceAthenzDomains: []string{"cluster-a"},
+				},
+			},
+			"cluster-b": {
+				Platform: config.Platform{
+					ServiceAthenzDomains: []string{"cluster-b"},
+				},
+			},
+		},
+		ProfileHeader:   "X-Garm-Profile",
+		ProfileExtraKey: "garm/profile",
+	}
+	type args struct {
+		request profileRequest
+		extra   map[string]authz.ExtraValue
+	}
+	tests := []struct {
+		name        string
+		args        args
+		wantAC      []webhook.AthenzAccessCheck
+		wantProfile string
+		wantErr     error
+	}{
+		{
+			name: "Check default profile",
+			wantAC: []webhook.AthenzAccessCheck{
+				{
+					Resource: "default:pods",
+					Action:   "get",
+				},
+			},
+		},
+		{
+			name: "Check profile selected by URL path first",
+			args: args{
+				request: profileRequest{
+					name: "cluster-a",
+					header: http.Header{
+						"X-Garm-Profile": []string{"cluster-b"},
+					},
+				},
+				extra: map[string]authz.ExtraValue{
+					"garm/profile": {"cluster-b"},
+				},
+			},
+			wantAC: []webhook.AthenzAccessCheck{
+				{
+					Resource: "cluster-a:pods",
+					Action:   "get",
+				},
+			},
+			wantProfile: "cluster-a",
+		},
+		{
+			name: "Check profile selected by header",
+			args: args{
+				request: profileRequest{
+					header: http.Header{
+						"X-Garm-Profile": []string{"cluster-b"},
+					},
+				},
+				extra: map[string]authz.ExtraValue{
+					"garm/profile": {"cluster-a"},
+				},
+			},
+			wantAC: []webhook.AthenzAccessCheck{
+				{
+					Resource: "cluster-b:pods",
+					Action:   "get",
+				},
+			},
+			wantProfile: "cluster-b",
+		},
+		{
+			name: "Check profile selected by SubjectAccessReview extra",
+			args: args{
+				extra: map[string]authz.ExtraValue{
+					"garm/profile": {"cluster-a"},
+				},
+			},
+			wantAC: []webhook.AthenzAccessCheck{
+				{
+					Resource: "cluster-a:pods",
+					Action:   "get",
+				},
+			},
+			wantProfile: "cluster-a",
+		},
+		{
+			name: "Check unknown profile",
+			args: args{
+				request: profileRequest{
+					name: "cluster-c",
+				},
+			},
+			wantProfile: "cluster-c",
+			wantErr:     fmt.Errorf(`unknown mapping profile "cluster-c"`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMapper(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, tr := withTrace(context.Background())
+			tr.request = tt.args.request
+
+			_, gotAC, err := m.MapResource(ctx, authz.SubjectAccessReviewSpec{
+				ResourceAttributes: &authz.ResourceAttributes{
+					Verb:     "get",
+					Resource: "pods",
+				},
+				Extra: tt.args.extra,
+			})
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("MapResource() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("MapResource() unexpected error: %v", err)
+				return
+			}
+			if !reflect.DeepEqual(gotAC, tt.wantAC) {
+				t.Errorf("MapResource() = %v, want %v", gotAC, tt.wantAC)
+			}
+			if tr.profile != tt.wantProfile {
+				t.Errorf("trace profile = %q, want %q", tr.profile, tt.wantProfile)
+			}
+		})
+	}
+}
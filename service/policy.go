@@ -0,0 +1,472 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/zmssvctoken"
+	"github.com/AthenZ/athenz/utils/zpe-updater/util"
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+// PolicyStore represents an interface to hold the Athenz policies fetched from ZTS, and to evaluate the access checks locally.
+type PolicyStore interface {
+	// StartPolicyUpdater starts a go routine to fetch the signed policies periodically.
+	StartPolicyUpdater(context.Context) PolicyStore
+	// Evaluate returns the decision of the access check for the roles of the principal in the domain of the check.
+	// The roles may be a subset of the roles of the principal, e.g. the roles of an access token requested with a role scope.
+	// It returns false as the second value if the policies of the domain are not available, or the other roles of the principal may change the decision.
+	Evaluate(check webhook.AthenzAccessCheck, roles []string) (bool, bool)
+}
+
+type policyStore struct {
+	mu sync.RWMutex
+	// url is the ZTS URL.
+	url string
+	// domains are the Athenz domains to fetch the signed policies.
+	domains []string
+	// interval is the duration between each fetch.
+	interval time.Duration
+	// client sends the signed policy requests.
+	client *http.Client
+	// authHeader is the HTTP header name of the n-token.
+	authHeader string
+	// token provides the n-token of Garm, nil sends the requests without n-token.
+	token webhook.IdentityToken
+	// ztsKeys verify the ZTS signatures by key ID.
+	ztsKeys map[string]zmssvctoken.Verifier
+	// zmsKeys verify the ZMS signatures by key ID.
+	zmsKeys map[string]zmssvctoken.Verifier
+	// policies are the verified policies by domain.
+	policies map[string]*domainPolicy
+}
+
+// domainPolicy is the verified policies of a domain.
+type domainPolicy struct {
+	// etag is the ETag header of the response, sent to skip the unmodified policies.
+	etag string
+	// expires is the expiry of the signed policies.
+	expires time.Time
+	// allow and deny are the assertions by effect.
+	allow []*policyAssertion
+	deny  []*policyAssertion
+}
+
+// policyAssertion is an assertion compiled to the anchored regular expressions.
+type policyAssertion struct {
+	role     *regexp.Regexp
+	action   *regexp.Regexp
+	resource *regexp.Regexp
+	// caseSensitive is true if the action and resource are compared as they are, otherwise in lower case.
+	caseSensitive bool
+}
+
+// policyAuthorizer is a http.Handler evaluating the Athenz access checks locally, and passing the requests it cannot decide to the Athenz authorizer.
+type policyAuthorizer struct {
+	// next is the Athenz authorizer.
+	next http.Handler
+	// store holds the policies.
+	store PolicyStore
+	// mapper creates the Athenz access checks from the request.
+	mapper webhook.ResourceMapper
+	// signer verifies the roles signed by the access token authenticator.
+	signer *scopeSigner
+	// lp creates the logger outputting the local decisions with the request ID.
+	lp webhook.LogProvider
+}
+
+// NewPolicyStore returns a PolicyStore fetching the signed policies of cfg.Policy.Domains from "athenz.url" every "athenz.policy.refresh_duration".
+// The requests carry the n-token of cfg.AuthZ.Token, hence, it should be set before.
+func NewPolicyStore(cfg config.Athenz) (PolicyStore, error) {
+	dur, err := time.ParseDuration(cfg.Policy.RefreshDuration)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid policy refresh duration %s", cfg.Policy.RefreshDuration)
+	}
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid athenz timeout %s", cfg.Timeout)
+	}
+	ztsKeys, err := loadVerifiers(cfg.Policy.ZTSPublicKeys)
+	if err != nil {
+		return nil, errors.Wrap(err, "zts public key load failed")
+	}
+	zmsKeys, err := loadVerifiers(cfg.Policy.ZMSPublicKeys)
+	if err != nil {
+		return nil, errors.Wrap(err, "zms public key load failed")
+	}
+
+	tcfg := new(tls.Config)
+	if cfg.AthenzRootCA != "" {
+		tcfg.RootCAs, err = NewX509CertPool(config.GetActualValue(cfg.AthenzRootCA))
+		if err != nil {
+			return nil, errors.Wrap(err, "policy x509 certpool error")
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tcfg
+
+	domains := make([]string, 0, len(cfg.Policy.Domains))
+	for _, d := range cfg.Policy.Domains {
+		domains = append(domains, config.GetActualValue(d))
+	}
+
+	return &policyStore{
+		url:      strings.TrimSuffix(cfg.URL, "/"),
+		domains:  domains,
+		interval: dur,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+		authHeader: cfg.AuthHeader,
+		token:      cfg.AuthZ.Token,
+		ztsKeys:    ztsKeys,
+		zmsKeys:    zmsKeys,
+		policies:   make(map[string]*domainPolicy, len(domains)),
+	}, nil
+}
+
+// loadVerifiers returns the signature verifiers of the public key PEM files by key ID.
+func loadVerifiers(paths map[string]string) (map[string]zmssvctoken.Verifier, error) {
+	keys := make(map[string]zmssvctoken.Verifier, len(paths))
+	for id, path := range paths {
+		b, err := ioutil.ReadFile(config.GetActualValue(path))
+		if err != nil {
+			return nil, errors.Wrapf(err, "key ID %s", id)
+		}
+		keys[id], err = zmssvctoken.NewVerifier(b)
+		if err != nil {
+			return nil, errors.Wrapf(err, "key ID %s", id)
+		}
+	}
+	return keys, nil
+}
+
+// StartPolicyUpdater returns a PolicyStore.
+// It starts a go routine to fetch the signed policies immediately, and then periodically.
+func (s *policyStore) StartPolicyUpdater(ctx context.Context) PolicyStore {
+	go func() {
+		s.update(ctx)
+
+		ticker := time.NewTicker(s.interval)
+		for {
+			select {
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			case <-ticker.C:
+				s.update(ctx)
+			}
+		}
+	}()
+	return s
+}
+
+// update fetches the signed policies of every domain. On failure, the last verified policies are kept until they expire.
+func (s *policyStore) update(ctx context.Context) {
+	for _, d := range s.domains {
+		err := s.fetch(ctx, d)
+		if err != nil {
+			err = glg.Warn(errors.Wrapf(err, "policy update failed for domain %s, keep using the cached policies", d))
+			if err != nil {
+				glg.Fatal(err)
+			}
+		}
+	}
+}
+
+// fetch requests the signed policies of the domain to ZTS, and stores them after verification.
+func (s *policyStore) fetch(ctx context.Context, domain string) error {
+	req, err := http.NewRequest(http.MethodGet, s.url+"/domain/"+domain+"/signed_policy_data", nil)
+	if err != nil {
+		return errors.Wrap(err, "policy request creation failed")
+	}
+	if s.token != nil {
+		tok, err := s.token()
+		if err != nil {
+			return errors.Wrap(err, "policy request token failed")
+		}
+		req.Header.Set(s.authHeader, tok)
+	}
+	s.mu.RLock()
+	if p, ok := s.policies[domain]; ok && p.etag != "" && time.Now().Add(s.interval).Before(p.expires) {
+		// ZTS re-signs the unmodified policies with a new expiry, hence, the policies expiring before the next fetch are fetched in full
+		req.Header.Set("If-None-Match", p.etag)
+	}
+	s.mu.RUnlock()
+
+	res, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "policy request failed")
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("policy request returns %s", res.Status)
+	}
+
+	var data zts.DomainSignedPolicyData
+	err = json.NewDecoder(res.Body).Decode(&data)
+	if err != nil {
+		return errors.Wrap(err, "policy decode failed")
+	}
+	p, err := s.verify(domain, &data)
+	if err != nil {
+		return err
+	}
+	p.etag = res.Header.Get("ETag")
+
+	s.mu.Lock()
+	s.policies[domain] = p
+	s.mu.Unlock()
+	return nil
+}
+
+// verify checks the expiry, the ZTS signature and the ZMS signature of the signed policies, and returns the compiled policies.
+func (s *policyStore) verify(domain string, data *zts.DomainSignedPolicyData) (*domainPolicy, error) {
+	spd := data.SignedPolicyData
+	if spd == nil || spd.PolicyData == nil {
+		return nil, errors.New("no policy data")
+	}
+	if string(spd.PolicyData.Domain) != domain {
+		return nil, errors.Errorf("policy data of domain %s, want %s", spd.PolicyData.Domain, domain)
+	}
+	if !time.Now().Before(spd.Expires.Time) {
+		return nil, errors.Errorf("policy data expired at %s", spd.Expires)
+	}
+
+	err := verifySignature(s.ztsKeys, data.KeyId, spd, data.Signature)
+	if err != nil {
+		return nil, errors.Wrap(err, "zts signature verification failed")
+	}
+	err = verifySignature(s.zmsKeys, spd.ZmsKeyId, spd.PolicyData, spd.ZmsSignature)
+	if err != nil {
+		return nil, errors.Wrap(err, "zms signature verification failed")
+	}
+
+	p := &domainPolicy{
+		expires: spd.Expires.Time,
+	}
+	rolePrefix := domain + ":role."
+	for _, policy := range spd.PolicyData.Policies {
+		if policy == nil {
+			continue
+		}
+		cs := policy.CaseSensitive != nil && *policy.CaseSensitive
+		for _, a := range policy.Assertions {
+			if a == nil || !strings.HasPrefix(a.Role, rolePrefix) {
+				// roles of other domains are never in the roles of this domain
+				continue
+			}
+			res := a.Resource
+			if !strings.Contains(res, ":") {
+				res = domain + ":" + res
+			}
+			pa := &policyAssertion{
+				role:          globPattern(strings.TrimPrefix(a.Role, rolePrefix), true),
+				action:        globPattern(a.Action, cs),
+				resource:      globPattern(res, cs),
+				caseSensitive: cs,
+			}
+			if a.Effect != nil && *a.Effect == zts.DENY {
+				p.deny = append(p.deny, pa)
+			} else {
+				p.allow = append(p.allow, pa)
+			}
+		}
+	}
+	return p, nil
+}
+
+// verifySignature verifies the signature of the canonical JSON of obj with the key of the key ID.
+func verifySignature(keys map[string]zmssvctoken.Verifier, id string, obj interface{}, signature string) error {
+	key, ok := keys[id]
+	if !ok {
+		return errors.Errorf("unknown key ID %q", id)
+	}
+	in, err := util.ToCanonicalString(obj)
+	if err != nil {
+		return err
+	}
+	return key.Verify(in, signature)
+}
+
+// globPattern returns the anchored regular expression of the Athenz glob, "*" matches any characters and "?" matches a character.
+func globPattern(glob string, caseSensitive bool) *regexp.Regexp {
+	if !caseSensitive {
+		glob = strings.ToLower(glob)
+	}
+	p := regexp.QuoteMeta(glob)
+	p = strings.Replace(p, `\*`, ".*", -1)
+	p = strings.Replace(p, `\?`, ".", -1)
+	return regexp.MustCompile("^" + p + "$")
+}
+
+// Evaluate returns the decision of the access check in the same way as ZPE, as far as the roles can decide.
+// The check is denied if any deny assertion of the roles matches, and allowed if any allow assertion of the roles matches.
+// As the principal may hold other roles, the check is not decided if a deny assertion of other roles matches, or no assertion matches.
+func (s *policyStore) Evaluate(check webhook.AthenzAccessCheck, roles []string) (bool, bool) {
+	s.mu.RLock()
+	p, ok := s.policies[checkDomain(check)]
+	s.mu.RUnlock()
+	if !ok || !time.Now().Before(p.expires) {
+		return false, false
+	}
+
+	denied := false
+	for _, a := range p.deny {
+		if !a.matchCheck(check) {
+			continue
+		}
+		if a.matchRoles(roles) {
+			return false, true
+		}
+		denied = true
+	}
+	if denied {
+		// the principal may hold the role of the deny assertion
+		return false, false
+	}
+	for _, a := range p.allow {
+		if a.matchCheck(check) && a.matchRoles(roles) {
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// matchCheck returns true if the assertion matches the action and resource.
+func (a *policyAssertion) matchCheck(check webhook.AthenzAccessCheck) bool {
+	action, resource := check.Action, check.Resource
+	if !a.caseSensitive {
+		action, resource = strings.ToLower(action), strings.ToLower(resource)
+	}
+	return a.action.MatchString(action) && a.resource.MatchString(resource)
+}
+
+// matchRoles returns true if the assertion matches any of the roles.
+func (a *policyAssertion) matchRoles(roles []string) bool {
+	for _, r := range roles {
+		if a.role.MatchString(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDomain returns the Athenz domain of the access check resource.
+func checkDomain(check webhook.AthenzAccessCheck) string {
+	if i := strings.Index(check.Resource, ":"); i > 0 {
+		return check.Resource[:i]
+	}
+	return ""
+}
+
+// newPolicyAuthorizer returns a http.Handler evaluating the access checks with the policies of store, and passing the undecided requests to next.
+// The roles are taken only from the extra fields signed by signer.
+func newPolicyAuthorizer(next http.Handler, store PolicyStore, mapper webhook.ResourceMapper, signer *scopeSigner, lp webhook.LogProvider) http.Handler {
+	return &policyAuthorizer{
+		next:   next,
+		store:  store,
+		mapper: mapper,
+		signer: signer,
+		lp:     lp,
+	}
+}
+
+// ServeHTTP answers the request if the access checks are decided locally.
+// The request is allowed if any check is allowed, and denied only if every check is denied locally.
+// A check is not decided locally if the request has no roles signed by the access token authenticator, the roles are not of its domain,
+// or the policies of its domain cannot decide it (see PolicyStore.Evaluate).
+// The mapping is shared with the authorizers behind by the request trace, hence, the request is mapped only once.
+func (p *policyAuthorizer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var sar authz.SubjectAccessReview
+	if json.Unmarshal(body, &sar) != nil {
+		// let the authorizer report the error
+		p.next.ServeHTTP(w, r)
+		return
+	}
+	domain, roles, ok := p.signer.verify(sar.Spec.User, sar.Spec.Extra)
+	if !ok {
+		p.next.ServeHTTP(w, r)
+		return
+	}
+	principal, checks, err := p.mapper.MapResource(r.Context(), sar.Spec)
+	if err != nil || len(checks) == 0 {
+		p.next.ServeHTTP(w, r)
+		return
+	}
+
+	decided := true
+	for _, c := range checks {
+		if checkDomain(c) != domain {
+			decided = false
+			continue
+		}
+		allowed, ok := p.store.Evaluate(c, roles)
+		if !ok {
+			decided = false
+			continue
+		}
+		if allowed {
+			p.decided(r, principal, c.String(), true)
+			writeStatus(w, sar, authz.SubjectAccessReviewStatus{Allowed: true})
+			return
+		}
+	}
+	if !decided {
+		p.next.ServeHTTP(w, r)
+		return
+	}
+
+	p.decided(r, principal, quoteChecks(checks), false)
+	writeStatus(w, sar, authz.SubjectAccessReviewStatus{
+		EvaluationError: athenzDenialMessage(principal, checks),
+	})
+}
+
+// decided marks the request trace as decided locally, and outputs the decision.
+func (p *policyAuthorizer) decided(r *http.Request, principal, checks string, allowed bool) {
+	if t := traceFrom(r.Context()); t != nil {
+		t.local = true
+	}
+	p.lp(requestIDFrom(r.Context())).Printf("authz policy %s: %s -> allowed=%t\n", principal, checks, allowed)
+}
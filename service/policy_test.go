@@ -0,0 +1,699 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/zmssvctoken"
+	"github.com/AthenZ/athenz/utils/zpe-updater/util"
+	"github.com/kpango/glg"
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+const (
+	// policyTestData is the signed policy data of domain k8s, the signatures are set by policyTestKeys.sign.
+	policyTestData = `{
+  "signedPolicyData": {
+    "policyData": {
+      "domain": "%s",
+      "policies": [
+        {
+          "name": "k8s:policy.admin",
+          "assertions": [
+            {"role": "k8s:role.admin", "resource": "k8s:*", "action": "*", "effect": "ALLOW"},
+            {"role": "k8s:role.admin", "resource": "k8s:secrets.*", "action": "delete", "effect": "DENY"},
+            {"role": "k8s:role.view?r", "resource": "pods.*", "action": "get", "effect": "ALLOW"},
+            {"role": "other:role.admin", "resource": "k8s:*", "action": "*", "effect": "ALLOW"}
+          ]
+        }
+      ]
+    },
+    "zmsSignature": "unsigned",
+    "zmsKeyId": "zms-0",
+    "modified": "2026-01-01T00:00:00.000Z",
+    "expires": "%s"
+  },
+  "signature": "unsigned",
+  "keyId": "zts-0"
+}`
+)
+
+// policyTestKeys are the ZTS and ZMS signing keys of the test signed policy data.
+type policyTestKeys struct {
+	zts zmssvctoken.Signer
+	zms zmssvctoken.Signer
+	// paths are the public key PEM files by name.
+	paths map[string]string
+}
+
+func newPolicyTestKeys(t *testing.T, dir string) policyTestKeys {
+	k := policyTestKeys{paths: make(map[string]string)}
+	signer := func(name string) zmssvctoken.Signer {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name+".pem")
+		err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k.paths[name] = path
+		s, err := zmssvctoken.NewSigner(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	k.zts = signer("zts")
+	k.zms = signer("zms")
+	return k
+}
+
+// signedPolicy returns the signed policy data of the domain, modify changes the data after signing.
+func (k policyTestKeys) signedPolicy(t *testing.T, domain string, expires time.Time, modify func(*zts.DomainSignedPolicyData)) []byte {
+	var data zts.DomainSignedPolicyData
+	err := json.Unmarshal([]byte(fmt.Sprintf(policyTestData, domain, expires.UTC().Format("2006-01-02T15:04:05.000Z"))), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := util.ToCanonicalString(data.SignedPolicyData.PolicyData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.SignedPolicyData.ZmsSignature, err = k.zms.Sign(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err = util.ToCanonicalString(data.SignedPolicyData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Signature, err = k.zts.Sign(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modify != nil {
+		modify(&data)
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func (k policyTestKeys) athenzConfig(url string) config.Athenz {
+	return config.Athenz{
+		URL:        url,
+		Timeout:    "1s",
+		AuthHeader: "Athenz-Principal-Auth",
+		Policy: config.Policy{
+			Enabled:         true,
+			Domains:         []string{"k8s"},
+			RefreshDuration: "1h",
+			ZTSPublicKeys: map[string]string{
+				"zts-0": k.paths["zts"],
+			},
+			ZMSPublicKeys: map[string]string{
+				"zms-0": k.paths["zms"],
+			},
+		},
+		AuthZ: webhook.AuthorizationConfig{
+			Token: func() (string, error) {
+				return "garm-token", nil
+			},
+		},
+	}
+}
+
+func TestNewPolicyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keys := newPolicyTestKeys(t, dir)
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := ioutil.WriteFile(invalid, []byte("dummy"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     func() config.Athenz
+		wantErr error
+	}{
+		{
+			name: "Check NewPolicyStore fail with invalid refresh duration",
+			cfg: func() config.Athenz {
+				c := keys.athenzConfig("")
+				c.Policy.RefreshDuration = "dummy"
+				return c
+			},
+			wantErr: fmt.Errorf(`invalid policy refresh duration dummy: time: invalid duration "dummy"`),
+		},
+		{
+			name: "Check NewPolicyStore fail with invalid timeout",
+			cfg: func() config.Athenz {
+				c := keys.athenzConfig("")
+				c.Timeout = "dummy"
+				return c
+			},
+			wantErr: fmt.Errorf(`invalid athenz timeout dummy: time: invalid duration "dummy"`),
+		},
+		{
+			name: "Check NewPolicyStore fail with missing ZTS public key",
+			cfg: func() config.Athenz {
+				c := keys.athenzConfig("")
+				c.Policy.ZTSPublicKeys = map[string]string{"zts-0": "./testdata/notexists.pem"}
+				return c
+			},
+			wantErr: fmt.Errorf("zts public key load failed: key ID zts-0: open ./testdata/notexists.pem: no such file or directory"),
+		},
+		{
+			name: "Check NewPolicyStore fail with invalid ZMS public key",
+			cfg: func() config.Athenz {
+				c := keys.athenzConfig("")
+				c.Policy.ZMSPublicKeys = map[string]string{"zms-0": invalid}
+				return c
+			},
+			wantErr: fmt.Errorf("zms public key load failed: key ID zms-0: Unable to load public key"),
+		},
+		{
+			name: "Check NewPolicyStore success",
+			cfg: func() config.Athenz {
+				return keys.athenzConfig("https://zts.athenz.io/zts/v1/")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewPolicyStore(tt.cfg())
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("NewPolicyStore() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("NewPolicyStore() unexpected error: %v", err)
+				return
+			}
+			s := got.(*policyStore)
+			if s.url != "https://zts.athenz.io/zts/v1" || !reflect.DeepEqual(s.domains, []string{"k8s"}) || len(s.ztsKeys) != 1 || len(s.zmsKeys) != 1 {
+				t.Errorf("NewPolicyStore() = %+v", s)
+			}
+		})
+	}
+}
+
+func Test_policyStore_fetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keys := newPolicyTestKeys(t, dir)
+	exp := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		body    []byte
+		status  int
+		wantErr string
+		want    bool
+	}{
+		{
+			name:   "Check verified policies are stored",
+			body:   keys.signedPolicy(t, "k8s", exp, nil),
+			status: http.StatusOK,
+			want:   true,
+		},
+		{
+			name: "Check tampered policies are rejected",
+			body: keys.signedPolicy(t, "k8s", exp, func(d *zts.DomainSignedPolicyData) {
+				d.SignedPolicyData.PolicyData.Policies[0].Assertions[1].Effect = nil
+			}),
+			status:  http.StatusOK,
+			wantErr: "zts signature verification failed: crypto/rsa: verification error",
+		},
+		{
+			name: "Check policies re-signed by ZTS only are rejected",
+			body: keys.signedPolicy(t, "k8s", exp, func(d *zts.DomainSignedPolicyData) {
+				d.SignedPolicyData.PolicyData.Policies[0].Assertions[1].Effect = nil
+				in, _ := util.ToCanonicalString(d.SignedPolicyData)
+				d.Signature, _ = keys.zts.Sign(in)
+			}),
+			status:  http.StatusOK,
+			wantErr: "zms signature verification failed: crypto/rsa: verification error",
+		},
+		{
+			name: "Check policies of unknown key ID are rejected",
+			body: keys.signedPolicy(t, "k8s", exp, func(d *zts.DomainSignedPolicyData) {
+				d.KeyId = "zts-1"
+			}),
+			status:  http.StatusOK,
+			wantErr: `zts signature verification failed: unknown key ID "zts-1"`,
+		},
+		{
+			name:    "Check policies of other domain are rejected",
+			body:    keys.signedPolicy(t, "other", exp, nil),
+			status:  http.StatusOK,
+			wantErr: "policy data of domain other, want k8s",
+		},
+		{
+			name:    "Check expired policies are rejected",
+			body:    keys.signedPolicy(t, "k8s", time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC), nil),
+			status:  http.StatusOK,
+			wantErr: "policy data expired at 2026-01-02T00:00:00.000Z",
+		},
+		{
+			name:    "Check error status",
+			status:  http.StatusNotFound,
+			wantErr: "policy request returns 404 Not Found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/zts/v1/domain/k8s/signed_policy_data" || r.Header.Get("Athenz-Principal-Auth") != "garm-token" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.WriteHeader(tt.status)
+				w.Write(tt.body)
+			}))
+			defer srv.Close()
+
+			s, err := NewPolicyStore(keys.athenzConfig(srv.URL + "/zts/v1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.(*policyStore).fetch(context.Background(), "k8s")
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("fetch() unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("fetch() error = %v, want %v", err, tt.wantErr)
+			}
+			if _, ok := s.(*policyStore).policies["k8s"]; ok != tt.want {
+				t.Errorf("policies stored = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func Test_policyStore_fetch_notModified(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keys := newPolicyTestKeys(t, dir)
+	renewed := time.Now().Add(time.Hour * 2).UTC().Truncate(time.Millisecond)
+	body := keys.signedPolicy(t, "k8s", renewed, nil)
+
+	tests := []struct {
+		name        string
+		cached      *domainPolicy
+		wantETag    string
+		wantExpires time.Time
+	}{
+		{
+			name: "Check unmodified policies are kept",
+			cached: &domainPolicy{
+				etag:    `"v1"`,
+				expires: renewed.Add(time.Hour),
+			},
+			wantETag:    `"v1"`,
+			wantExpires: renewed.Add(time.Hour),
+		},
+		{
+			name: "Check expiring policies are fetched in full",
+			cached: &domainPolicy{
+				etag:    `"v1"`,
+				expires: time.Now().Add(time.Minute),
+			},
+			wantETag:    `"v1"`,
+			wantExpires: renewed,
+		},
+		{
+			name: "Check expired policies are fetched in full",
+			cached: &domainPolicy{
+				etag:    `"v1"`,
+				expires: time.Now().Add(-time.Minute),
+			},
+			wantETag:    `"v1"`,
+			wantExpires: renewed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("If-None-Match") == `"v1"` {
+					// ZTS answers 304 even if the policies are re-signed with a new expiry
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Header().Set("ETag", `"v1"`)
+				w.Write(body)
+			}))
+			defer srv.Close()
+
+			s, err := NewPolicyStore(keys.athenzConfig(srv.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ps := s.(*policyStore)
+			ps.policies["k8s"] = tt.cached
+			if err := ps.fetch(context.Background(), "k8s"); err != nil {
+				t.Fatalf("fetch() unexpected error: %v", err)
+			}
+			got := ps.policies["k8s"]
+			if got.etag != tt.wantETag || !got.expires.Equal(tt.wantExpires) {
+				t.Errorf("fetch() policies = %v, %v, want %v, %v", got.etag, got.expires, tt.wantETag, tt.wantExpires)
+			}
+		})
+	}
+}
+
+func Test_policyStore_StartPolicyUpdater(t *testing.T) {
+	glg.Get().SetLevelMode(glg.WARN, glg.NONE)
+	dir, err := ioutil.TempDir("", "garm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keys := newPolicyTestKeys(t, dir)
+	body := keys.signedPolicy(t, "k8s", time.Now().Add(time.Hour), nil)
+
+	var requests, notModified, down int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch {
+		case atomic.LoadInt32(&down) == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.Header.Get("If-None-Match") == `"v1"`:
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(body)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := keys.athenzConfig(srv.URL)
+	cfg.Policy.RefreshDuration = "10ms"
+	s, err := NewPolicyStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.StartPolicyUpdater(ctx)
+	check := webhook.AthenzAccessCheck{Action: "get", Resource: "k8s:pods"}
+
+	// fetched immediately, and unmodified policies are not sent again
+	time.Sleep(time.Millisecond * 100)
+	if allowed, ok := s.Evaluate(check, []string{"admin"}); !allowed || !ok {
+		t.Errorf("Evaluate() = %v, %v, want true, true", allowed, ok)
+	}
+	if atomic.LoadInt32(&notModified) == 0 {
+		t.Error("no If-None-Match request")
+	}
+
+	// ZTS outage, the cached policies are kept
+	atomic.StoreInt32(&down, 1)
+	time.Sleep(time.Millisecond * 50)
+	if allowed, ok := s.Evaluate(check, []string{"admin"}); !allowed || !ok {
+		t.Errorf("Evaluate() during outage = %v, %v, want true, true", allowed, ok)
+	}
+
+	// context canceled, no more fetch
+	cancel()
+	time.Sleep(time.Millisecond * 50)
+	n := atomic.LoadInt32(&requests)
+	time.Sleep(time.Millisecond * 50)
+	if got := atomic.LoadInt32(&requests); got != n {
+		t.Errorf("requests after cancel = %d, want %d", got, n)
+	}
+}
+
+func Test_policyStore_Evaluate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keys := newPolicyTestKeys(t, dir)
+	var data zts.DomainSignedPolicyData
+	if err := json.Unmarshal(keys.signedPolicy(t, "k8s", time.Now().Add(time.Hour), nil), &data); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewPolicyStore(keys.athenzConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := s.(*policyStore)
+	p, err := ps.verify("k8s", &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.policies["k8s"] = p
+	ps.policies["expired"] = &domainPolicy{
+		expires: time.Now().Add(-time.Minute),
+	}
+
+	tests := []struct {
+		name        string
+		check       webhook.AthenzAccessCheck
+		roles       []string
+		wantAllowed bool
+		wantOK      bool
+	}{
+		{
+			name:        "Check allow assertion with glob resource",
+			check:       webhook.AthenzAccessCheck{Action: "create", Resource: "k8s:deployments.default"},
+			roles:       []string{"admin"},
+			wantAllowed: true,
+			wantOK:      true,
+		},
+		{
+			name:        "Check deny assertion overrides allow assertion",
+			check:       webhook.AthenzAccessCheck{Action: "delete", Resource: "k8s:secrets.default"},
+			roles:       []string{"admin"},
+			wantAllowed: false,
+			wantOK:      true,
+		},
+		{
+			name:        "Check case insensitive match, resource without domain and role glob",
+			check:       webhook.AthenzAccessCheck{Action: "GET", Resource: "k8s:Pods.default"},
+			roles:       []string{"viewer"},
+			wantAllowed: true,
+			wantOK:      true,
+		},
+		{
+			name:   "Check no assertion matches is not decided",
+			check:  webhook.AthenzAccessCheck{Action: "delete", Resource: "k8s:pods.default"},
+			roles:  []string{"viewer", "other"},
+			wantOK: false,
+		},
+		{
+			name:   "Check deny assertion of other roles is not decided",
+			check:  webhook.AthenzAccessCheck{Action: "delete", Resource: "k8s:secrets.default"},
+			roles:  []string{"viewer"},
+			wantOK: false,
+		},
+		{
+			name:   "Check domain without policies",
+			check:  webhook.AthenzAccessCheck{Action: "get", Resource: "unknown:pods"},
+			roles:  []string{"admin"},
+			wantOK: false,
+		},
+		{
+			name:   "Check domain of expired policies",
+			check:  webhook.AthenzAccessCheck{Action: "get", Resource: "expired:pods"},
+			roles:  []string{"admin"},
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, ok := s.Evaluate(tt.check, tt.roles)
+			if allowed != tt.wantAllowed || ok != tt.wantOK {
+				t.Errorf("Evaluate() = %v, %v, want %v, %v", allowed, ok, tt.wantAllowed, tt.wantOK)
+			}
+		})
+	}
+}
+
+// policyTestStore is a PolicyStore allowing the checks of the roles in allow, and denying others of domain k8s.
+type policyTestStore struct {
+	allow map[string]bool
+}
+
+func (s *policyTestStore) StartPolicyUpdater(context.Context) PolicyStore {
+	return s
+}
+
+func (s *policyTestStore) Evaluate(check webhook.AthenzAccessCheck, roles []string) (bool, bool) {
+	if checkDomain(check) != "k8s" {
+		return false, false
+	}
+	for _, r := range roles {
+		if s.allow[r] {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// policyTestMapper is a ResourceMapper returning the checks for the user.
+type policyTestMapper []webhook.AthenzAccessCheck
+
+func (m policyTestMapper) MapResource(ctx context.Context, spec authz.SubjectAccessReviewSpec) (string, []webhook.AthenzAccessCheck, error) {
+	return spec.User, m, nil
+}
+
+func Test_policyAuthorizer_ServeHTTP(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("athenz"))
+	})
+	checks := policyTestMapper{
+		{Action: "get", Resource: "k8s:pods"},
+		{Action: "get", Resource: "other:pods"},
+	}
+	signer := &scopeSigner{key: []byte("test")}
+	newAuthorizer := func(m webhook.ResourceMapper) http.Handler {
+		return newPolicyAuthorizer(next, &policyTestStore{allow: map[string]bool{"admin": true}}, m, signer, func(string) webhook.Logger {
+			return dummyLogger("")
+		})
+	}
+	review := func(groups string, extra map[string]authz.ExtraValue) string {
+		b, err := json.Marshal(extra)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","spec":{"user":"user.alice","group":[` + groups + `],"extra":` + string(b) + `}}`
+	}
+	signed := func(user string, exp time.Time, roles ...string) map[string]authz.ExtraValue {
+		return map[string]authz.ExtraValue{
+			extraScope:          roles,
+			extraAudience:       {"k8s"},
+			extraScopeSignature: {signer.sign(user, "k8s", roles, exp)},
+		}
+	}
+	exp := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		h         http.Handler
+		body      string
+		want      string
+		wantLocal bool
+	}{
+		{
+			name:      "Check allowed locally",
+			h:         newAuthorizer(checks),
+			body:      review(`"k8s:role.admin"`, signed("user.alice", exp, "admin")),
+			want:      `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","status":{"allowed":true}}`,
+			wantLocal: true,
+		},
+		{
+			name:      "Check denied locally only if every check is decided",
+			h:         newAuthorizer(checks[:1]),
+			body:      review(`"k8s:role.viewer"`, signed("user.alice", exp, "viewer")),
+			want:      `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","status":{"allowed":false,"evaluationError":"principal user.alice does not have access to any of 'get on k8s:pods' resources"}}`,
+			wantLocal: true,
+		},
+		{
+			name: "Check check of other domain is passed to Athenz",
+			h:    newAuthorizer(checks),
+			body: review(`"k8s:role.viewer"`, signed("user.alice", exp, "viewer")),
+			want: "athenz",
+		},
+		{
+			name: "Check roles in groups without signature are passed to Athenz",
+			h:    newAuthorizer(checks[:1]),
+			body: review(`"k8s:role.admin"`, nil),
+			want: "athenz",
+		},
+		{
+			name: "Check roles signed for other user are passed to Athenz",
+			h:    newAuthorizer(checks[:1]),
+			body: review(`"k8s:role.admin"`, signed("user.bob", exp, "admin")),
+			want: "athenz",
+		},
+		{
+			name: "Check roles of expired signature are passed to Athenz",
+			h:    newAuthorizer(checks[:1]),
+			body: review(`"k8s:role.admin"`, signed("user.alice", time.Now().Add(-time.Minute), "admin")),
+			want: "athenz",
+		},
+		{
+			name: "Check request without checks is passed to Athenz",
+			h:    newAuthorizer(policyTestMapper{}),
+			body: review(`"k8s:role.admin"`, signed("user.alice", exp, "admin")),
+			want: "athenz",
+		},
+		{
+			name: "Check invalid request is passed to Athenz",
+			h:    newAuthorizer(checks),
+			body: "{",
+			want: "athenz",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, tr := withTrace(context.Background())
+			w := httptest.NewRecorder()
+			tt.h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/authz", bytes.NewBufferString(tt.body)).WithContext(ctx))
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("ServeHTTP() body = %v, want %v", got, tt.want)
+			}
+			if tr.local != tt.wantLocal {
+				t.Errorf("trace local = %v, want %v", tr.local, tt.wantLocal)
+			}
+		})
+	}
+}
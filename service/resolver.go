@@ -17,21 +17,30 @@ limitations under the License.
 package service
 
 import (
+	"fmt"
 	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/yahoojapan/garm/config"
 )
 
 // Resolver is used to map K8s webhook requests to Athenz requests. (Athenz cannot use ":", hence, needs mapping.)
+// The platform Resolvers registered by RegisterResolver should embed the default Resolver instead of implementing every method (see ResolverFactory).
 type Resolver interface {
 	// MapVerbAction maps K8s verb to Athenz action.
 	MapVerbAction(string) string
 	// MapK8sResourceAthenzResource maps K8s resources to resources in Athenz resource.
 	MapK8sResourceAthenzResource(string) string
+	// MapSubresource maps K8s subresource of the K8s resource to subresource in Athenz resource.
+	MapSubresource(resource, subresource string) string
+	// IsSubresourceAsAction returns true if the subresource should be used as Athenz action.
+	IsSubresourceAsAction() bool
 	// BuildDomainsFromNamespace creates Athenz domains with namespace.
 	BuildDomainsFromNamespace(string) []string
 	// PrincipalFromUser creates principal name from user.
 	PrincipalFromUser(user string, groups []string) string
+	// MapK8sGroups maps K8s groups to the group names used by the mapping rules.
+	MapK8sGroups(groups []string) []string
 	// GetAdminDomain creates Athenz admin domain with namespace.
 	GetAdminDomain(string) string
 	// MapAPIGroup maps K8s API group to API group in Athenz resource.
@@ -45,12 +54,36 @@ type Resolver interface {
 	GetNonResourceGroup() string
 	// GetNonResourceNamespace returns the mapped value for K8s non-resource namespace.
 	GetNonResourceNamespace() string
+	// GetResourceTemplate returns the template of the Athenz resource, or "" for the fixed layout.
+	GetResourceTemplate() string
 	// TrimResource sterilizes resources to match Athenz resource naming convention.
 	TrimResource(string) string
 	// IsAllowed returns true if the K8s request should to Athenz, else returns false if directly reject.
-	IsAllowed(verb, namespace, apiGroup, resource, name string) bool
+	IsAllowed(verb, namespace, apiGroup, resource, name, user string, groups []string) bool
+	// IsWhiteListed returns true if the K8s request matches the white list.
+	IsWhiteListed(verb, namespace, apiGroup, resource, name, user string, groups []string) bool
+	// IsLocalAllowed returns true if the K8s request should be allowed directly without querying Athenz.
+	IsLocalAllowed(verb, namespace, apiGroup, resource, name, user string, groups []string) bool
 	// IsAdminAccess returns true if the K8s request should use Athenz admin domain.
-	IsAdminAccess(verb, namespace, apiGroup, resource, name string) bool
+	IsAdminAccess(verb, namespace, apiGroup, resource, name, user string, groups []string) bool
+	// Decide returns the Decision of the ordered request filtering rules, or nil if the rules are disabled.
+	Decide(verb, namespace, apiGroup, resource, subresource, name, user string, groups []string) *Decision
+	// BuildDomains creates Athenz domains from the domain templates with namespace.
+	BuildDomains(domains []string, namespace string) []string
+	// MapGroups returns the K8s groups of the Athenz principal.
+	MapGroups(domain, service string) []string
+	// MapUserInfo returns the K8s username, UID and extra fields of the Athenz principal.
+	MapUserInfo(domain, service string) (username, uid string, extra map[string][]string)
+}
+
+// Decision represents how a K8s request is handled by the request filtering rules.
+type Decision struct {
+	// Action represents the action applied to the request, e.g. config.ActionDeny.
+	Action string
+	// Rules represents the names of the matched rules, e.g. "rules[0]", "default_action" or "white_list".
+	Rules []string
+	// Domains represents the Athenz domain templates of config.ActionCustomDomain.
+	Domains []string
 }
 
 // resolve implements Resolver. It contains the configuration information for a K8s platform.
@@ -79,28 +112,69 @@ type EKSResolve struct {
 }
 
 // NewResolver returns a new resolver using cfg.TLD.Platform.
-// The actual return type depends on cfg.TLD.Platform.Name.
+// The actual return type depends on cfg.TLD.Platform.Name, and is created by the ResolverFactory registered with the name (see RegisterResolver).
+// "" => resolve
 // k8s => K8SResolve
 // aks => AKSResolve
 // eks => EKSResolve
-func NewResolver(cfg config.Mapping) Resolver {
+// It returns an error if no ResolverFactory is registered with the name.
+func NewResolver(cfg config.Mapping) (Resolver, error) {
 	pfConfig := cfg.TLD.Platform
-	res := resolve{
+	err := compilePatterns(pfConfig)
+	if err != nil {
+		return nil, err
+	}
+	res := &resolve{
 		cfg: pfConfig,
 	}
 
 	res.athenzDomains = res.createAthenzDomains(pfConfig.ServiceAthenzDomains)
 	res.athenzSAPrefix = res.createAthenzDomains([]string{pfConfig.AthenzServiceAccountPrefix})[0]
 
-	switch res.cfg.Name {
-	case "k8s":
-		return &K8SResolve{res}
-	case "aks":
-		return &AKSResolve{res}
-	case "eks":
-		return &EKSResolve{res}
+	if pfConfig.Name == "" {
+		return res, nil
+	}
+	factory, ok := lookupResolver(pfConfig.Name)
+	if !ok {
+		return nil, errors.Errorf("unknown platform %q, registered platforms: %s", pfConfig.Name, strings.Join(Platforms(), ", "))
+	}
+	return factory(res, pfConfig), nil
+}
+
+// compilePatterns compiles the patterns of the lists, rules, namespace domains and group mappings.
+// The patterns are compiled before the Resolver is used, hence, an invalid pattern is returned as an error instead of panic while matching.
+func compilePatterns(cfg config.Platform) error {
+	lists := []struct {
+		name string
+		list []*config.RequestInfo
+	}{
+		{"admin_access_list", cfg.AdminAccessList},
+		{"white_list", cfg.WhiteList},
+		{"black_list", cfg.BlackList},
+	}
+	for _, l := range lists {
+		for i, ri := range l.list {
+			if err := ri.Compile(); err != nil {
+				return errors.Wrapf(err, "%s[%d]", l.name, i)
+			}
+		}
+	}
+	for i, r := range cfg.Rules {
+		if err := r.Compile(); err != nil {
+			return errors.Wrapf(err, "rules[%d]", i)
+		}
+	}
+	for i, n := range cfg.NamespaceDomains {
+		if err := n.Compile(); err != nil {
+			return errors.Wrapf(err, "namespace_domains[%d]", i)
+		}
+	}
+	for i, g := range cfg.GroupMappings {
+		if err := g.Compile(); err != nil {
+			return errors.Wrapf(err, "group_mappings[%d]", i)
+		}
 	}
-	return &res
+	return nil
 }
 
 // MapVerbAction returns mapped value in cfg.VerbMappings,
@@ -123,6 +197,27 @@ func (r *resolve) MapK8sResourceAthenzResource(k8sRes string) string {
 	return athenzRes
 }
 
+// MapSubresource returns mapped value of "resource/subresource" in cfg.SubresourceMappings,
+// else returns mapped value of "subresource",
+// else returns the same value.
+func (r *resolve) MapSubresource(resource, subresource string) string {
+	if subresource == "" {
+		return subresource
+	}
+	if sub, ok := r.cfg.SubresourceMappings[resource+"/"+subresource]; ok {
+		return sub
+	}
+	if sub, ok := r.cfg.SubresourceMappings[subresource]; ok {
+		return sub
+	}
+	return subresource
+}
+
+// IsSubresourceAsAction returns cfg.SubresourceAsAction
+func (r *resolve) IsSubresourceAsAction() bool {
+	return r.cfg.SubresourceAsAction
+}
+
 // createAthenzDomains use athenzDomains;
 // do the following for each athenzDomains
 // split it with ".";
@@ -150,10 +245,22 @@ func (r *resolve) createAthenzDomains(athenzDomains []string) []string {
 }
 
 // BuildDomainsFromNamespace returns domains by processing athenzDomains.
+// if namespace matches cfg.NamespaceDomains, use the domains of the first matched override instead of athenzDomains, after replacing "_match_" with the matched part;
 // if namespace != "", replace `/ = .`, then `.. => -`, then replace "_namespace_" in athenzDomains with namespace;
 // else replace "._namespace_" in athenzDomains with namespace;
 // trim ".", then "-", then ":"
 func (r *resolve) BuildDomainsFromNamespace(namespace string) []string {
+	for _, nd := range r.cfg.NamespaceDomains {
+		match, ok := nd.Match(namespace)
+		if !ok {
+			continue
+		}
+		domains := make([]string, 0, len(nd.Domains))
+		for _, domain := range nd.Domains {
+			domains = append(domains, strings.Replace(domain, config.MatchPlaceholder, match, -1))
+		}
+		return r.buildAthenzDomain(r.createAthenzDomains(domains), namespace)
+	}
 	return r.buildAthenzDomain(r.athenzDomains, namespace)
 }
 
@@ -238,6 +345,16 @@ func (r *resolve) GetNonResourceNamespace() string {
 	return r.cfg.NonResourceNamespace
 }
 
+// GetResourceTemplate returns cfg.ResourceTemplate
+func (r *resolve) GetResourceTemplate() string {
+	return r.cfg.ResourceTemplate
+}
+
+// MapK8sGroups returns groups directly
+func (r *resolve) MapK8sGroups(groups []string) []string {
+	return groups
+}
+
 // PrincipalFromUser maps K8s user to Athenz principal.
 // 1. service account: if has ServiceAccountPrefixes, remove prefix, map to AthenzServiceAccountPrefix
 // 1.1. if contains namespace, create domain by the namespace and AthenzServiceAccountPrefix
@@ -308,49 +425,142 @@ func (r *resolve) TrimResource(res string) string {
 // IsAllowed returns true, if inside whitelist or not in both list
 // returns false, only if inside blacklist
 // i.e. return (in whitelist || not in blacklist)
-func (r *resolve) IsAllowed(verb, namespace, apiGroup, resource, name string) bool {
-	var ok bool
-	for _, white := range r.cfg.WhiteList {
-		ok = white.Match(config.RequestInfo{
-			Verb:      verb,
-			Namespace: namespace,
-			APIGroup:  apiGroup,
-			Resource:  resource,
-			Name:      name,
-		})
-		if ok {
-			return true
+func (r *resolve) IsAllowed(verb, namespace, apiGroup, resource, name, user string, groups []string) bool {
+	req := config.RequestInfo{
+		Verb:      verb,
+		Namespace: namespace,
+		APIGroup:  apiGroup,
+		Resource:  resource,
+		Name:      name,
+	}
+	return matchList(r.cfg.WhiteList, req, user, groups, r.cfg.LegacyListMatch) || !matchList(r.cfg.BlackList, req, user, groups, r.cfg.LegacyListMatch)
+}
+
+// IsWhiteListed returns true, if any whitelist in config match
+func (r *resolve) IsWhiteListed(verb, namespace, apiGroup, resource, name, user string, groups []string) bool {
+	return matchList(r.cfg.WhiteList, config.RequestInfo{
+		Verb:      verb,
+		Namespace: namespace,
+		APIGroup:  apiGroup,
+		Resource:  resource,
+		Name:      name,
+	}, user, groups, r.cfg.LegacyListMatch)
+}
+
+// IsLocalAllowed returns true, if cfg.WhiteListLocalAllow is enabled and any whitelist in config match
+func (r *resolve) IsLocalAllowed(verb, namespace, apiGroup, resource, name, user string, groups []string) bool {
+	return r.cfg.WhiteListLocalAllow && r.IsWhiteListed(verb, namespace, apiGroup, resource, name, user, groups)
+}
+
+// IsAdminAccess returns true, if any admin access in config match
+func (r *resolve) IsAdminAccess(verb, namespace, apiGroup, resource, name, user string, groups []string) bool {
+	return matchList(r.cfg.AdminAccessList, config.RequestInfo{
+		Verb:      verb,
+		Namespace: namespace,
+		APIGroup:  apiGroup,
+		Resource:  resource,
+		Name:      name,
+	}, user, groups, r.cfg.LegacyListMatch)
+}
+
+// Decide returns the Decision of the first rule in cfg.Rules matching the request, or the Decision of cfg.DefaultAction if no rule matches.
+// It returns nil if cfg.DefaultAction is empty, i.e. the request should be decided by the lists.
+func (r *resolve) Decide(verb, namespace, apiGroup, resource, subresource, name, user string, groups []string) *Decision {
+	if r.cfg.DefaultAction == "" {
+		return nil
+	}
+	req := config.RequestInfo{
+		Verb:      verb,
+		Namespace: namespace,
+		APIGroup:  apiGroup,
+		Resource:  resource,
+		Name:      name,
+	}
+	for i, rule := range r.cfg.Rules {
+		if rule.Match(req, subresource, user, groups) {
+			return &Decision{
+				Action:  rule.Action,
+				Rules:   []string{fmt.Sprintf("rules[%d]", i)},
+				Domains: rule.Domains,
+			}
 		}
 	}
+	return &Decision{
+		Action: r.cfg.DefaultAction,
+		Rules:  []string{ruleDefaultAction},
+	}
+}
+
+// BuildDomains returns domains by processing the domain templates in the same way as cfg.ServiceAthenzDomains (see BuildDomainsFromNamespace).
+func (r *resolve) BuildDomains(domains []string, namespace string) []string {
+	return r.buildAthenzDomain(r.createAthenzDomains(domains), namespace)
+}
 
-	for _, black := range r.cfg.BlackList {
-		ok = black.Match(config.RequestInfo{
-			Verb:      verb,
-			Namespace: namespace,
-			APIGroup:  apiGroup,
-			Resource:  resource,
-			Name:      name,
-		})
-		if ok {
-			return false
+// MapGroups returns the groups of every matched group mapping in config, without duplication
+// "_domain_" and "_service_" in the groups are replaced with domain and service
+func (r *resolve) MapGroups(domain, service string) []string {
+	var groups []string
+	seen := make(map[string]bool)
+	replacer := strings.NewReplacer("_domain_", domain, "_service_", service)
+	for _, gm := range r.cfg.GroupMappings {
+		if !gm.Match(domain, service) {
+			continue
+		}
+		for _, g := range gm.Groups {
+			g = replacer.Replace(g)
+			if !seen[g] {
+				seen[g] = true
+				groups = append(groups, g)
+			}
 		}
 	}
+	return groups
+}
 
-	return true
+// MapUserInfo returns the K8s username, UID and extra fields by replacing the placeholders in the user mapping templates
+// 1. "_principal_" => ${domain}.${service}
+// 2. "_domain_" => domain, "_service_" => service
+// 3. "_user_" => principal without AthenzUserPrefix, if the rest is mapped back to the same principal by PrincipalFromUser, else principal
+func (r *resolve) MapUserInfo(domain, service string) (string, string, map[string][]string) {
+	principal := domain + "." + service
+	user := principal
+	if r.cfg.AthenzUserPrefix != "" && strings.HasPrefix(principal, r.cfg.AthenzUserPrefix) {
+		if u := strings.TrimPrefix(principal, r.cfg.AthenzUserPrefix); u != "" && !strings.Contains(u, ".") {
+			user = u
+		}
+	}
+
+	replacer := strings.NewReplacer(
+		"_principal_", principal,
+		"_domain_", domain,
+		"_service_", service,
+		"_user_", user,
+	)
+
+	um := r.cfg.UserMapping
+	var extra map[string][]string
+	if len(um.Extra) != 0 {
+		extra = make(map[string][]string, len(um.Extra))
+		for k, vals := range um.Extra {
+			mapped := make([]string, len(vals))
+			for i, v := range vals {
+				mapped[i] = replacer.Replace(v)
+			}
+			extra[k] = mapped
+		}
+	}
+	return replacer.Replace(um.GetUsername()), replacer.Replace(um.GetUID()), extra
 }
 
-// IsAdminAccess returns true, if any admin access in config match
-func (r *resolve) IsAdminAccess(verb, namespace, apiGroup, resource, name string) bool {
-	var ok bool
-	for _, admin := range r.cfg.AdminAccessList {
-		ok = admin.Match(config.RequestInfo{
-			Verb:      verb,
-			Namespace: namespace,
-			APIGroup:  apiGroup,
-			Resource:  resource,
-			Name:      name,
-		})
-		if ok {
+// matchList returns true, if any RequestInfo in list matches req, user and groups.
+// If legacy is true, req is matched by the deprecated RequestInfo.LegacyMatch.
+func matchList(list []*config.RequestInfo, req config.RequestInfo, user string, groups []string, legacy bool) bool {
+	for _, ri := range list {
+		matched := ri.Match
+		if legacy {
+			matched = ri.LegacyMatch
+		}
+		if matched(req) && ri.MatchIdentity(user, groups) {
 			return true
 		}
 	}
@@ -0,0 +1,56 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"strings"
+)
+
+// PrincipalFromUser maps Azure AD user to Athenz principal.
+// 1. mapped user: if the user (UPN or object ID) is in AKS.UserMappings, map to the principal
+// 2. UPN: if the domain part of the UPN is in AKS.UPNDomainMappings, map to "${Athenz domain}.${user name}"
+// The user name containing "." is not mapped by the domain, because Athenz parses the last part as the service of another domain.
+// 3. others: same as the K8s platform
+func (r *AKSResolve) PrincipalFromUser(user string, groups []string) string {
+	if p, ok := r.cfg.AKS.UserMappings[user]; ok {
+		return p
+	}
+
+	if i := strings.LastIndex(user, "@"); i > 0 && !strings.Contains(user[:i], ".") {
+		if domain, ok := r.cfg.AKS.UPNDomainMappings[user[i+1:]]; ok {
+			return domain + "." + user[:i]
+		}
+	}
+
+	return r.resolve.PrincipalFromUser(user, groups)
+}
+
+// MapK8sGroups maps Azure AD group object IDs to group names by AKS.GroupNames, other groups are returned directly.
+func (r *AKSResolve) MapK8sGroups(groups []string) []string {
+	if len(r.cfg.AKS.GroupNames) == 0 || len(groups) == 0 {
+		return groups
+	}
+	mapped := make([]string, len(groups))
+	for i, g := range groups {
+		if name, ok := r.cfg.AKS.GroupNames[g]; ok {
+			mapped[i] = name
+			continue
+		}
+		mapped[i] = g
+	}
+	return mapped
+}
@@ -0,0 +1,150 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yahoojapan/garm/config"
+)
+
+func TestAKSResolve_PrincipalFromUser(t *testing.T) {
+	aks := config.AKS{
+		UserMappings: map[string]string{
+			"3f2504e0-4f89-11d3-9a0c-0305e82c3301": "k8s.admin.deployer",
+			"bob@corp.onmicrosoft.com":             "user.robert",
+		},
+		UPNDomainMappings: map[string]string{
+			"corp.onmicrosoft.com": "user",
+		},
+	}
+	type args struct {
+		user   string
+		groups []string
+	}
+	tests := []struct {
+		name string
+		aks  config.AKS
+		args args
+		want string
+	}{
+		{
+			name: "Check mapped object ID",
+			aks:  aks,
+			args: args{
+				user: "3f2504e0-4f89-11d3-9a0c-0305e82c3301",
+			},
+			want: "k8s.admin.deployer",
+		},
+		{
+			name: "Check mapped UPN has priority over domain mapping",
+			aks:  aks,
+			args: args{
+				user: "bob@corp.onmicrosoft.com",
+			},
+			want: "user.robert",
+		},
+		{
+			name: "Check UPN domain mapping",
+			aks:  aks,
+			args: args{
+				user: "alice@corp.onmicrosoft.com",
+			},
+			want: "user.alice",
+		},
+		{
+			name: "Check UPN with dot in user name is not mapped by domain",
+			aks:  aks,
+			args: args{
+				user: "alice.smith@corp.onmicrosoft.com",
+			},
+			want: "alice.smith@corp.onmicrosoft.com",
+		},
+		{
+			name: "Check UPN of unknown domain is not mapped",
+			aks:  aks,
+			args: args{
+				user: "alice@other.example.com",
+			},
+			want: "alice@other.example.com",
+		},
+		{
+			name: "Check other user is mapped as K8s platform",
+			aks:  config.AKS{},
+			args: args{
+				user: "alice",
+			},
+			want: "user.alice",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &AKSResolve{
+				resolve{
+					cfg: config.Platform{
+						AthenzUserPrefix: "user.",
+						AKS:              tt.aks,
+					},
+				},
+			}
+			if got := r.PrincipalFromUser(tt.args.user, tt.args.groups); got != tt.want {
+				t.Errorf("AKSResolve.PrincipalFromUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAKSResolve_MapK8sGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		aks    config.AKS
+		groups []string
+		want   []string
+	}{
+		{
+			name:   "Check no group names",
+			aks:    config.AKS{},
+			groups: []string{"6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+			want:   []string{"6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		},
+		{
+			name: "Check group object IDs are mapped to names",
+			aks: config.AKS{
+				GroupNames: map[string]string{
+					"6ba7b810-9dad-11d1-80b4-00c04fd430c8": "k8s-admins",
+				},
+			},
+			groups: []string{"6ba7b810-9dad-11d1-80b4-00c04fd430c8", "system:authenticated"},
+			want:   []string{"k8s-admins", "system:authenticated"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &AKSResolve{
+				resolve{
+					cfg: config.Platform{
+						AKS: tt.aks,
+					},
+				},
+			}
+			if got := r.MapK8sGroups(tt.groups); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AKSResolve.MapK8sGroups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
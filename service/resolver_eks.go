@@ -0,0 +1,108 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"strings"
+)
+
+// iamARN is the parsed IAM role or user ARN of the EKS user.
+type iamARN struct {
+	// account is the AWS account ID.
+	account string
+	// typ is "role" or "user".
+	typ string
+	// name is the role or user name without path.
+	name string
+	// session is the role session name, only for assumed roles.
+	session string
+	// base is the IAM role or user ARN, i.e. the role ARN for assumed roles.
+	base string
+}
+
+// parseIAMARN parses the IAM role/user ARN or the STS assumed role ARN.
+// arn:${partition}:iam::${account}:role/${path}${name}
+// arn:${partition}:iam::${account}:user/${path}${name}
+// arn:${partition}:sts::${account}:assumed-role/${name}/${session}
+func parseIAMARN(user string) (iamARN, bool) {
+	parts := strings.SplitN(user, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[3] != "" {
+		return iamARN{}, false
+	}
+	partition, service, account, resource := parts[1], parts[2], parts[4], parts[5]
+
+	res := strings.Split(resource, "/")
+	if len(res) < 2 || res[len(res)-1] == "" {
+		return iamARN{}, false
+	}
+	switch {
+	case service == "iam" && (res[0] == "role" || res[0] == "user"):
+		return iamARN{
+			account: account,
+			typ:     res[0],
+			name:    res[len(res)-1],
+			base:    user,
+		}, true
+	case service == "sts" && res[0] == "assumed-role" && len(res) == 3:
+		return iamARN{
+			account: account,
+			typ:     "role",
+			name:    res[1],
+			session: res[2],
+			base:    "arn:" + partition + ":iam::" + account + ":role/" + res[1],
+		}, true
+	}
+	return iamARN{}, false
+}
+
+// PrincipalFromUser maps EKS user to Athenz principal.
+// 1. mapped user: if the user (or the role of the assumed role ARN) is in EKS.UserMappings, map to the principal
+// 2. system group: if any group is in EKS.SystemGroupMappings, map to the principal
+// 3. IAM ARN: if EKS.ARNTemplate is set, map to the template, else no mapping
+// 4. others: same as the K8s platform
+func (r *EKSResolve) PrincipalFromUser(user string, groups []string) string {
+	arn, isARN := parseIAMARN(user)
+
+	if p, ok := r.cfg.EKS.UserMappings[user]; ok {
+		return p
+	}
+	if isARN {
+		if p, ok := r.cfg.EKS.UserMappings[arn.base]; ok {
+			return p
+		}
+	}
+
+	for _, g := range groups {
+		if p, ok := r.cfg.EKS.SystemGroupMappings[g]; ok {
+			return p
+		}
+	}
+
+	if isARN {
+		if r.cfg.EKS.ARNTemplate == "" {
+			return user
+		}
+		return strings.NewReplacer(
+			"_account_", arn.account,
+			"_type_", arn.typ,
+			"_name_", arn.name,
+			"_session_", arn.session,
+		).Replace(r.cfg.EKS.ARNTemplate)
+	}
+
+	return r.resolve.PrincipalFromUser(user, groups)
+}
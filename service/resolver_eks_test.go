@@ -0,0 +1,185 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yahoojapan/garm/config"
+)
+
+func Test_parseIAMARN(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   string
+		want   iamARN
+		wantOk bool
+	}{
+		{
+			name: "Check IAM role ARN",
+			user: "arn:aws:iam::123456789012:role/path/to/admin",
+			want: iamARN{
+				account: "123456789012",
+				typ:     "role",
+				name:    "admin",
+				base:    "arn:aws:iam::123456789012:role/path/to/admin",
+			},
+			wantOk: true,
+		},
+		{
+			name: "Check IAM user ARN",
+			user: "arn:aws:iam::123456789012:user/alice",
+			want: iamARN{
+				account: "123456789012",
+				typ:     "user",
+				name:    "alice",
+				base:    "arn:aws:iam::123456789012:user/alice",
+			},
+			wantOk: true,
+		},
+		{
+			name: "Check STS assumed role ARN",
+			user: "arn:aws:sts::123456789012:assumed-role/admin/alice@example.com",
+			want: iamARN{
+				account: "123456789012",
+				typ:     "role",
+				name:    "admin",
+				session: "alice@example.com",
+				base:    "arn:aws:iam::123456789012:role/admin",
+			},
+			wantOk: true,
+		},
+		{
+			name: "Check non ARN user",
+			user: "kubernetes-admin",
+		},
+		{
+			name: "Check non IAM ARN",
+			user: "arn:aws:s3:::bucket/key",
+		},
+		{
+			name: "Check ARN without name",
+			user: "arn:aws:iam::123456789012:role/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseIAMARN(tt.user)
+			if ok != tt.wantOk || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseIAMARN() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestEKSResolve_PrincipalFromUser(t *testing.T) {
+	eks := config.EKS{
+		UserMappings: map[string]string{
+			"arn:aws:iam::123456789012:role/admin": "k8s.admin.eks-admin",
+			"kubernetes-admin":                     "k8s.admin.kubernetes-admin",
+		},
+		ARNTemplate: "aws._account_._type_-_name_",
+		SystemGroupMappings: map[string]string{
+			"system:nodes": "k8s.eks.nodes",
+		},
+	}
+	type args struct {
+		user   string
+		groups []string
+	}
+	tests := []struct {
+		name string
+		eks  config.EKS
+		args args
+		want string
+	}{
+		{
+			name: "Check mapped role ARN",
+			eks:  eks,
+			args: args{
+				user: "arn:aws:iam::123456789012:role/admin",
+			},
+			want: "k8s.admin.eks-admin",
+		},
+		{
+			name: "Check assumed role of mapped role ARN",
+			eks:  eks,
+			args: args{
+				user: "arn:aws:sts::123456789012:assumed-role/admin/alice",
+			},
+			want: "k8s.admin.eks-admin",
+		},
+		{
+			name: "Check mapped aws-auth username",
+			eks:  eks,
+			args: args{
+				user:   "kubernetes-admin",
+				groups: []string{"system:masters"},
+			},
+			want: "k8s.admin.kubernetes-admin",
+		},
+		{
+			name: "Check system group",
+			eks:  eks,
+			args: args{
+				user:   "system:node:ip-10-0-0-1.ec2.internal",
+				groups: []string{"system:bootstrappers", "system:nodes"},
+			},
+			want: "k8s.eks.nodes",
+		},
+		{
+			name: "Check ARN template",
+			eks:  eks,
+			args: args{
+				user: "arn:aws:iam::123456789012:user/bob",
+			},
+			want: "aws.123456789012.user-bob",
+		},
+		{
+			name: "Check ARN without template is not mapped",
+			eks:  config.EKS{},
+			args: args{
+				user: "arn:aws:iam::123456789012:user/bob",
+			},
+			want: "arn:aws:iam::123456789012:user/bob",
+		},
+		{
+			name: "Check other user is mapped as K8s platform",
+			eks:  eks,
+			args: args{
+				user: "alice",
+			},
+			want: "user.alice",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &EKSResolve{
+				resolve{
+					cfg: config.Platform{
+						AthenzUserPrefix: "user.",
+						EKS:              tt.eks,
+					},
+				},
+			}
+			if got := r.PrincipalFromUser(tt.args.user, tt.args.groups); got != tt.want {
+				t.Errorf("EKSResolve.PrincipalFromUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/yahoojapan/garm/config"
+)
+
+// ResolverFactory creates the Resolver of a platform.
+// base is the Resolver of the default mapping rules created from cfg.
+// The platform Resolver should embed base, and override only the methods it changes, e.g. the platform hooks PrincipalFromUser and MapK8sGroups:
+//
+//	type openShiftResolve struct {
+//		service.Resolver
+//	}
+//
+//	func (r *openShiftResolve) PrincipalFromUser(user string, groups []string) string {
+//		return "openshift." + user
+//	}
+//
+//	service.RegisterResolver("openshift", func(base service.Resolver, _ config.Platform) service.Resolver {
+//		return &openShiftResolve{base}
+//	})
+//
+// The methods added to Resolver in later versions are then inherited from base, without changing the platform Resolver.
+type ResolverFactory func(base Resolver, cfg config.Platform) Resolver
+
+var (
+	// resolversMu guards resolvers.
+	resolversMu sync.RWMutex
+	// resolvers maps the platform name to the registered ResolverFactory.
+	resolvers = make(map[string]ResolverFactory)
+)
+
+func init() {
+	RegisterResolver("k8s", func(base Resolver, _ config.Platform) Resolver {
+		return &K8SResolve{*base.(*resolve)}
+	})
+	RegisterResolver("aks", func(base Resolver, _ config.Platform) Resolver {
+		return &AKSResolve{*base.(*resolve)}
+	})
+	RegisterResolver("eks", func(base Resolver, _ config.Platform) Resolver {
+		return &EKSResolve{*base.(*resolve)}
+	})
+}
+
+// RegisterResolver makes the ResolverFactory available for the platform name, i.e. "map_rule.tld.platform.name".
+// It should be called in the init function of the package providing the platform.
+// It panics if name is empty, factory is nil, or name is already registered.
+func RegisterResolver(name string, factory ResolverFactory) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+
+	if name == "" {
+		panic("garm: RegisterResolver platform name is empty")
+	}
+	if factory == nil {
+		panic(fmt.Sprintf("garm: RegisterResolver factory of platform %q is nil", name))
+	}
+	if _, dup := resolvers[name]; dup {
+		panic(fmt.Sprintf("garm: RegisterResolver called twice for platform %q", name))
+	}
+	resolvers[name] = factory
+}
+
+// Platforms returns the sorted names of the registered platforms.
+func Platforms() []string {
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+
+	names := make([]string, 0, len(resolvers))
+	for name := range resolvers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupResolver returns the ResolverFactory registered with the platform name.
+func lookupResolver(name string) (ResolverFactory, bool) {
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+
+	factory, ok := resolvers[name]
+	return factory, ok
+}
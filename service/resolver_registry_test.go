@@ -0,0 +1,109 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yahoojapan/garm/config"
+)
+
+// openShiftResolve is a Resolver of a downstream platform overriding PrincipalFromUser.
+type openShiftResolve struct {
+	Resolver
+}
+
+func (r *openShiftResolve) PrincipalFromUser(user string, groups []string) string {
+	return "openshift." + user
+}
+
+func TestRegisterResolver(t *testing.T) {
+	RegisterResolver("openshift", func(base Resolver, _ config.Platform) Resolver {
+		return &openShiftResolve{base}
+	})
+	defer func() {
+		resolversMu.Lock()
+		delete(resolvers, "openshift")
+		resolversMu.Unlock()
+	}()
+
+	got, err := NewResolver(config.Mapping{
+		TLD: config.TLD{
+			Platform: config.Platform{
+				Name:                 "openshift",
+				ServiceAthenzDomains: []string{"k8s._namespace_"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewResolver() unexpected error: %v", err)
+	}
+	if p := got.PrincipalFromUser("alice", nil); p != "openshift.alice" {
+		t.Errorf("PrincipalFromUser() = %v, want %v", p, "openshift.alice")
+	}
+	if d := got.BuildDomainsFromNamespace("ns"); !reflect.DeepEqual(d, []string{"k8s.ns"}) {
+		t.Errorf("BuildDomainsFromNamespace() = %v, want %v", d, []string{"k8s.ns"})
+	}
+	if names := Platforms(); !reflect.DeepEqual(names, []string{"aks", "eks", "k8s", "openshift"}) {
+		t.Errorf("Platforms() = %v, want %v", names, []string{"aks", "eks", "k8s", "openshift"})
+	}
+}
+
+func TestRegisterResolver_panic(t *testing.T) {
+	factory := func(base Resolver, _ config.Platform) Resolver {
+		return base
+	}
+	tests := []struct {
+		name    string
+		pname   string
+		factory ResolverFactory
+	}{
+		{
+			name:    "Check empty name",
+			pname:   "",
+			factory: factory,
+		},
+		{
+			name:    "Check nil factory",
+			pname:   "nil",
+			factory: nil,
+		},
+		{
+			name:    "Check duplicated name",
+			pname:   "k8s",
+			factory: factory,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterResolver() does not panic")
+				}
+			}()
+			RegisterResolver(tt.pname, tt.factory)
+		})
+	}
+}
+
+func TestPlatforms(t *testing.T) {
+	want := []string{"aks", "eks", "k8s"}
+	if got := Platforms(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Platforms() = %v, want %v", got, want)
+	}
+}
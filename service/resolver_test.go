@@ -17,6 +17,7 @@ limitations under the License.
 package service
 
 import (
+	"fmt"
 	"os"
 	"reflect"
 	"testing"
@@ -29,9 +30,10 @@ func TestNewResolver(t *testing.T) {
 		cfg config.Mapping
 	}
 	tests := []struct {
-		name string
-		args args
-		want Resolver
+		name    string
+		args    args
+		want    Resolver
+		wantErr error
 	}{
 		{
 			name: "Check NewResolver, invalid platform name",
@@ -44,7 +46,68 @@ func TestNewResolver(t *testing.T) {
 					},
 				},
 			},
-			want: &resolve{},
+			wantErr: fmt.Errorf(`unknown platform "invalid", registered platforms: aks, eks, k8s`),
+		},
+		{
+			name: "Check NewResolver, invalid black_list pattern",
+			args: args{
+				cfg: config.Mapping{
+					TLD: config.TLD{
+						Platform: config.Platform{
+							BlackList: []*config.RequestInfo{
+								{Verb: "get"},
+								{Verb: "regex:("},
+							},
+						},
+					},
+				},
+			},
+			wantErr: fmt.Errorf("black_list[1]: invalid pattern \"regex:(\": error parsing regexp: missing closing ): `^(?:()$`"),
+		},
+		{
+			name: "Check NewResolver, invalid rule subresource pattern",
+			args: args{
+				cfg: config.Mapping{
+					TLD: config.TLD{
+						Platform: config.Platform{
+							Rules: []*config.Rule{
+								{Subresource: "regex:["},
+							},
+						},
+					},
+				},
+			},
+			wantErr: fmt.Errorf("rules[0]: invalid pattern \"regex:[\": error parsing regexp: missing closing ]: `[)$`"),
+		},
+		{
+			name: "Check NewResolver, invalid namespace_domains pattern",
+			args: args{
+				cfg: config.Mapping{
+					TLD: config.TLD{
+						Platform: config.Platform{
+							NamespaceDomains: []*config.NamespaceDomain{
+								{Namespace: "regex:("},
+							},
+						},
+					},
+				},
+			},
+			wantErr: fmt.Errorf("namespace_domains[0]: invalid pattern \"regex:(\": error parsing regexp: missing closing ): `^(?:()$`"),
+		},
+		{
+			name: "Check NewResolver, invalid group_mappings pattern",
+			args: args{
+				cfg: config.Mapping{
+					TLD: config.TLD{
+						Platform: config.Platform{
+							GroupMappings: []*config.GroupMapping{
+								{DomainRegex: "("},
+							},
+						},
+					},
+				},
+			},
+			wantErr: fmt.Errorf("group_mappings[0]: invalid pattern \"(\": error parsing regexp: missing closing ): `^(?:()$`"),
 		},
 		{
 			name: "Check NewResolver, platform = k8s",
@@ -117,7 +180,17 @@ func TestNewResolver(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := NewResolver(tt.args.cfg)
+			got, err := NewResolver(tt.args.cfg)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("NewResolver() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("NewResolver() unexpected error: %v", err)
+				return
+			}
 
 			switch v := got.(type) {
 			case *K8SResolve:
@@ -627,6 +700,86 @@ func Test_resolve_BuildDomainsFromNamespace(t *testing.T) {
 			},
 			want: []string{"athenz-<.namespace|-|-.|-----.|-n-s-ns--nn-ss-sss|-183>-domain-200"},
 		},
+		{
+			name: "Check resolve BuildDomainsFromNamespace, namespace override",
+			fields: fields{
+				cfg: config.Platform{
+					NamespaceDomains: []*config.NamespaceDomain{
+						{
+							Namespace: "kube-system",
+							Domains:   []string{"platform.k8s.system"},
+						},
+						{
+							Namespace: "team-*",
+							Domains:   []string{"team._match_.k8s", "k8s._namespace_"},
+						},
+					},
+				},
+				athenzDomains: []string{"k8s._namespace_"},
+			},
+			args: args{
+				namespace: "team-alpha",
+			},
+			want: []string{"team.alpha.k8s", "k8s.team-alpha"},
+		},
+		{
+			name: "Check resolve BuildDomainsFromNamespace, first matched namespace override",
+			fields: fields{
+				cfg: config.Platform{
+					NamespaceDomains: []*config.NamespaceDomain{
+						{
+							Namespace: "kube-*",
+							Domains:   []string{"platform.k8s.system"},
+						},
+						{
+							Namespace: "*",
+							Domains:   []string{"k8s.other"},
+						},
+					},
+				},
+				athenzDomains: []string{"k8s._namespace_"},
+			},
+			args: args{
+				namespace: "kube-public",
+			},
+			want: []string{"platform.k8s.system"},
+		},
+		{
+			name: "Check resolve BuildDomainsFromNamespace, namespace override with regex",
+			fields: fields{
+				cfg: config.Platform{
+					NamespaceDomains: []*config.NamespaceDomain{
+						{
+							Namespace: "regex:(?:team|squad)-([a-z]+)-.*",
+							Domains:   []string{"team._match_.k8s"},
+						},
+					},
+				},
+				athenzDomains: []string{"k8s._namespace_"},
+			},
+			args: args{
+				namespace: "squad-beta-dev",
+			},
+			want: []string{"team.beta.k8s"},
+		},
+		{
+			name: "Check resolve BuildDomainsFromNamespace, namespace override not matched",
+			fields: fields{
+				cfg: config.Platform{
+					NamespaceDomains: []*config.NamespaceDomain{
+						{
+							Namespace: "team-*",
+							Domains:   []string{"team._match_.k8s"},
+						},
+					},
+				},
+				athenzDomains: []string{"k8s._namespace_"},
+			},
+			args: args{
+				namespace: "myteam-alpha",
+			},
+			want: []string{"k8s.myteam-alpha"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1357,6 +1510,49 @@ func Test_resolve_IsAllowed(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "Check resolve IsAllowed black list does not match across the fields",
+			fields: fields{
+				cfg: config.Platform{
+					BlackList: []*config.RequestInfo{
+						{
+							Verb:      "get",
+							Namespace: "kube-*",
+							Resource:  "secrets",
+						}},
+				},
+			},
+			args: args{
+				verb:      "get",
+				namespace: "kube",
+				apiGroup:  "system",
+				resource:  "",
+				name:      "secrets-0",
+			},
+			want: true,
+		},
+		{
+			name: "Check resolve IsAllowed black list matches across the fields with legacy list match",
+			fields: fields{
+				cfg: config.Platform{
+					LegacyListMatch: true,
+					BlackList: []*config.RequestInfo{
+						{
+							Verb:      "get",
+							Namespace: "kube-*",
+							Resource:  "secrets",
+						}},
+				},
+			},
+			args: args{
+				verb:      "get",
+				namespace: "kube",
+				apiGroup:  "system",
+				resource:  "",
+				name:      "secrets-0",
+			},
+			want: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1364,13 +1560,105 @@ func Test_resolve_IsAllowed(t *testing.T) {
 				cfg:           tt.fields.cfg,
 				athenzDomains: tt.fields.athenzDomains,
 			}
-			if got := r.IsAllowed(tt.args.verb, tt.args.namespace, tt.args.apiGroup, tt.args.resource, tt.args.name); got != tt.want {
+			if got := r.IsAllowed(tt.args.verb, tt.args.namespace, tt.args.apiGroup, tt.args.resource, tt.args.name, "", nil); got != tt.want {
 				t.Errorf("resolve.IsAllowed() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func Test_resolve_IsWhiteListed(t *testing.T) {
+	type fields struct {
+		cfg           config.Platform
+		athenzDomains []string
+	}
+	type args struct {
+		verb      string
+		namespace string
+		apiGroup  string
+		resource  string
+		name      string
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want   bool
+	}{
+		{
+			name: "Check resolve IsWhiteListed empty list",
+			fields: fields{
+				cfg: config.Platform{
+					WhiteList: []*config.RequestInfo{},
+				},
+			},
+			args: args{
+				verb:      "get",
+				namespace: "kube-system",
+				apiGroup:  "",
+				resource:  "pods",
+				name:      "pod-1",
+			},
+			want: false,
+		},
+		{
+			name: "Check resolve IsWhiteListed no match",
+			fields: fields{
+				cfg: config.Platform{
+					WhiteList: []*config.RequestInfo{
+						{
+							Verb:      "get",
+							Namespace: "kube-system",
+							Resource:  "pods",
+						},
+					},
+				},
+			},
+			args: args{
+				verb:      "delete",
+				namespace: "kube-system",
+				apiGroup:  "",
+				resource:  "pods",
+				name:      "pod-1",
+			},
+			want: false,
+		},
+		{
+			name: "Check resolve IsWhiteListed has match",
+			fields: fields{
+				cfg: config.Platform{
+					WhiteList: []*config.RequestInfo{
+						{
+							Verb:      "get",
+							Namespace: "kube-system",
+							Resource:  "pods",
+						},
+					},
+				},
+			},
+			args: args{
+				verb:      "get",
+				namespace: "kube-system",
+				apiGroup:  "",
+				resource:  "pods",
+				name:      "",
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolve{
+				cfg:           tt.fields.cfg,
+				athenzDomains: tt.fields.athenzDomains,
+			}
+			if got := r.IsWhiteListed(tt.args.verb, tt.args.namespace, tt.args.apiGroup, tt.args.resource, tt.args.name, "", nil); got != tt.want {
+				t.Errorf("resolve.IsWhiteListed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_resolve_IsAdminAccess(t *testing.T) {
 	type fields struct {
 		cfg           config.Platform
@@ -1464,6 +1752,7 @@ func Test_resolve_IsAdminAccess(t *testing.T) {
 			name: "Check resolve IsAdminAccess regex match",
 			fields: fields{
 				cfg: config.Platform{
+					LegacyListMatch: true,
 					AdminAccessList: []*config.RequestInfo{
 						{
 							Verb:      "verb-461",
@@ -1512,6 +1801,7 @@ func Test_resolve_IsAdminAccess(t *testing.T) {
 			name: "Check resolve IsAdminAccess regex match success after APIGroup replace",
 			fields: fields{
 				cfg: config.Platform{
+					LegacyListMatch: true,
 					AdminAccessList: []*config.RequestInfo{
 						{
 							Verb:      "verb-509",
@@ -1539,13 +1829,201 @@ func Test_resolve_IsAdminAccess(t *testing.T) {
 				cfg:           tt.fields.cfg,
 				athenzDomains: tt.fields.athenzDomains,
 			}
-			if got := r.IsAdminAccess(tt.args.verb, tt.args.namespace, tt.args.apiGroup, tt.args.resource, tt.args.name); got != tt.want {
+			if got := r.IsAdminAccess(tt.args.verb, tt.args.namespace, tt.args.apiGroup, tt.args.resource, tt.args.name, "", nil); got != tt.want {
 				t.Errorf("resolve.IsAdminAccess() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func Test_resolve_MapK8sGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups []string
+		want   []string
+	}{
+		{
+			name:   "Check resolve MapK8sGroups returns groups directly",
+			groups: []string{"system:authenticated"},
+			want:   []string{"system:authenticated"},
+		},
+		{
+			name:   "Check resolve MapK8sGroups nil groups",
+			groups: nil,
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolve{}
+			if got := r.MapK8sGroups(tt.groups); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolve.MapK8sGroups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolve_MapGroups(t *testing.T) {
+	type args struct {
+		domain  string
+		service string
+	}
+	tests := []struct {
+		name string
+		cfg  config.Platform
+		args args
+		want []string
+	}{
+		{
+			name: "Check resolve MapGroups empty list",
+			cfg:  config.Platform{},
+			args: args{
+				domain:  "k8s.admin",
+				service: "user",
+			},
+			want: nil,
+		},
+		{
+			name: "Check resolve MapGroups matched mappings",
+			cfg: config.Platform{
+				GroupMappings: []*config.GroupMapping{
+					{
+						DomainRegex: `.*\.k8s\.admin`,
+						Groups:      []string{"system:masters"},
+					},
+					{
+						Domain: "other",
+						Groups: []string{"other"},
+					},
+					{
+						Groups: []string{"athenz:_domain_", "athenz:_domain_._service_", "system:masters"},
+					},
+				},
+			},
+			args: args{
+				domain:  "team.k8s.admin",
+				service: "user",
+			},
+			want: []string{"system:masters", "athenz:team.k8s.admin", "athenz:team.k8s.admin.user"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolve{
+				cfg: tt.cfg,
+			}
+			if got := r.MapGroups(tt.args.domain, tt.args.service); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolve.MapGroups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolve_MapUserInfo(t *testing.T) {
+	type args struct {
+		domain  string
+		service string
+	}
+	type want struct {
+		username string
+		uid      string
+		extra    map[string][]string
+	}
+	tests := []struct {
+		name string
+		cfg  config.Platform
+		args args
+		want want
+	}{
+		{
+			name: "Check resolve MapUserInfo default templates",
+			cfg:  config.Platform{},
+			args: args{
+				domain:  "k8s.admin",
+				service: "user",
+			},
+			want: want{
+				username: "k8s.admin.user",
+				uid:      "k8s.admin.user",
+			},
+		},
+		{
+			name: "Check resolve MapUserInfo configured templates",
+			cfg: config.Platform{
+				UserMapping: config.UserMapping{
+					Username: "athenz:_domain_:_service_",
+					UID:      "_principal_",
+					Extra: map[string][]string{
+						"athenz-domain": {"_domain_"},
+					},
+				},
+			},
+			args: args{
+				domain:  "k8s.admin",
+				service: "user",
+			},
+			want: want{
+				username: "athenz:k8s.admin:user",
+				uid:      "k8s.admin.user",
+				extra: map[string][]string{
+					"athenz-domain": {"k8s.admin"},
+				},
+			},
+		},
+		{
+			name: "Check resolve MapUserInfo strips Athenz user prefix",
+			cfg: config.Platform{
+				AthenzUserPrefix: "user.",
+				UserMapping: config.UserMapping{
+					Username: "_user_",
+				},
+			},
+			args: args{
+				domain:  "user",
+				service: "alice",
+			},
+			want: want{
+				username: "alice",
+				uid:      "user.alice",
+			},
+		},
+		{
+			name: "Check resolve MapUserInfo keeps principal not in user domain",
+			cfg: config.Platform{
+				AthenzUserPrefix: "user.",
+				UserMapping: config.UserMapping{
+					Username: "_user_",
+				},
+			},
+			args: args{
+				domain:  "k8s.admin",
+				service: "alice",
+			},
+			want: want{
+				username: "k8s.admin.alice",
+				uid:      "k8s.admin.alice",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolve{
+				cfg: tt.cfg,
+			}
+			username, uid, extra := r.MapUserInfo(tt.args.domain, tt.args.service)
+			if username != tt.want.username || uid != tt.want.uid || !reflect.DeepEqual(extra, tt.want.extra) {
+				t.Errorf("resolve.MapUserInfo() = %v, %v, %v, want %v, %v, %v", username, uid, extra, tt.want.username, tt.want.uid, tt.want.extra)
+			}
+			// round trip with PrincipalFromUser
+			if tt.cfg.UserMapping.Username == "_user_" {
+				if got := r.PrincipalFromUser(username, nil); got != uid {
+					t.Errorf("resolve.PrincipalFromUser() = %v, want %v", got, uid)
+				}
+			}
+		})
+	}
+}
+
 func Test_resolve_GetAdminDomain(t *testing.T) {
 	type fields struct {
 		cfg           config.Platform
@@ -1633,3 +2111,410 @@ func Test_resolve_GetAdminDomain(t *testing.T) {
 		})
 	}
 }
+
+func Test_resolve_Decide(t *testing.T) {
+	rules := []*config.Rule{
+		{
+			RequestInfo: config.RequestInfo{
+				Verb:      "get",
+				Namespace: "*",
+				Resource:  "pods",
+				Name:      "*",
+			},
+			Subresource: "*",
+			Action:      config.ActionDefaultDomain,
+		},
+		{
+			RequestInfo: config.RequestInfo{
+				Verb:      "*",
+				Namespace: "*",
+				Resource:  "pods",
+				Name:      "*",
+				Group:     "developers",
+			},
+			Subresource: "exec",
+			Action:      config.ActionCustomDomain,
+			Domains:     []string{"k8s.shell._namespace_"},
+		},
+	}
+	type args struct {
+		verb        string
+		namespace   string
+		apiGroup    string
+		resource    string
+		subresource string
+		name        string
+		user        string
+		groups      []string
+	}
+	tests := []struct {
+		name string
+		cfg  config.Platform
+		args args
+		want *Decision
+	}{
+		{
+			name: "Check resolve Decide, rules disabled",
+			cfg: config.Platform{
+				Rules: rules,
+			},
+			args: args{
+				verb:     "get",
+				resource: "pods",
+			},
+			want: nil,
+		},
+		{
+			name: "Check resolve Decide, first match",
+			cfg: config.Platform{
+				Rules:         rules,
+				DefaultAction: config.ActionDeny,
+			},
+			args: args{
+				verb:        "get",
+				namespace:   "ns",
+				resource:    "pods",
+				subresource: "exec",
+				groups:      []string{"developers"},
+			},
+			want: &Decision{
+				Action: config.ActionDefaultDomain,
+				Rules:  []string{"rules[0]"},
+			},
+		},
+		{
+			name: "Check resolve Decide, custom domain",
+			cfg: config.Platform{
+				Rules:         rules,
+				DefaultAction: config.ActionDeny,
+			},
+			args: args{
+				verb:        "create",
+				namespace:   "ns",
+				resource:    "pods",
+				subresource: "exec",
+				groups:      []string{"developers"},
+			},
+			want: &Decision{
+				Action:  config.ActionCustomDomain,
+				Rules:   []string{"rules[1]"},
+				Domains: []string{"k8s.shell._namespace_"},
+			},
+		},
+		{
+			name: "Check resolve Decide, default action",
+			cfg: config.Platform{
+				Rules:         rules,
+				DefaultAction: config.ActionDeny,
+			},
+			args: args{
+				verb:        "create",
+				namespace:   "ns",
+				resource:    "pods",
+				subresource: "exec",
+				groups:      []string{"viewers"},
+			},
+			want: &Decision{
+				Action: config.ActionDeny,
+				Rules:  []string{ruleDefaultAction},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolve{
+				cfg: tt.cfg,
+			}
+			got := r.Decide(tt.args.verb, tt.args.namespace, tt.args.apiGroup, tt.args.resource, tt.args.subresource, tt.args.name, tt.args.user, tt.args.groups)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolve.Decide() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolve_BuildDomains(t *testing.T) {
+	tests := []struct {
+		name       string
+		domains    []string
+		namespace  string
+		beforeFunc func()
+		afterFunc  func()
+		want       []string
+	}{
+		{
+			name:      "Check resolve BuildDomains",
+			domains:   []string{"k8s.shell._namespace_", "_garm_test_domain_.k8s"},
+			namespace: "ns",
+			beforeFunc: func() {
+				os.Setenv("garm_test_domain", "env")
+			},
+			afterFunc: func() {
+				os.Unsetenv("garm_test_domain")
+			},
+			want: []string{"k8s.shell.ns", "env.k8s"},
+		},
+		{
+			name:    "Check resolve BuildDomains, empty namespace",
+			domains: []string{"k8s.shell._namespace_"},
+			want:    []string{"k8s.shell"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.beforeFunc != nil {
+				tt.beforeFunc()
+			}
+			if tt.afterFunc != nil {
+				defer tt.afterFunc()
+			}
+			r := &resolve{}
+			if got := r.BuildDomains(tt.domains, tt.namespace); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolve.BuildDomains() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolve_IsLocalAllowed(t *testing.T) {
+	whiteList := []*config.RequestInfo{
+		{
+			Verb:     "get",
+			Resource: "/version",
+		},
+	}
+	type args struct {
+		verb     string
+		resource string
+	}
+	tests := []struct {
+		name string
+		cfg  config.Platform
+		args args
+		want bool
+	}{
+		{
+			name: "Check resolve IsLocalAllowed, white listed",
+			cfg: config.Platform{
+				WhiteList:           whiteList,
+				WhiteListLocalAllow: true,
+			},
+			args: args{
+				verb:     "get",
+				resource: "/version",
+			},
+			want: true,
+		},
+		{
+			name: "Check resolve IsLocalAllowed, not white listed",
+			cfg: config.Platform{
+				WhiteList:           whiteList,
+				WhiteListLocalAllow: true,
+			},
+			args: args{
+				verb:     "get",
+				resource: "/healthz",
+			},
+			want: false,
+		},
+		{
+			name: "Check resolve IsLocalAllowed, local allow disabled",
+			cfg: config.Platform{
+				WhiteList: whiteList,
+			},
+			args: args{
+				verb:     "get",
+				resource: "/version",
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolve{
+				cfg: tt.cfg,
+			}
+			if got := r.IsLocalAllowed(tt.args.verb, "", "", tt.args.resource, "", "", nil); got != tt.want {
+				t.Errorf("resolve.IsLocalAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolve_GetResourceTemplate(t *testing.T) {
+	type fields struct {
+		cfg           config.Platform
+		athenzDomains []string
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		want   string
+	}{
+		{
+			name: "Check resolve GetResourceTemplate",
+			fields: fields{
+				cfg: config.Platform{
+					ResourceTemplate: "_domain_:_namespace_._resource_",
+				},
+			},
+			want: "_domain_:_namespace_._resource_",
+		},
+		{
+			name:   "Check resolve GetResourceTemplate, fixed layout",
+			fields: fields{},
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolve{
+				cfg:           tt.fields.cfg,
+				athenzDomains: tt.fields.athenzDomains,
+			}
+			if got := r.GetResourceTemplate(); got != tt.want {
+				t.Errorf("resolve.GetResourceTemplate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolve_MapSubresource(t *testing.T) {
+	type fields struct {
+		cfg config.Platform
+	}
+	type args struct {
+		resource    string
+		subresource string
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want   string
+	}{
+		{
+			name: "Check resolve MapSubresource, resource specific mapping",
+			fields: fields{
+				cfg: config.Platform{
+					SubresourceMappings: map[string]string{
+						"pods/exec": "interactive",
+						"exec":      "shell",
+					},
+				},
+			},
+			args: args{
+				resource:    "pods",
+				subresource: "exec",
+			},
+			want: "interactive",
+		},
+		{
+			name: "Check resolve MapSubresource, any resource mapping",
+			fields: fields{
+				cfg: config.Platform{
+					SubresourceMappings: map[string]string{
+						"pods/exec": "interactive",
+						"exec":      "shell",
+					},
+				},
+			},
+			args: args{
+				resource:    "nodes",
+				subresource: "exec",
+			},
+			want: "shell",
+		},
+		{
+			name: "Check resolve MapSubresource, mapped to empty",
+			fields: fields{
+				cfg: config.Platform{
+					SubresourceMappings: map[string]string{
+						"status": "",
+					},
+				},
+			},
+			args: args{
+				resource:    "pods",
+				subresource: "status",
+			},
+			want: "",
+		},
+		{
+			name: "Check resolve MapSubresource, not mapped",
+			fields: fields{
+				cfg: config.Platform{
+					SubresourceMappings: map[string]string{
+						"pods/exec": "interactive",
+					},
+				},
+			},
+			args: args{
+				resource:    "pods",
+				subresource: "log",
+			},
+			want: "log",
+		},
+		{
+			name: "Check resolve MapSubresource, empty subresource is not mapped",
+			fields: fields{
+				cfg: config.Platform{
+					SubresourceMappings: map[string]string{
+						"": "dummy",
+					},
+				},
+			},
+			args: args{
+				resource:    "pods",
+				subresource: "",
+			},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolve{
+				cfg: tt.fields.cfg,
+			}
+			if got := r.MapSubresource(tt.args.resource, tt.args.subresource); got != tt.want {
+				t.Errorf("resolve.MapSubresource() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolve_IsSubresourceAsAction(t *testing.T) {
+	type fields struct {
+		cfg config.Platform
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		want   bool
+	}{
+		{
+			name: "Check resolve IsSubresourceAsAction, enabled",
+			fields: fields{
+				cfg: config.Platform{
+					SubresourceAsAction: true,
+				},
+			},
+			want: true,
+		},
+		{
+			name:   "Check resolve IsSubresourceAsAction, disabled",
+			fields: fields{},
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolve{
+				cfg: tt.fields.cfg,
+			}
+			if got := r.IsSubresourceAsAction(); got != tt.want {
+				t.Errorf("resolve.IsSubresourceAsAction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -19,8 +19,10 @@ package service
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	webhook "github.com/yahoo/k8s-athenz-webhook"
+	"github.com/yahoojapan/garm/config"
 	authz "k8s.io/api/authorization/v1beta1"
 )
 
@@ -45,6 +47,24 @@ type athenzAccessCheckParam struct {
 	name        string
 	adminDomain string
 	domains     []string
+	// namespace and subresource are only used by the resource template.
+	namespace   string
+	subresource string
+}
+
+// requestAttributes is the K8s request attributes after mapping.
+type requestAttributes struct {
+	verb      string
+	namespace string
+	group     string
+	resource  string
+	name      string
+	user      string
+	groups    []string
+	// baseResource is the mapped K8s resource without the subresource.
+	baseResource string
+	// subresource is the K8s subresource.
+	subresource string
 }
 
 // NewResourceMapper creates a new ResourceMapper for mapping K8s resources to Athenz principals.
@@ -55,14 +75,104 @@ func NewResourceMapper(resolver Resolver) ResourceMapper {
 }
 
 // MapResource maps K8s access request object to Athenz access request object.
-// 1. check is non-resources group or not
-// 2. replace the value based on internal resolver configuration according
-// 3. value mapping using internal resolver
-// 4. get Athenz domains
-// 5. get Athenz user
-// 6. create Athenz principal based on internal resolver configuration (directly reject, admin domain, user domain)
+// 1. map the request attributes (see mapAttributes)
+// 2. create Athenz principal based on internal resolver configuration
+// 3. decide the action of the request (see decide)
+// 4. create Athenz access checks by the action (directly reject, directly allow, admin domain, service domains, custom domains)
 func (m *resourceMapper) MapResource(ctx context.Context, spec authz.SubjectAccessReviewSpec) (string, []webhook.AthenzAccessCheck, error) {
+	a := m.mapAttributes(spec)
+
+	identity := m.res.PrincipalFromUser(a.user, a.groups)
+	d := m.decide(spec, a)
+	mp := mapping{
+		spec:     spec,
+		identity: identity,
+		action:   d.Action,
+		rules:    d.Rules,
+	}
+	param := athenzAccessCheckParam{
+		action:   m.res.MapVerbAction(a.verb),
+		group:    m.res.MapAPIGroup(a.group),
+		resource: m.res.MapK8sResourceAthenzResource(a.resource),
+		name:     m.res.MapResourceName(a.name),
+		domains:  m.res.BuildDomainsFromNamespace(a.namespace),
+	}
+	if m.res.GetResourceTemplate() != "" {
+		param.resource = a.baseResource
+		param.namespace = a.namespace
+		param.subresource = a.subresource
+	}
+
+	switch d.Action {
+	case config.ActionDeny:
+		traceFrom(ctx).mapped(mp)
+		return "", nil,
+			fmt.Errorf(
+				"----%s's request is not allowed----\nVerb:\t%s\nNamespace:\t%s\nAPI Group:\t%s\nResource:\t%s\nResource Name:\t%s\n",
+				identity, a.verb, a.namespace, a.group, a.resource, a.name)
+	case config.ActionAllowBypassAthenz:
+		// no Athenz access checks, the webhook allows the request directly
+		traceFrom(ctx).mapped(mp)
+		return identity, nil, nil
+	case config.ActionAdminDomain:
+		param.adminDomain = m.res.GetAdminDomain(a.namespace)
+		mp.checks = m.createAdminAccessCheck(param)
+	case config.ActionCustomDomain:
+		param.domains = m.res.BuildDomains(d.Domains, a.namespace)
+		mp.checks = m.createAccessCheck(param)
+	default:
+		mp.checks = m.createAccessCheck(param)
+	}
+	traceFrom(ctx).mapped(mp)
+	return identity, mp.checks, nil
+}
+
+// decide returns the Decision of the request.
+// If the ordered rules are enabled, the K8s request attributes before mapping are matched against the rules,
+// else the mapped request attributes are matched against the lists.
+// 1. in white_list AND white_list_local_allow => directly allow
+// 2. in black_list AND NOT in white_list => directly reject
+// 3. in admin_access_list => admin domain
+// 4. else => service domains
+func (m *resourceMapper) decide(spec authz.SubjectAccessReviewSpec, a requestAttributes) *Decision {
 	var verb, namespace, group, resource, sub, name string
+	if ra := spec.ResourceAttributes; ra != nil {
+		verb, namespace, group, resource, sub, name = ra.Verb, ra.Namespace, ra.Group, ra.Resource, ra.Subresource, ra.Name
+	} else if nra := spec.NonResourceAttributes; nra != nil {
+		verb, resource = nra.Verb, nra.Path
+	}
+	if d := m.res.Decide(verb, namespace, group, resource, sub, name, a.user, a.groups); d != nil {
+		return d
+	}
+
+	d := &Decision{
+		Action: config.ActionDefaultDomain,
+	}
+	if m.res.IsWhiteListed(a.verb, a.namespace, a.group, a.resource, a.name, a.user, a.groups) {
+		d.Rules = append(d.Rules, ruleWhiteList)
+	}
+	switch {
+	case m.res.IsLocalAllowed(a.verb, a.namespace, a.group, a.resource, a.name, a.user, a.groups):
+		d.Action = config.ActionAllowBypassAthenz
+	case !m.res.IsAllowed(a.verb, a.namespace, a.group, a.resource, a.name, a.user, a.groups):
+		d.Action = config.ActionDeny
+		d.Rules = append(d.Rules, ruleBlackList)
+	case m.res.IsAdminAccess(a.verb, a.namespace, a.group, a.resource, a.name, a.user, a.groups):
+		d.Action = config.ActionAdminDomain
+		d.Rules = append(d.Rules, ruleAdminAccessList)
+	}
+	return d
+}
+
+// mapAttributes extracts the K8s request attributes from spec, and maps them using internal resolver.
+// 1. check is non-resources group or not
+// 2. replace the value based on internal resolver configuration according
+// 3. map the subresource, and move it to the action if subresource_as_action is enabled
+// 4. value mapping using internal resolver
+// 5. map the K8s groups using internal resolver
+func (m *resourceMapper) mapAttributes(spec authz.SubjectAccessReviewSpec) requestAttributes {
+	var verb, namespace, group, resource, base, sub, name string
+	var action string
 
 	if spec.ResourceAttributes != nil {
 		name = spec.ResourceAttributes.Name
@@ -71,8 +181,13 @@ func (m *resourceMapper) MapResource(ctx context.Context, spec authz.SubjectAcce
 			namespace = m.res.GetEmptyNamespace()
 		}
 		verb = spec.ResourceAttributes.Verb
-		resource = spec.ResourceAttributes.Resource
-		sub = spec.ResourceAttributes.Subresource
+		base = spec.ResourceAttributes.Resource
+		sub = m.res.MapSubresource(base, spec.ResourceAttributes.Subresource)
+		if sub != "" && m.res.IsSubresourceAsAction() {
+			action = sub
+			sub = ""
+		}
+		resource = base
 		if sub != "" {
 			resource = fmt.Sprintf("%s.%s", resource, sub)
 		}
@@ -81,47 +196,43 @@ func (m *resourceMapper) MapResource(ctx context.Context, spec authz.SubjectAcce
 		group = m.res.GetNonResourceGroup()
 		verb = spec.NonResourceAttributes.Verb
 		resource = spec.NonResourceAttributes.Path
+		base = resource
 		namespace = m.res.GetNonResourceNamespace()
 	}
 
-	verb = m.res.MapVerbAction(verb)
-	resource = m.res.MapK8sResourceAthenzResource(resource)
-	group = m.res.MapAPIGroup(group)
-	name = m.res.MapResourceName(name)
-
-	identity := m.res.PrincipalFromUser(spec.User, spec.Groups)
+	if action == "" {
+		action = m.res.MapVerbAction(verb)
+	}
 
-	switch {
-	case !m.res.IsAllowed(verb, namespace, group, resource, name): // Not Allowed
-		return "", nil,
-			fmt.Errorf(
-				"----%s's request is not allowed----\nVerb:\t%s\nNamespaceb:\t%s\nAPI Group:\t%s\nResource:\t%s\nResource Name:\t%s\n",
-				identity, verb, namespace, group, resource, name)
-	case m.res.IsAdminAccess(verb, namespace, group, resource, name):
-		return identity, m.createAdminAccessCheck(
-			athenzAccessCheckParam{
-				action:      m.res.MapVerbAction(verb),
-				group:       m.res.MapAPIGroup(group),
-				resource:    m.res.MapK8sResourceAthenzResource(resource),
-				name:        m.res.MapResourceName(name),
-				adminDomain: m.res.GetAdminDomain(namespace),
-				domains:     m.res.BuildDomainsFromNamespace(namespace),
-			}), nil
-	default:
-		return identity, m.createAccessCheck(
-			athenzAccessCheckParam{
-				action:   m.res.MapVerbAction(verb),
-				group:    m.res.MapAPIGroup(group),
-				resource: m.res.MapK8sResourceAthenzResource(resource),
-				name:     m.res.MapResourceName(name),
-				domains:  m.res.BuildDomainsFromNamespace(namespace),
-			}), nil
+	return requestAttributes{
+		verb:         action,
+		namespace:    namespace,
+		group:        m.res.MapAPIGroup(group),
+		resource:     m.res.MapK8sResourceAthenzResource(resource),
+		name:         m.res.MapResourceName(name),
+		user:         spec.User,
+		groups:       m.res.MapK8sGroups(spec.Groups),
+		baseResource: m.res.MapK8sResourceAthenzResource(base),
+		subresource:  sub,
 	}
 }
 
 // createAdminAccessCheck returns AthenzAccessChecks for admin domain.
 // Returns an array of the form "[ adminDomain+domain_0, adminDomain+domain_1 ... adminDomain ]"
+// If the resource template is set, "_domain_" is replaced with adminDomain, and "_service_domain_" is replaced with each domain, and then "".
+// The same Athenz resources are checked only once, e.g. the template does not contain "_service_domain_".
 func (m *resourceMapper) createAdminAccessCheck(accessCheckParam athenzAccessCheckParam) []webhook.AthenzAccessCheck {
+	if tmpl := m.res.GetResourceTemplate(); tmpl != "" {
+		accessChecks := make([]webhook.AthenzAccessCheck, 0, len(accessCheckParam.domains)+1)
+		for _, domain := range append(accessCheckParam.domains[:len(accessCheckParam.domains):len(accessCheckParam.domains)], "") {
+			ac := m.createTemplateAccessCheck(tmpl, accessCheckParam.adminDomain, domain, accessCheckParam)
+			if !containsAccessCheck(accessChecks, ac) {
+				accessChecks = append(accessChecks, ac)
+			}
+		}
+		return accessChecks
+	}
+
 	accessChecks := make([]webhook.AthenzAccessCheck, len(accessCheckParam.domains)+1)
 	for i, domain := range accessCheckParam.domains {
 		accessChecks[i] = webhook.AthenzAccessCheck{
@@ -137,8 +248,15 @@ func (m *resourceMapper) createAdminAccessCheck(accessCheckParam athenzAccessChe
 }
 
 // createAdminAccessCheck returns AthenzAccessChecks for service domains.
+// If the resource template is set, both "_domain_" and "_service_domain_" are replaced with each domain.
 func (m *resourceMapper) createAccessCheck(accessCheckParam athenzAccessCheckParam) []webhook.AthenzAccessCheck {
 	accessChecks := make([]webhook.AthenzAccessCheck, 0, len(accessCheckParam.domains))
+	if tmpl := m.res.GetResourceTemplate(); tmpl != "" {
+		for _, domain := range accessCheckParam.domains {
+			accessChecks = append(accessChecks, m.createTemplateAccessCheck(tmpl, domain, domain, accessCheckParam))
+		}
+		return accessChecks
+	}
 	for _, domain := range accessCheckParam.domains {
 		accessChecks = append(accessChecks,
 			webhook.AthenzAccessCheck{
@@ -148,3 +266,31 @@ func (m *resourceMapper) createAccessCheck(accessCheckParam athenzAccessCheckPar
 	}
 	return accessChecks
 }
+
+// createTemplateAccessCheck returns AthenzAccessCheck with the Athenz resource created by replacing the placeholders in tmpl.
+// Unlike the fixed layout, the Athenz resource is not trimmed by TrimResource.
+func (m *resourceMapper) createTemplateAccessCheck(tmpl, domain, serviceDomain string, accessCheckParam athenzAccessCheckParam) webhook.AthenzAccessCheck {
+	return webhook.AthenzAccessCheck{
+		Resource: strings.NewReplacer(
+			"_domain_", domain,
+			"_service_domain_", serviceDomain,
+			"_namespace_", accessCheckParam.namespace,
+			"_api_group_", accessCheckParam.group,
+			"_resource_", accessCheckParam.resource,
+			"_subresource_", accessCheckParam.subresource,
+			"_name_", accessCheckParam.name,
+			"_verb_", accessCheckParam.action,
+		).Replace(tmpl),
+		Action: accessCheckParam.action,
+	}
+}
+
+// containsAccessCheck returns true if accessChecks contains ac.
+func containsAccessCheck(accessChecks []webhook.AthenzAccessCheck, ac webhook.AthenzAccessCheck) bool {
+	for _, c := range accessChecks {
+		if c == ac {
+			return true
+		}
+	}
+	return false
+}
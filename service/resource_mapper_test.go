@@ -438,7 +438,759 @@ func Test_resourceMapper_MapResource(t *testing.T) {
 			wantIdentity:           "",
 			wantAthenzAccessChecks: nil,
 			wantError: fmt.Errorf(
-				"----user-322's request is not allowed----\nVerb:\tverb-317\nNamespaceb:\tnamespace-316\nAPI Group:\tgroup-320\nResource:\tresource-318.sub-resource-319\nResource Name:\tname-315\n"),
+				"----user-322's request is not allowed----\nVerb:\tverb-317\nNamespace:\tnamespace-316\nAPI Group:\tgroup-320\nResource:\tresource-318.sub-resource-319\nResource Name:\tname-315\n"),
+		},
+		{
+			name: "Check resourceMapper MapResource, black list exception by group in white list",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s"},
+					cfg: config.Platform{
+						BlackList: []*config.RequestInfo{
+							{
+								Verb:      "delete",
+								Namespace: "*",
+								APIGroup:  "*",
+								Resource:  "nodes",
+								Name:      "*",
+							},
+						},
+						WhiteList: []*config.RequestInfo{
+							{
+								Verb:      "delete",
+								Namespace: "*",
+								APIGroup:  "*",
+								Resource:  "nodes",
+								Name:      "*",
+								Group:     "system:masters",
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace: "ns",
+						Verb:      "delete",
+						Resource:  "nodes",
+					},
+					User:   "admin.user",
+					Groups: []string{"system:authenticated", "system:masters"},
+				},
+			},
+			wantIdentity: "admin.user",
+			wantAthenzAccessChecks: []webhook.AthenzAccessCheck{
+				{
+					Resource: "k8s:nodes",
+					Action:   "delete",
+				},
+			},
+			wantError: nil,
+		},
+		{
+			name: "Check resourceMapper MapResource, black list without group exception",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s"},
+					cfg: config.Platform{
+						BlackList: []*config.RequestInfo{
+							{
+								Verb:      "delete",
+								Namespace: "*",
+								APIGroup:  "*",
+								Resource:  "nodes",
+								Name:      "*",
+							},
+						},
+						WhiteList: []*config.RequestInfo{
+							{
+								Verb:      "delete",
+								Namespace: "*",
+								APIGroup:  "*",
+								Resource:  "nodes",
+								Name:      "*",
+								Group:     "system:masters",
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace: "ns",
+						Verb:      "delete",
+						Resource:  "nodes",
+					},
+					User:   "normal.user",
+					Groups: []string{"system:authenticated"},
+				},
+			},
+			wantIdentity:           "",
+			wantAthenzAccessChecks: nil,
+			wantError: fmt.Errorf(
+				"----normal.user's request is not allowed----\nVerb:\tdelete\nNamespace:\tns\nAPI Group:\t\nResource:\tnodes\nResource Name:\t\n"),
+		},
+		{
+			name: "Check resourceMapper MapResource, admin access only for user pattern",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s"},
+					cfg: config.Platform{
+						AdminAthenzDomain: "k8s.admin",
+						AdminAccessList: []*config.RequestInfo{
+							{
+								Verb:      "*",
+								Namespace: "*",
+								APIGroup:  "*",
+								Resource:  "*",
+								Name:      "*",
+								User:      "system:serviceaccount:kube-system:*",
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace: "kube-system",
+						Verb:      "get",
+						Resource:  "pods",
+					},
+					User: "system:serviceaccount:kube-system:default",
+				},
+			},
+			wantIdentity: "system:serviceaccount:kube-system:default",
+			wantAthenzAccessChecks: []webhook.AthenzAccessCheck{
+				{
+					Resource: "k8s.admin:k8s.pods",
+					Action:   "get",
+				},
+				{
+					Resource: "k8s.admin:pods",
+					Action:   "get",
+				},
+			},
+			wantError: nil,
+		},
+		{
+			name: "Check resourceMapper MapResource, white list local allow",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s"},
+					cfg: config.Platform{
+						NonResourceNamespace: "nonres",
+						WhiteList: []*config.RequestInfo{
+							{
+								Verb:      "get",
+								Namespace: "*",
+								Resource:  "regex:/healthz|/version",
+							},
+						},
+						BlackList: []*config.RequestInfo{
+							{
+								Verb:      "*",
+								Namespace: "*",
+								Resource:  "*",
+							},
+						},
+						WhiteListLocalAllow: true,
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					NonResourceAttributes: &authz.NonResourceAttributes{
+						Verb: "get",
+						Path: "/version",
+					},
+					User: "user.name",
+				},
+			},
+			wantIdentity:           "user.name",
+			wantAthenzAccessChecks: nil,
+			wantError:              nil,
+		},
+		{
+			name: "Check resourceMapper MapResource, rules allow bypass Athenz",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s._namespace_"},
+					cfg: config.Platform{
+						AdminAthenzDomain: "k8s.admin",
+						Rules: []*config.Rule{
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:     "get",
+									Resource: "/healthz",
+								},
+								Action: config.ActionAllowBypassAthenz,
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "*",
+									Namespace: "*",
+									Resource:  "secrets",
+									Name:      "*",
+								},
+								Action: config.ActionDeny,
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "create",
+									Namespace: "*",
+									Resource:  "pods",
+									Name:      "*",
+								},
+								Subresource: "exec",
+								Action:      config.ActionCustomDomain,
+								Domains:     []string{"k8s.shell._namespace_"},
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "*",
+									Namespace: "kube-system",
+									Resource:  "*",
+									Name:      "*",
+								},
+								Action: config.ActionAdminDomain,
+							},
+						},
+						DefaultAction: config.ActionDefaultDomain,
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					NonResourceAttributes: &authz.NonResourceAttributes{
+						Verb: "get",
+						Path: "/healthz",
+					},
+					User: "user.name",
+				},
+			},
+			wantIdentity:           "user.name",
+			wantAthenzAccessChecks: nil,
+			wantError:              nil,
+		},
+		{
+			name: "Check resourceMapper MapResource, rules deny",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s._namespace_"},
+					cfg: config.Platform{
+						AdminAthenzDomain: "k8s.admin",
+						Rules: []*config.Rule{
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:     "get",
+									Resource: "/healthz",
+								},
+								Action: config.ActionAllowBypassAthenz,
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "*",
+									Namespace: "*",
+									Resource:  "secrets",
+									Name:      "*",
+								},
+								Action: config.ActionDeny,
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "create",
+									Namespace: "*",
+									Resource:  "pods",
+									Name:      "*",
+								},
+								Subresource: "exec",
+								Action:      config.ActionCustomDomain,
+								Domains:     []string{"k8s.shell._namespace_"},
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "*",
+									Namespace: "kube-system",
+									Resource:  "*",
+									Name:      "*",
+								},
+								Action: config.ActionAdminDomain,
+							},
+						},
+						DefaultAction: config.ActionDefaultDomain,
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace: "kube-system",
+						Verb:      "get",
+						Resource:  "secrets",
+						Name:      "token",
+					},
+					User: "user.name",
+				},
+			},
+			wantIdentity:           "",
+			wantAthenzAccessChecks: nil,
+			wantError: fmt.Errorf(
+				"----user.name's request is not allowed----\nVerb:\tget\nNamespace:\tkube-system\nAPI Group:\t\nResource:\tsecrets\nResource Name:\t\n"),
+		},
+		{
+			name: "Check resourceMapper MapResource, rules custom domain",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s._namespace_"},
+					cfg: config.Platform{
+						AdminAthenzDomain: "k8s.admin",
+						Rules: []*config.Rule{
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:     "get",
+									Resource: "/healthz",
+								},
+								Action: config.ActionAllowBypassAthenz,
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "*",
+									Namespace: "*",
+									Resource:  "secrets",
+									Name:      "*",
+								},
+								Action: config.ActionDeny,
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "create",
+									Namespace: "*",
+									Resource:  "pods",
+									Name:      "*",
+								},
+								Subresource: "exec",
+								Action:      config.ActionCustomDomain,
+								Domains:     []string{"k8s.shell._namespace_"},
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "*",
+									Namespace: "kube-system",
+									Resource:  "*",
+									Name:      "*",
+								},
+								Action: config.ActionAdminDomain,
+							},
+						},
+						DefaultAction: config.ActionDefaultDomain,
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace:   "ns",
+						Verb:        "create",
+						Resource:    "pods",
+						Subresource: "exec",
+						Name:        "pod",
+					},
+					User: "user.name",
+				},
+			},
+			wantIdentity: "user.name",
+			wantAthenzAccessChecks: []webhook.AthenzAccessCheck{
+				{
+					Resource: "k8s.shell.ns:pods.exec",
+					Action:   "create",
+				},
+			},
+			wantError: nil,
+		},
+		{
+			name: "Check resourceMapper MapResource, rules admin domain",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s._namespace_"},
+					cfg: config.Platform{
+						AdminAthenzDomain: "k8s.admin",
+						Rules: []*config.Rule{
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:     "get",
+									Resource: "/healthz",
+								},
+								Action: config.ActionAllowBypassAthenz,
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "*",
+									Namespace: "*",
+									Resource:  "secrets",
+									Name:      "*",
+								},
+								Action: config.ActionDeny,
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "create",
+									Namespace: "*",
+									Resource:  "pods",
+									Name:      "*",
+								},
+								Subresource: "exec",
+								Action:      config.ActionCustomDomain,
+								Domains:     []string{"k8s.shell._namespace_"},
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "*",
+									Namespace: "kube-system",
+									Resource:  "*",
+									Name:      "*",
+								},
+								Action: config.ActionAdminDomain,
+							},
+						},
+						DefaultAction: config.ActionDefaultDomain,
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace: "kube-system",
+						Verb:      "get",
+						Resource:  "pods",
+					},
+					User: "user.name",
+				},
+			},
+			wantIdentity: "user.name",
+			wantAthenzAccessChecks: []webhook.AthenzAccessCheck{
+				{
+					Resource: "k8s.admin:k8s.kube-system.pods",
+					Action:   "get",
+				},
+				{
+					Resource: "k8s.admin:pods",
+					Action:   "get",
+				},
+			},
+			wantError: nil,
+		},
+		{
+			name: "Check resourceMapper MapResource, rules default action",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s._namespace_"},
+					cfg: config.Platform{
+						AdminAthenzDomain: "k8s.admin",
+						Rules: []*config.Rule{
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:     "get",
+									Resource: "/healthz",
+								},
+								Action: config.ActionAllowBypassAthenz,
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "*",
+									Namespace: "*",
+									Resource:  "secrets",
+									Name:      "*",
+								},
+								Action: config.ActionDeny,
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "create",
+									Namespace: "*",
+									Resource:  "pods",
+									Name:      "*",
+								},
+								Subresource: "exec",
+								Action:      config.ActionCustomDomain,
+								Domains:     []string{"k8s.shell._namespace_"},
+							},
+							{
+								RequestInfo: config.RequestInfo{
+									Verb:      "*",
+									Namespace: "kube-system",
+									Resource:  "*",
+									Name:      "*",
+								},
+								Action: config.ActionAdminDomain,
+							},
+						},
+						DefaultAction: config.ActionDefaultDomain,
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace:   "ns",
+						Verb:        "get",
+						Resource:    "pods",
+						Subresource: "log",
+					},
+					User: "user.name",
+				},
+			},
+			wantIdentity: "user.name",
+			wantAthenzAccessChecks: []webhook.AthenzAccessCheck{
+				{
+					Resource: "k8s.ns:pods.log",
+					Action:   "get",
+				},
+			},
+			wantError: nil,
+		},
+		{
+			name: "Check resourceMapper MapResource, resource template",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s._namespace_"},
+					cfg: config.Platform{
+						ResourceTemplate: "_domain_:_api_group_._resource_/_subresource_@_name_",
+						ResourceMappings: map[string]string{
+							"pods": "pod",
+						},
+						APIGroupControlEnabled:     true,
+						ResourceNameControlEnabled: true,
+						WhiteList: []*config.RequestInfo{
+							{
+								Verb: "*",
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace:   "ns",
+						Verb:        "create",
+						Group:       "core",
+						Resource:    "pods",
+						Subresource: "exec",
+						Name:        "nginx",
+					},
+					User: "user.name",
+				},
+			},
+			wantIdentity: "user.name",
+			wantAthenzAccessChecks: []webhook.AthenzAccessCheck{
+				{
+					Resource: "k8s.ns:core.pod/exec@nginx",
+					Action:   "create",
+				},
+			},
+			wantError: nil,
+		},
+		{
+			name: "Check resourceMapper MapResource, resource template, admin access",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s._namespace_"},
+					cfg: config.Platform{
+						ResourceTemplate:  "_domain_:_service_domain_._namespace_._resource_._verb_",
+						AdminAthenzDomain: "k8s.admin",
+						AdminAccessList: []*config.RequestInfo{
+							{
+								Verb:      "*",
+								Namespace: "kube-system",
+								Resource:  "*",
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace: "kube-system",
+						Verb:      "get",
+						Resource:  "secrets",
+					},
+					User: "user.name",
+				},
+			},
+			wantIdentity: "user.name",
+			wantAthenzAccessChecks: []webhook.AthenzAccessCheck{
+				{
+					Resource: "k8s.admin:k8s.kube-system.kube-system.secrets.get",
+					Action:   "get",
+				},
+				{
+					Resource: "k8s.admin:.kube-system.secrets.get",
+					Action:   "get",
+				},
+			},
+			wantError: nil,
+		},
+		{
+			name: "Check resourceMapper MapResource, resource template, admin access, without _service_domain_",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s._namespace_", "k8s.sub._namespace_"},
+					cfg: config.Platform{
+						ResourceTemplate:  "_domain_:_namespace_._resource_",
+						AdminAthenzDomain: "k8s.admin",
+						AdminAccessList: []*config.RequestInfo{
+							{
+								Verb:      "*",
+								Namespace: "*",
+								Resource:  "*",
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace: "ns",
+						Verb:      "get",
+						Resource:  "secrets",
+					},
+					User: "user.name",
+				},
+			},
+			wantIdentity: "user.name",
+			wantAthenzAccessChecks: []webhook.AthenzAccessCheck{
+				{
+					Resource: "k8s.admin:ns.secrets",
+					Action:   "get",
+				},
+			},
+			wantError: nil,
+		},
+		{
+			name: "Check resourceMapper MapResource, subresource mapping",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s._namespace_"},
+					cfg: config.Platform{
+						SubresourceMappings: map[string]string{
+							"pods/exec":        "interactive",
+							"pods/attach":      "interactive",
+							"pods/portforward": "interactive",
+						},
+						ResourceMappings: map[string]string{
+							"pods.interactive": "pods-interactive",
+						},
+						WhiteList: []*config.RequestInfo{
+							{
+								Verb: "*",
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace:   "ns",
+						Verb:        "create",
+						Resource:    "pods",
+						Subresource: "attach",
+					},
+					User: "user.name",
+				},
+			},
+			wantIdentity: "user.name",
+			wantAthenzAccessChecks: []webhook.AthenzAccessCheck{
+				{
+					Resource: "k8s.ns:pods-interactive",
+					Action:   "create",
+				},
+			},
+			wantError: nil,
+		},
+		{
+			name: "Check resourceMapper MapResource, subresource as action",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s._namespace_"},
+					cfg: config.Platform{
+						SubresourceAsAction: true,
+						VerbMappings: map[string]string{
+							"create": "write",
+						},
+						WhiteList: []*config.RequestInfo{
+							{
+								Verb: "*",
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace:   "ns",
+						Verb:        "create",
+						Resource:    "pods",
+						Subresource: "exec",
+					},
+					User: "user.name",
+				},
+			},
+			wantIdentity: "user.name",
+			wantAthenzAccessChecks: []webhook.AthenzAccessCheck{
+				{
+					Resource: "k8s.ns:pods",
+					Action:   "exec",
+				},
+			},
+			wantError: nil,
+		},
+		{
+			name: "Check resourceMapper MapResource, subresource as action, subresource mapped to empty",
+			fields: fields{
+				res: &resolve{
+					athenzDomains: []string{"k8s._namespace_"},
+					cfg: config.Platform{
+						SubresourceAsAction: true,
+						SubresourceMappings: map[string]string{
+							"status": "",
+						},
+						VerbMappings: map[string]string{
+							"update": "write",
+						},
+						WhiteList: []*config.RequestInfo{
+							{
+								Verb: "*",
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				spec: authz.SubjectAccessReviewSpec{
+					ResourceAttributes: &authz.ResourceAttributes{
+						Namespace:   "ns",
+						Verb:        "update",
+						Resource:    "pods",
+						Subresource: "status",
+					},
+					User: "user.name",
+				},
+			},
+			wantIdentity: "user.name",
+			wantAthenzAccessChecks: []webhook.AthenzAccessCheck{
+				{
+					Resource: "k8s.ns:pods",
+					Action:   "write",
+				},
+			},
+			wantError: nil,
 		},
 	}
 	for _, tt := range tests {
@@ -20,12 +20,15 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kpango/glg"
 	"github.com/pkg/errors"
 	"github.com/yahoojapan/garm/config"
+	"github.com/yahoojapan/garm/metrics"
 )
 
 // Server represents a Garm server behaviour.
@@ -33,6 +36,12 @@ type Server interface {
 	ListenAndServe(context.Context) chan []error
 }
 
+// ReadinessChecker represents a dependency which must be ready before Garm handles webhook requests.
+type ReadinessChecker interface {
+	// Ready returns nil if ready, otherwise returns the reason.
+	Ready() error
+}
+
 type server struct {
 	// Webhook server
 	srv        *http.Server
@@ -52,6 +61,12 @@ type server struct {
 
 	// mutex lock variable
 	mu *sync.RWMutex
+
+	// checks are the dependencies checked by the readiness probe
+	checks []ReadinessChecker
+
+	// shuttingDown is set to 1 when the shutdown begins
+	shuttingDown int32
 }
 
 const (
@@ -76,7 +91,10 @@ var (
 //
 // The health check server is a http.Server instance, which the port number is read from "config.Server.HealthzPort"
 // , and its handler always return HTTP Status OK (200) response on HTTP GET request.
-func NewServer(cfg config.Server, h http.Handler) Server {
+// It also serves the liveness probe on "config.Server.LivenessPath", the readiness probe on "config.Server.ReadinessPath"
+// , and the Prometheus metrics on "config.Server.MetricsPath".
+// The readiness probe returns HTTP Status OK (200) only if all the given checks are ready and the server is not shutting down.
+func NewServer(cfg config.Server, h http.Handler, checks ...ReadinessChecker) Server {
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.Port),
 		Handler: h,
@@ -84,8 +102,7 @@ func NewServer(cfg config.Server, h http.Handler) Server {
 	srv.SetKeepAlivesEnabled(true)
 
 	hcsrv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.HealthzPort),
-		Handler: createHealthCheckServiceMux(cfg.HealthzPath),
+		Addr: fmt.Sprintf(":%d", cfg.HealthzPort),
 	}
 	hcsrv.SetKeepAlivesEnabled(true)
 
@@ -107,19 +124,22 @@ func NewServer(cfg config.Server, h http.Handler) Server {
 		}
 	}
 
-	return &server{
-		srv:   srv,
-		hcsrv: hcsrv,
-		cfg:   cfg,
-		pwt:   pwt,
-		sddur: dur,
-		mu:    &sync.RWMutex{},
+	s := &server{
+		srv:    srv,
+		hcsrv:  hcsrv,
+		cfg:    cfg,
+		pwt:    pwt,
+		sddur:  dur,
+		mu:     &sync.RWMutex{},
+		checks: checks,
 	}
+	hcsrv.Handler = createHealthCheckServiceMux(cfg, http.HandlerFunc(s.handleReadinessRequest))
+	return s
 }
 
 // ListenAndServe returns an error channel, which includes the errors returned from webhook server.
 // It start both health check and webhook server, and both servers will close whenever the context receives a Done signal.
-// Whenever the server closed, the webhook server will shutdown after a defined duration (cfg.ProbeWaitTime), while the health check server will shutdown immediately.
+// Whenever the context receives a Done signal, the readiness probe fails immediately, the webhook server will shutdown after a defined duration (cfg.ProbeWaitTime), and then the health check server will shutdown.
 func (s *server) ListenAndServe(ctx context.Context) chan []error {
 	echan := make(chan []error, 1)
 	// error channels to keep track server status
@@ -207,17 +227,10 @@ func (s *server) ListenAndServe(ctx context.Context) chan []error {
 		for {
 			select {
 			case <-ctx.Done(): // when context receives Done signal, closes running servers and returns any errors
+				// fail the readiness probe first, the webhook server keeps serving during config.ProbeWaitTime
+				atomic.StoreInt32(&s.shuttingDown, 1)
+
 				s.mu.RLock()
-				if s.hcrunning {
-					err := glg.Info("garm health check server will shutdown")
-					if err != nil {
-						errs = appendErr(errs, errors.Wrap(err, "garm health check server shutdown message output failed"))
-					}
-					err = s.hcShutdown(context.Background())
-					if err != nil {
-						errs = appendErr(errs, errors.Wrap(err, "garm health check server shutdown failed"))
-					}
-				}
 				if s.srvRunning {
 					err := glg.Info("garm api server will shutdown")
 					if err != nil {
@@ -228,6 +241,16 @@ func (s *server) ListenAndServe(ctx context.Context) chan []error {
 						errs = appendErr(errs, errors.Wrap(err, "garm api server shutdown failed"))
 					}
 				}
+				if s.hcrunning {
+					err := glg.Info("garm health check server will shutdown")
+					if err != nil {
+						errs = appendErr(errs, errors.Wrap(err, "garm health check server shutdown message output failed"))
+					}
+					err = s.hcShutdown(context.Background())
+					if err != nil {
+						errs = appendErr(errs, errors.Wrap(err, "garm health check server shutdown failed"))
+					}
+				}
 				s.mu.RUnlock()
 
 				echan <- appendErr(errs, ctx.Err())
@@ -296,10 +319,13 @@ func (s *server) apiShutdown(ctx context.Context) error {
 }
 
 // createHealthCheckServiceMux returns a *http.ServeMux object.
-// It registers the health check server handler to given pattern.
-func createHealthCheckServiceMux(pattern string) *http.ServeMux {
+// It registers the health check and liveness probe handler, the given readiness probe handler, and the Prometheus metrics handler to the paths in cfg.
+func createHealthCheckServiceMux(cfg config.Server, readiness http.Handler) *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc(pattern, handleHealthCheckRequest)
+	mux.HandleFunc(cfg.HealthzPath, handleHealthCheckRequest)
+	mux.HandleFunc(cfg.GetLivenessPath(), handleHealthCheckRequest)
+	mux.Handle(cfg.GetReadinessPath(), readiness)
+	mux.Handle(cfg.GetMetricsPath(), metrics.Handler())
 	return mux
 }
 
@@ -318,6 +344,45 @@ func handleHealthCheckRequest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleReadinessRequest is a handler function for readiness probe requests.
+// It responses HTTP Status OK (200) if ready, otherwise responses HTTP Status Service Unavailable (503) with the reasons, one reason per line.
+func (s *server) handleReadinessRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		return
+	}
+
+	code := http.StatusOK
+	body := http.StatusText(http.StatusOK)
+	if reasons := s.notReadyReasons(); len(reasons) != 0 {
+		code = http.StatusServiceUnavailable
+		body = strings.Join(reasons, "\n")
+	}
+
+	w.Header().Set(ContentType, fmt.Sprintf("%s;%s", TextPlain, CharsetUTF8))
+	w.WriteHeader(code)
+	_, err := fmt.Fprint(w, body)
+	if err != nil {
+		err = glg.Error(errors.Wrap(err, "readiness response failed"))
+		if err != nil {
+			glg.Fatal(errors.Wrap(err, "error log output failed"))
+		}
+	}
+}
+
+// notReadyReasons returns the reasons why the server is not ready, or empty if ready.
+func (s *server) notReadyReasons() []string {
+	if atomic.LoadInt32(&s.shuttingDown) != 0 {
+		return []string{"shutting down"}
+	}
+	var reasons []string
+	for _, c := range s.checks {
+		if err := c.Ready(); err != nil {
+			reasons = append(reasons, err.Error())
+		}
+	}
+	return reasons
+}
+
 // listenAndServeAPI returns any errors on starting the HTTPS server, including any errors on loading TLS certificate.
 func (s *server) listenAndServeAPI() error {
 	if !s.cfg.TLS.Enabled {
@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -632,7 +633,8 @@ func Test_server_apiShutdown(t *testing.T) {
 
 func Test_server_createHealthCheckServiceMux(t *testing.T) {
 	type args struct {
-		pattern string
+		cfg       config.Server
+		readiness http.Handler
 	}
 	type test struct {
 		name       string
@@ -646,7 +648,10 @@ func Test_server_createHealthCheckServiceMux(t *testing.T) {
 			return test{
 				name: "Test create server mux",
 				args: args{
-					pattern: ":8080",
+					cfg: config.Server{
+						HealthzPath: ":8080",
+					},
+					readiness: http.NotFoundHandler(),
 				},
 				checkFunc: func(got *http.ServeMux) error {
 					if got == nil {
@@ -656,6 +661,41 @@ func Test_server_createHealthCheckServiceMux(t *testing.T) {
 				},
 			}
 		}(),
+		func() test {
+			return test{
+				name: "Test server mux serves health check, probes and metrics",
+				args: args{
+					cfg: config.Server{
+						HealthzPath: "/healthz",
+					},
+					readiness: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						w.WriteHeader(http.StatusServiceUnavailable)
+					}),
+				},
+				checkFunc: func(got *http.ServeMux) error {
+					for _, path := range []string{"/healthz", "/livez"} {
+						rec := httptest.NewRecorder()
+						got.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+						if rec.Code != http.StatusOK || rec.Body.String() != http.StatusText(http.StatusOK) {
+							return fmt.Errorf("%s response = %d %v, want %d %v", path, rec.Code, rec.Body.String(), http.StatusOK, http.StatusText(http.StatusOK))
+						}
+					}
+
+					rec := httptest.NewRecorder()
+					got.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+					if rec.Code != http.StatusServiceUnavailable {
+						return fmt.Errorf("readiness response = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+					}
+
+					rec = httptest.NewRecorder()
+					got.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+					if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "garm_token_age_seconds") {
+						return fmt.Errorf("metrics response = %d %v, want %d with garm metrics", rec.Code, rec.Body.String(), http.StatusOK)
+					}
+					return nil
+				},
+			}
+		}(),
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -676,7 +716,7 @@ func Test_server_createHealthCheckServiceMux(t *testing.T) {
 				}
 			}
 
-			got := createHealthCheckServiceMux(tt.args.pattern)
+			got := createHealthCheckServiceMux(tt.args.cfg, tt.args.readiness)
 			if err := tt.checkFunc(got); err != nil {
 				t.Errorf("server.listenAndServeAPI() Error = %v", err)
 			}
@@ -684,6 +724,74 @@ func Test_server_createHealthCheckServiceMux(t *testing.T) {
 	}
 }
 
+// readinessFunc is a ReadinessChecker implementation by function.
+type readinessFunc func() error
+
+// Ready returns the result of the function.
+func (f readinessFunc) Ready() error {
+	return f()
+}
+
+func Test_server_handleReadinessRequest(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		checks       []ReadinessChecker
+		shuttingDown int32
+		wantCode     int
+		wantBody     string
+	}{
+		{
+			name:   "Test ready",
+			method: http.MethodGet,
+			checks: []ReadinessChecker{
+				readinessFunc(func() error { return nil }),
+			},
+			wantCode: http.StatusOK,
+			wantBody: http.StatusText(http.StatusOK),
+		},
+		{
+			name:   "Test not ready reports every reason",
+			method: http.MethodGet,
+			checks: []ReadinessChecker{
+				readinessFunc(func() error { return ErrTokenNotFound }),
+				readinessFunc(func() error { return nil }),
+				readinessFunc(func() error { return ErrAthenzNotProbed }),
+			},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: ErrTokenNotFound.Error() + "\n" + ErrAthenzNotProbed.Error(),
+		},
+		{
+			name:   "Test not ready when shutting down",
+			method: http.MethodGet,
+			checks: []ReadinessChecker{
+				readinessFunc(func() error { return nil }),
+			},
+			shuttingDown: 1,
+			wantCode:     http.StatusServiceUnavailable,
+			wantBody:     "shutting down",
+		},
+		{
+			name:     "Test ignore non GET request",
+			method:   http.MethodPost,
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{
+				checks:       tt.checks,
+				shuttingDown: tt.shuttingDown,
+			}
+			rec := httptest.NewRecorder()
+			s.handleReadinessRequest(rec, httptest.NewRequest(tt.method, "/readyz", nil))
+			if rec.Code != tt.wantCode || rec.Body.String() != tt.wantBody {
+				t.Errorf("handleReadinessRequest() = %d %v, want %d %v", rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
+
 func Test_server_handleHealthCheckRequest(t *testing.T) {
 	type args struct {
 		rw http.ResponseWriter
@@ -29,16 +29,20 @@ import (
 	"github.com/pkg/errors"
 	webhook "github.com/yahoo/k8s-athenz-webhook"
 	"github.com/yahoojapan/garm/config"
+	"github.com/yahoojapan/garm/metrics"
 )
 
 // TokenService represents an interface for user to get the token, and automatically update the token.
 type TokenService interface {
 	StartTokenUpdater(context.Context) TokenService
 	GetToken() (string, error)
+	Ready() error
 	createTokenBuilder(string, string, string, []byte) (TokenService, error)
 }
 
 type token struct {
+	// refreshedAt is the last successful update time in Unix nanoseconds, the first field for 64-bit alignment of atomic operations.
+	refreshedAt     int64
 	tokenFilePath   string
 	token           *atomic.Value
 	validateToken   bool
@@ -126,6 +130,19 @@ func (t *token) GetToken() (string, error) {
 	return tok.(string), nil
 }
 
+// Ready returns nil if the token is loaded and its last update is within the token expiration, otherwise returns the reason.
+func (t *token) Ready() error {
+	_, err := t.GetToken()
+	if err != nil {
+		return err
+	}
+	last := time.Unix(0, atomic.LoadInt64(&t.refreshedAt))
+	if t.tokenExpiration > 0 && time.Since(last) > t.tokenExpiration {
+		return errors.Errorf("token last updated at %s, older than the expiration %s", last.Format(time.RFC3339), t.tokenExpiration)
+	}
+	return nil
+}
+
 // createTokenBuilder returns a TokenService or error.
 // It initializes a token builder with Athenz domain, service name, key version and the signature private key
 // , then returns a TokenService containing the token builder.
@@ -184,10 +201,12 @@ func (t *token) loadToken() (ntoken string, err error) {
 // It generates a token from loadToken() function, and stores into memory, and returns if any errors occurred.
 func (t *token) update() error {
 	token, err := t.loadToken()
+	metrics.ObserveTokenRefresh(err)
 	if err != nil {
 		return errors.Wrap(err, "loadToken failed")
 	}
 	t.setToken(token)
+	atomic.StoreInt64(&t.refreshedAt, time.Now().UnixNano())
 	return nil
 }
 
@@ -524,6 +524,62 @@ func Test_token_GetToken(t *testing.T) {
 	}
 }
 
+func Test_token_Ready(t *testing.T) {
+	tests := []struct {
+		name    string
+		tok     func() *token
+		wantErr error
+	}{
+		{
+			name: "Check not ready before the first update",
+			tok: func() *token {
+				return &token{
+					token:           new(atomic.Value),
+					tokenExpiration: time.Minute,
+				}
+			},
+			wantErr: ErrTokenNotFound,
+		},
+		{
+			name: "Check ready after update",
+			tok: func() *token {
+				t := &token{
+					token:           new(atomic.Value),
+					tokenExpiration: time.Minute,
+				}
+				t.setToken("dummy")
+				t.refreshedAt = time.Now().UnixNano()
+				return t
+			},
+		},
+		{
+			name: "Check not ready if the last update is older than the expiration",
+			tok: func() *token {
+				t := &token{
+					token:           new(atomic.Value),
+					tokenExpiration: time.Minute,
+				}
+				t.setToken("dummy")
+				t.refreshedAt = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+				return t
+			},
+			wantErr: fmt.Errorf("token last updated at %s, older than the expiration 1m0s", time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC).Local().Format(time.RFC3339)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tok().Ready()
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("Ready() unexpected error: %v", err)
+				return
+			}
+			if tt.wantErr != nil && (err == nil || err.Error() != tt.wantErr.Error()) {
+				t.Errorf("Ready() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_token_createTokenBuilder(t *testing.T) {
 	type args struct {
 		athenzDomain string
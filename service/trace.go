@@ -0,0 +1,144 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"net/http"
+	"strings"
+	"time"
+
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+	authz "k8s.io/api/authorization/v1beta1"
+)
+
+const (
+	// ruleWhiteList represents the request matches white_list.
+	ruleWhiteList = "white_list"
+	// ruleBlackList represents the request matches black_list and is directly rejected.
+	ruleBlackList = "black_list"
+	// ruleAdminAccessList represents the request matches admin_access_list and is checked against the admin domain.
+	ruleAdminAccessList = "admin_access_list"
+	// ruleDefaultAction represents the request matches none of the ordered rules, and is decided by default_action.
+	ruleDefaultAction = "default_action"
+)
+
+// traceKey is the context key of trace.
+type traceKey struct{}
+
+// trace records the details of a webhook request decided by the mappers.
+// It is shared between the mappers and the Athenz HTTP handler wrappers through the request context.
+type trace struct {
+	mapping
+	// requestID is the ID generated by Garm for the request. The webhook library and the Garm handlers log the request with the same ID (see requestHandler).
+	requestID string
+	// mappedAt is the time when the mapping finished.
+	mappedAt time.Time
+	// cached is true if the decision is answered from the decision cache without querying Athenz.
+	cached bool
+	// local is true if the decision is answered from the Athenz policies evaluated locally without querying Athenz.
+	local bool
+	// request is the HTTP request attributes selecting the mapping profile.
+	request profileRequest
+	// profile is the name of the selected mapping profile, empty for the default profile.
+	profile string
+	// resource is the result of mapping the SubjectAccessReview, shared by the authorizers handling the request. It is nil before mapping.
+	resource *resourceResult
+}
+
+// profileRequest represents the HTTP request attributes selecting the mapping profile.
+type profileRequest struct {
+	// name is the profile name in the URL path, e.g. "a" of "/authz/a".
+	name string
+	// header is the HTTP request header.
+	header http.Header
+}
+
+// newProfileRequest returns the profileRequest of the HTTP request to the endpoint, e.g. "authz".
+func newProfileRequest(r *http.Request, endpoint string) profileRequest {
+	return profileRequest{
+		name:   strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+endpoint), "/"),
+		header: r.Header,
+	}
+}
+
+// mapping is the mapping result of a webhook request.
+type mapping struct {
+	// spec is the SubjectAccessReview spec.
+	spec authz.SubjectAccessReviewSpec
+	// identity is the Athenz principal.
+	identity string
+	// action is the action applied to the request, e.g. config.ActionDeny.
+	action string
+	// rules are the matched rules.
+	rules []string
+	// checks are the Athenz access checks created.
+	checks []webhook.AthenzAccessCheck
+}
+
+// resourceResult is the result of mapping the SubjectAccessReview of a request.
+type resourceResult struct {
+	principal string
+	checks    []webhook.AthenzAccessCheck
+	err       error
+}
+
+// withTrace returns a copy of ctx carrying a new trace, and the trace.
+func withTrace(ctx context.Context) (context.Context, *trace) {
+	t := &trace{
+		requestID: newRequestID(),
+	}
+	return context.WithValue(ctx, traceKey{}, t), t
+}
+
+// traceFrom returns the trace in ctx, or nil if ctx is nil or does not carry any trace.
+func traceFrom(ctx context.Context) *trace {
+	if ctx == nil {
+		return nil
+	}
+	t, _ := ctx.Value(traceKey{}).(*trace)
+	return t
+}
+
+// requestIDFrom returns the request ID of the trace in ctx, or a new request ID if ctx does not carry any trace.
+func requestIDFrom(ctx context.Context) string {
+	if t := traceFrom(ctx); t != nil {
+		return t.requestID
+	}
+	return newRequestID()
+}
+
+// mapped records the mapping result and finish time. It does nothing on a nil trace.
+func (t *trace) mapped(m mapping) {
+	if t == nil {
+		return
+	}
+	t.mappedAt = time.Now()
+	t.mapping = m
+}
+
+// newRequestID returns a random ID of 8 lower case characters.
+func newRequestID() string {
+	b := make([]byte, 5)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "unknown"
+	}
+	return strings.ToLower(base32.StdEncoding.EncodeToString(b))
+}
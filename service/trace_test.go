@@ -0,0 +1,129 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	webhook "github.com/yahoo/k8s-athenz-webhook"
+)
+
+func Test_withTrace(t *testing.T) {
+	ctx, want := withTrace(context.Background())
+	if want == nil {
+		t.Fatal("withTrace() returns nil trace")
+	}
+	if want.requestID == "" {
+		t.Error("withTrace() returns trace without request ID")
+	}
+	if got := traceFrom(ctx); got != want {
+		t.Errorf("traceFrom() = %p, want %p", got, want)
+	}
+}
+
+func Test_traceFrom(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+	}{
+		{
+			name: "Check nil context",
+			ctx:  nil,
+		},
+		{
+			name: "Check context without trace",
+			ctx:  context.Background(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := traceFrom(tt.ctx); got != nil {
+				t.Errorf("traceFrom() = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func Test_trace_mapped(t *testing.T) {
+	// nil trace is ignored
+	var nt *trace
+	nt.mapped(mapping{identity: "nil"})
+
+	tr := new(trace)
+	tr.mapped(mapping{
+		identity: "user.name",
+		rules:    []string{ruleAdminAccessList},
+		checks:   make([]webhook.AthenzAccessCheck, 2),
+	})
+	if tr.mappedAt.IsZero() || tr.identity != "user.name" || len(tr.checks) != 2 {
+		t.Errorf("mapped() trace = %+v, want user.name with 2 checks", tr)
+	}
+}
+
+func Test_newRequestID(t *testing.T) {
+	got := newRequestID()
+	if len(got) != 8 || got != strings.ToLower(got) {
+		t.Errorf("newRequestID() = %v, want 8 lower case characters", got)
+	}
+	if got == newRequestID() {
+		t.Errorf("newRequestID() returns the same ID %v", got)
+	}
+}
+
+func Test_newProfileRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		endpoint string
+		want     string
+	}{
+		{
+			name:     "Check endpoint without profile",
+			target:   "/authz",
+			endpoint: "authz",
+			want:     "",
+		},
+		{
+			name:     "Check endpoint with profile",
+			target:   "/authz/cluster-a",
+			endpoint: "authz",
+			want:     "cluster-a",
+		},
+		{
+			name:     "Check endpoint with empty profile",
+			target:   "/authn/",
+			endpoint: "authn",
+			want:     "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tt.target, nil)
+			r.Header.Set("X-Garm-Profile", "header")
+			got := newProfileRequest(r, tt.endpoint)
+			if got.name != tt.want {
+				t.Errorf("newProfileRequest() name = %q, want %q", got.name, tt.want)
+			}
+			if got.header.Get("X-Garm-Profile") != "header" {
+				t.Errorf("newProfileRequest() header = %v, want the request header", got.header)
+			}
+		})
+	}
+}
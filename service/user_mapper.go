@@ -30,8 +30,7 @@ type UserMapper interface {
 }
 
 type userMapper struct {
-	res    Resolver
-	groups []string
+	res Resolver
 }
 
 // NewUserMapper returns a UserMapper instance with given Resolver.
@@ -42,12 +41,26 @@ func NewUserMapper(resolver Resolver) UserMapper {
 }
 
 // MapUser returns UserInfo.
-// UserInfo contains the username, UID and groups of the user.
+// UserInfo contains the username, UID, groups and extra fields of the user.
+// The username, UID and extra fields are created from the user mapping templates of the Resolver.
+// The groups are assigned by the group mappings of the Resolver.
 func (u *userMapper) MapUser(ctx context.Context, domain, service string) (authn.UserInfo, error) {
 	principal := fmt.Sprintf("%s.%s", domain, service)
-	return authn.UserInfo{
-		Username: principal,
-		UID:      principal,
-		Groups:   u.groups,
-	}, nil
+	traceFrom(ctx).mapped(mapping{
+		identity: principal,
+	})
+
+	username, uid, extra := u.res.MapUserInfo(domain, service)
+	info := authn.UserInfo{
+		Username: username,
+		UID:      uid,
+		Groups:   u.res.MapGroups(domain, service),
+	}
+	if len(extra) != 0 {
+		info.Extra = make(map[string]authn.ExtraValue, len(extra))
+		for k, v := range extra {
+			info.Extra[k] = authn.ExtraValue(v)
+		}
+	}
+	return info, nil
 }
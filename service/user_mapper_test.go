@@ -18,9 +18,11 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"reflect"
 	"testing"
 
+	"github.com/yahoojapan/garm/config"
 	authn "k8s.io/api/authentication/v1beta1"
 )
 
@@ -54,8 +56,7 @@ func TestNewUserMapper(t *testing.T) {
 
 func Test_userMapper_MapUser(t *testing.T) {
 	type fields struct {
-		res    Resolver
-		groups []string
+		res Resolver
 	}
 	type args struct {
 		ctx     context.Context
@@ -73,8 +74,7 @@ func Test_userMapper_MapUser(t *testing.T) {
 		{
 			name: "Test UserInfo return",
 			fields: fields{
-				res:    nil,
-				groups: nil,
+				res: &resolve{},
 			},
 			args: args{
 				ctx:     nil,
@@ -87,12 +87,74 @@ func Test_userMapper_MapUser(t *testing.T) {
 				Groups:   nil,
 			},
 		},
+		{
+			name: "Test UserInfo return with mapped groups",
+			fields: fields{
+				res: &resolve{
+					cfg: config.Platform{
+						GroupMappings: []*config.GroupMapping{
+							{
+								Groups: []string{"athenz:_domain_"},
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				ctx:     context.Background(),
+				domain:  "testdomain",
+				service: "testservice",
+			},
+			checkFunc: func(got, want authn.UserInfo) error {
+				if !reflect.DeepEqual(got, want) {
+					return fmt.Errorf("MapUser() = %v, want %v", got, want)
+				}
+				return nil
+			},
+			want: authn.UserInfo{
+				Username: "testdomain.testservice",
+				UID:      "testdomain.testservice",
+				Groups:   []string{"athenz:testdomain"},
+			},
+		},
+		{
+			name: "Test UserInfo return with user mapping templates",
+			fields: fields{
+				res: &resolve{
+					cfg: config.Platform{
+						UserMapping: config.UserMapping{
+							Username: "athenz:_domain_:_service_",
+							Extra: map[string][]string{
+								"athenz-principal": {"_principal_"},
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				ctx:     context.Background(),
+				domain:  "testdomain",
+				service: "testservice",
+			},
+			checkFunc: func(got, want authn.UserInfo) error {
+				if !reflect.DeepEqual(got, want) {
+					return fmt.Errorf("MapUser() = %v, want %v", got, want)
+				}
+				return nil
+			},
+			want: authn.UserInfo{
+				Username: "athenz:testdomain:testservice",
+				UID:      "testdomain.testservice",
+				Extra: map[string]authn.ExtraValue{
+					"athenz-principal": {"testdomain.testservice"},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			u := &userMapper{
-				res:    tt.fields.res,
-				groups: tt.fields.groups,
+				res: tt.fields.res,
 			}
 			got, err := u.MapUser(tt.args.ctx, tt.args.domain, tt.args.service)
 			if tt.wantErr == nil && err != nil {
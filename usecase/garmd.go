@@ -19,7 +19,9 @@ package usecase
 import (
 	"context"
 
+	"github.com/kpango/glg"
 	"github.com/pkg/errors"
+	"github.com/yahoojapan/garm/audit"
 	"github.com/yahoojapan/garm/config"
 	"github.com/yahoojapan/garm/handler"
 	"github.com/yahoojapan/garm/router"
@@ -32,44 +34,107 @@ type GarmDaemon interface {
 }
 
 type garm struct {
-	cfg    config.Config
-	token  service.TokenService
-	athenz service.Athenz
-	server service.Server
+	cfg      config.Config
+	token    service.TokenService
+	athenz   service.Athenz
+	server   service.Server
+	watcher  service.ConfigWatcher
+	probe    service.AthenzProbe
+	policies service.PolicyStore
 }
 
 // New returns a Garm daemon, or error occurred.
 // The daemon contains a token service authentication and authorization server.
 // This function will also initialize the mapping rules for the authentication and authorization check.
+// If cfg.Audit.Enabled is true, an audit record is written for every webhook request.
+// If cfg.Reload.Enabled is true, the mapping rules will be reloaded when the configuration file changes.
+// If cfg.Athenz.Policy.Enabled is true, the Athenz policies are fetched periodically and evaluated locally.
+// The readiness probe requires the token, and also the Athenz server if cfg.Server.Readiness.AthenzProbe is true.
 func New(cfg config.Config) (GarmDaemon, error) {
 	token, err := service.NewTokenService(cfg.Token)
 	if err != nil {
 		return nil, errors.Wrap(err, "token service instantiate failed")
 	}
 
-	resolver := service.NewResolver(cfg.Mapping)
 	// set up mapper
-	cfg.Athenz.AuthZ.Mapper = service.NewResourceMapper(resolver)
-	cfg.Athenz.AuthN.Mapper = service.NewUserMapper(resolver)
+	mapper, err := service.NewMapper(cfg.Mapping)
+	if err != nil {
+		return nil, errors.Wrap(err, "mapper instantiate failed")
+	}
+	for _, w := range cfg.Mapping.Warnings() {
+		err = glg.Warn(w)
+		if err != nil {
+			return nil, errors.Wrap(err, "mapping warning output failed")
+		}
+	}
+
+	var watcher service.ConfigWatcher
+	if cfg.Reload.Enabled {
+		watcher, err = service.NewConfigWatcher(cfg, mapper)
+		if err != nil {
+			return nil, errors.Wrap(err, "config watcher instantiate failed")
+		}
+	}
+
+	cfg.Athenz.AuthZ.Mapper = mapper
+	cfg.Athenz.AuthN.Mapper = mapper
 
 	// set token source (function pointer)
 	cfg.Athenz.AuthZ.Token = token.GetToken
 
-	athenz, err := service.NewAthenz(cfg.Athenz, service.NewLogger(cfg.Logger))
+	var auditor audit.Auditor
+	if cfg.Audit.Enabled {
+		auditor, err = audit.New(cfg.Audit)
+		if err != nil {
+			return nil, errors.Wrap(err, "audit log instantiate failed")
+		}
+	}
+
+	var policies service.PolicyStore
+	if cfg.Athenz.Policy.Enabled {
+		policies, err = service.NewPolicyStore(cfg.Athenz)
+		if err != nil {
+			return nil, errors.Wrap(err, "policy store instantiate failed")
+		}
+	}
+
+	athenz, err := service.NewAthenz(cfg.Athenz, service.NewLogger(cfg.Logger), auditor, policies)
 	if err != nil {
 		return nil, errors.Wrap(err, "athenz service instantiate failed")
 	}
 
+	checks := []service.ReadinessChecker{token}
+	var probe service.AthenzProbe
+	if cfg.Server.Readiness.AthenzProbe {
+		probe, err = service.NewAthenzProbe(cfg.Athenz, cfg.Server.Readiness)
+		if err != nil {
+			return nil, errors.Wrap(err, "athenz probe instantiate failed")
+		}
+		checks = append(checks, probe)
+	}
+
 	return &garm{
-		cfg:    cfg,
-		token:  token,
-		athenz: athenz,
-		server: service.NewServer(cfg.Server, router.New(cfg.Server, handler.New(athenz))),
+		cfg:      cfg,
+		token:    token,
+		athenz:   athenz,
+		server:   service.NewServer(cfg.Server, router.New(cfg.Server, handler.New(athenz)), checks...),
+		watcher:  watcher,
+		probe:    probe,
+		policies: policies,
 	}, nil
 }
 
 // Start returns an error slice channel. This error channel reports the errors inside Garm server.
 func (g *garm) Start(ctx context.Context) chan []error {
 	g.token.StartTokenUpdater(ctx)
+	if g.watcher != nil {
+		g.watcher.StartConfigWatcher(ctx)
+	}
+	if g.probe != nil {
+		g.probe.StartAthenzProbe(ctx)
+	}
+	if g.policies != nil {
+		g.policies.StartPolicyUpdater(ctx)
+	}
 	return g.server.ListenAndServe(ctx)
 }
@@ -84,6 +84,109 @@ func TestNew(t *testing.T) {
 				wantErr: fmt.Errorf("athenz service instantiate failed: athenz timeout parse failed: time: invalid duration "),
 			}
 		}(),
+		func() test {
+			keyEnvName := "dummyKey"
+			key := "../service/testdata/dummyServer.key"
+
+			return test{
+				name: "Check error when new config watcher",
+				args: args{
+					cfg: config.Config{
+						Token: config.Token{
+							AthenzDomain:    keyEnvName,
+							ServiceName:     keyEnvName,
+							PrivateKey:      "_" + keyEnvName + "_",
+							ValidateToken:   false,
+							RefreshDuration: "1m",
+							KeyVersion:      "1",
+							Expiration:      "1m",
+						},
+						Reload: config.Reload{
+							Enabled:  true,
+							Interval: "1m",
+						},
+						FilePath: "./notexists.yaml",
+					},
+				},
+				beforeFunc: func() {
+					os.Setenv(keyEnvName, key)
+				},
+				afterFunc: func() {
+					os.Unsetenv(keyEnvName)
+				},
+				wantErr: fmt.Errorf("config watcher instantiate failed: config read failed: open ./notexists.yaml: no such file or directory"),
+			}
+		}(),
+		func() test {
+			keyEnvName := "dummyKey"
+			key := "../service/testdata/dummyServer.key"
+
+			return test{
+				name: "Check error when new audit log",
+				args: args{
+					cfg: config.Config{
+						Token: config.Token{
+							AthenzDomain:    keyEnvName,
+							ServiceName:     keyEnvName,
+							PrivateKey:      "_" + keyEnvName + "_",
+							ValidateToken:   false,
+							RefreshDuration: "1m",
+							KeyVersion:      "1",
+							Expiration:      "1m",
+						},
+						Audit: config.Audit{
+							Enabled: true,
+							Path:    "./notexists/audit.log",
+						},
+					},
+				},
+				beforeFunc: func() {
+					os.Setenv(keyEnvName, key)
+				},
+				afterFunc: func() {
+					os.Unsetenv(keyEnvName)
+				},
+				wantErr: fmt.Errorf("audit log instantiate failed: audit log open failed: file open failed: open ./notexists/audit.log: no such file or directory"),
+			}
+		}(),
+		func() test {
+			keyEnvName := "dummyKey"
+			key := "../service/testdata/dummyServer.key"
+
+			return test{
+				name: "Check error when new athenz probe",
+				args: args{
+					cfg: config.Config{
+						Token: config.Token{
+							AthenzDomain:    keyEnvName,
+							ServiceName:     keyEnvName,
+							PrivateKey:      "_" + keyEnvName + "_",
+							ValidateToken:   false,
+							RefreshDuration: "1m",
+							KeyVersion:      "1",
+							Expiration:      "1m",
+						},
+						Athenz: config.Athenz{
+							Timeout:      "1m",
+							AthenzRootCA: "./notexists.pem",
+						},
+						Server: config.Server{
+							Readiness: config.Readiness{
+								AthenzProbe:         true,
+								AthenzProbeInterval: "1m",
+							},
+						},
+					},
+				},
+				beforeFunc: func() {
+					os.Setenv(keyEnvName, key)
+				},
+				afterFunc: func() {
+					os.Unsetenv(keyEnvName)
+				},
+				wantErr: fmt.Errorf("athenz probe instantiate failed: athenz probe x509 certpool error: failed to read pem file: open ./notexists.pem: no such file or directory"),
+			}
+		}(),
 		func() test {
 			keyEnvName := "dummyKey"
 			key := "../service/testdata/dummyServer.key"
@@ -126,11 +229,11 @@ func TestNew(t *testing.T) {
 						t.Errorf("fsdf %v", err)
 					}
 
-					resolver := service.NewResolver(cfg.Mapping)
-					cfg.Athenz.AuthZ.Mapper = service.NewResourceMapper(resolver)
-					cfg.Athenz.AuthN.Mapper = service.NewUserMapper(resolver)
+					mapper, _ := service.NewMapper(cfg.Mapping)
+					cfg.Athenz.AuthZ.Mapper = mapper
+					cfg.Athenz.AuthN.Mapper = mapper
 					cfg.Athenz.AuthZ.Token = token.GetToken
-					athenz, _ := service.NewAthenz(cfg.Athenz, service.NewLogger(cfg.Logger))
+					athenz, _ := service.NewAthenz(cfg.Athenz, service.NewLogger(cfg.Logger), nil, nil)
 
 					server := service.NewServer(cfg.Server, router.New(cfg.Server, handler.New(athenz)))
 					return &garm{
@@ -236,11 +339,11 @@ func Test_garm_Start(t *testing.T) {
 				fields: func() fields {
 					token, _ := service.NewTokenService(cfg.Token)
 
-					resolver := service.NewResolver(cfg.Mapping)
-					cfg.Athenz.AuthZ.Mapper = service.NewResourceMapper(resolver)
-					cfg.Athenz.AuthN.Mapper = service.NewUserMapper(resolver)
+					mapper, _ := service.NewMapper(cfg.Mapping)
+					cfg.Athenz.AuthZ.Mapper = mapper
+					cfg.Athenz.AuthN.Mapper = mapper
 					cfg.Athenz.AuthZ.Token = token.GetToken
-					athenz, _ := service.NewAthenz(cfg.Athenz, service.NewLogger(cfg.Logger))
+					athenz, _ := service.NewAthenz(cfg.Athenz, service.NewLogger(cfg.Logger), nil, nil)
 
 					server := service.NewServer(cfg.Server, router.New(cfg.Server, handler.New(athenz)))
 					return fields{
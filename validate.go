@@ -0,0 +1,53 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/yahoojapan/garm/config"
+	"github.com/yahoojapan/garm/service"
+)
+
+const (
+	// validateCommand is the sub-command name to validate the configuration file.
+	validateCommand = "validate"
+)
+
+// validate reads the configuration file in path, and writes the validation result to w.
+// It returns an error containing every problem if the configuration is invalid, or the platform is unknown.
+// The mapping rules which may not work as intended are written to w as warnings (see config.Mapping.Warnings).
+func validate(w io.Writer, path string) error {
+	cfg, err := config.New(path)
+	if err != nil {
+		return err
+	}
+	err = cfg.Validate()
+	if err != nil {
+		return err
+	}
+	_, err = service.NewResolver(cfg.Mapping)
+	if err != nil {
+		return err
+	}
+	for _, warning := range cfg.Mapping.Warnings() {
+		fmt.Fprintf(w, "%s: warning: %s\n", path, warning)
+	}
+	fmt.Fprintf(w, "%s: OK\n", path)
+	return nil
+}
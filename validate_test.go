@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func Test_validate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := ioutil.ReadFile("./config/testdata/valid_config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknownPlatform := filepath.Join(dir, "unknown_platform.yaml")
+	err = ioutil.WriteFile(unknownPlatform, []byte(strings.Replace(string(b), "      name: k8s", "      name: unknown", 1)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		path string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr error
+	}{
+		{
+			name: "validate file not exists",
+			args: args{
+				path: "./notexists.yaml",
+			},
+			wantErr: errors.New("config read failed: open ./notexists.yaml: no such file or directory"),
+		},
+		{
+			name: "validate valid config",
+			args: args{
+				path: "./config/testdata/valid_config.yaml",
+			},
+			want: "./config/testdata/valid_config.yaml: warning: map_rule.tld.platform.black_list[0].namespace: \"kube-system\" matches only the whole value, but also matched \"kube-system-x\" with legacy_list_match\n" +
+				"./config/testdata/valid_config.yaml: OK\n",
+		},
+		{
+			name: "validate unknown platform",
+			args: args{
+				path: unknownPlatform,
+			},
+			wantErr: errors.New(`unknown platform "unknown", registered platforms: aks, eks, k8s`),
+		},
+		{
+			name: "validate invalid config",
+			args: args{
+				path: "./config/testdata/example_config.yaml",
+			},
+			wantErr: errors.New("invalid config:\n" +
+				"\tserver.tls.cert: environment variable cert for \"_cert_\" is not set\n" +
+				"\tserver.tls.key: environment variable key for \"_key_\" is not set\n" +
+				"\tserver.tls.ca: environment variable ca for \"_ca_\" is not set\n" +
+				"\tathenz.root_ca: environment variable root_ca for \"_root_ca_\" is not set\n" +
+				"\ttoken.athenz_domain: environment variable athenz_domain for \"_athenz_domain_\" is not set\n" +
+				"\ttoken.service_name: environment variable athenz_service for \"_athenz_service_\" is not set\n" +
+				"\ttoken.private_key: environment variable athenz_private_key for \"_athenz_private_key_\" is not set\n" +
+				"\tmap_rule.tld.platform.service_athenz_domains[0]: environment variable kaas_namespace for \"_kaas_namespace_\" is not set\n" +
+				"\tmap_rule.tld.platform.service_athenz_domains[1]: environment variable kaas_namespace for \"_kaas_namespace_\" is not set\n" +
+				"\tmap_rule.tld.platform.athenz_service_account_prefix: environment variable kaas_namespace for \"_kaas_namespace_\" is not set"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := new(bytes.Buffer)
+			err := validate(w, tt.args.path)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("validate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("validate() unexpected error: %v", err)
+				return
+			}
+			if got := w.String(); got != tt.want {
+				t.Errorf("validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}